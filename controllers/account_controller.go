@@ -0,0 +1,188 @@
+package controllers
+
+import (
+	"archive/zip"
+	"encoding/json"
+	"fmt"
+	"html"
+	"io"
+	"net/http"
+	"os"
+	"path/filepath"
+	"time"
+
+	"github.com/gin-gonic/gin"
+	"github.com/pramek008/go-jwt-project/database"
+	"github.com/pramek008/go-jwt-project/logger"
+	"github.com/pramek008/go-jwt-project/models"
+	"github.com/pramek008/go-jwt-project/utils"
+)
+
+// DeleteAccount schedules the account for deletion after the grace period
+// and logs it out everywhere. Logging in again before then restores it.
+func DeleteAccount(c *gin.Context) {
+	var request struct {
+		Password string `json:"password" binding:"required"`
+	}
+
+	if err := c.ShouldBindJSON(&request); err != nil {
+		utils.SendErrorResponse(c, http.StatusBadRequest, "Invalid request data")
+		return
+	}
+
+	userID, _ := c.Get("user_id")
+
+	var user models.User
+	if err := database.DB.Db.First(&user, "id = ?", userID).Error; err != nil {
+		utils.SendErrorResponse(c, http.StatusNotFound, "User not found")
+		return
+	}
+
+	if err := utils.VerifyPassword(user.Password, request.Password); err != nil {
+		utils.SendErrorResponse(c, http.StatusUnauthorized, "Password is incorrect")
+		return
+	}
+
+	purgeAt, err := utils.ScheduleAccountDeletion(user.ID)
+	if err != nil {
+		utils.SendErrorResponse(c, http.StatusInternalServerError, "Failed to delete account")
+		return
+	}
+
+	subject := "Your account will be deleted"
+	body := fmt.Sprintf("<h1>Account deletion scheduled</h1><p>Hi %s, your account and its data will be permanently deleted on %s.</p><p>Changed your mind? Just log in again before then and the account will be restored.</p>",
+		html.EscapeString(user.Nickname), purgeAt.Format(time.RFC1123))
+	if err := utils.SendEmail(user.Email, subject, body); err != nil {
+		logger.FromContext(c).Error("Failed to send account deletion email", "user_id", user.ID, "error", err)
+	}
+
+	utils.SendResponse(c, http.StatusOK, true, "Account scheduled for deletion, log in before the deletion date to restore it", gin.H{
+		"deletionScheduledAt": purgeAt,
+	})
+}
+
+// ExportAccount streams a ZIP with everything stored about the user: the
+// profile, sessions, login history, posts and the files uploaded with them.
+func ExportAccount(c *gin.Context) {
+	userID, _ := c.Get("user_id")
+
+	var user models.User
+	if err := database.DB.Db.Preload("Roles").First(&user, "id = ?", userID).Error; err != nil {
+		utils.SendErrorResponse(c, http.StatusNotFound, "User not found")
+		return
+	}
+
+	var posts []models.Post
+	if err := database.DB.Db.Where("user_id = ?", user.ID).Order("created_at").Find(&posts).Error; err != nil {
+		utils.SendErrorResponse(c, http.StatusInternalServerError, "Failed to fetch posts")
+		return
+	}
+
+	var sessions []models.Session
+	if err := database.DB.Db.Where("user_id = ?", user.ID).Order("created_at").Find(&sessions).Error; err != nil {
+		utils.SendErrorResponse(c, http.StatusInternalServerError, "Failed to fetch sessions")
+		return
+	}
+
+	var logins []models.LoginEvent
+	if err := database.DB.Db.Where("user_id = ?", user.ID).Order("created_at").Find(&logins).Error; err != nil {
+		utils.SendErrorResponse(c, http.StatusInternalServerError, "Failed to fetch login history")
+		return
+	}
+
+	var identities []models.UserIdentity
+	if err := database.DB.Db.Where("user_id = ?", user.ID).Order("created_at").Find(&identities).Error; err != nil {
+		utils.SendErrorResponse(c, http.StatusInternalServerError, "Failed to fetch identities")
+		return
+	}
+
+	roles := make([]string, 0, len(user.Roles))
+	for _, role := range user.Roles {
+		roles = append(roles, role.Name)
+	}
+
+	profile := gin.H{
+		"id":               user.ID,
+		"nickname":         user.Nickname,
+		"email":            user.Email,
+		"roles":            roles,
+		"mfaEnabled":       utils.UserHasMFA(user.ID),
+		"createdAt":        user.CreatedAt,
+		"updatedAt":        user.UpdatedAt,
+		"exportedAt":       time.Now(),
+		"suspendedAt":      user.SuspendedAt,
+		"suspensionReason": user.SuspensionReason,
+	}
+
+	exportedPosts := make([]gin.H, 0, len(posts))
+	for _, post := range posts {
+		exportedPosts = append(exportedPosts, gin.H{
+			"id":        post.ID,
+			"title":     post.Title,
+			"content":   post.Content,
+			"fileUrl":   post.FileURL,
+			"createdAt": post.CreatedAt,
+			"updatedAt": post.UpdatedAt,
+		})
+	}
+
+	filename := fmt.Sprintf("%s-export-%s.zip", user.Nickname, time.Now().Format("20060102"))
+	c.Header("Content-Type", "application/zip")
+	c.Header("Content-Disposition", fmt.Sprintf("attachment; filename=%q", filename))
+	c.Status(http.StatusOK)
+
+	// Headers are sent from here on, so failures can only be logged
+	archive := zip.NewWriter(c.Writer)
+	defer archive.Close()
+
+	log := logger.FromContext(c)
+	for name, data := range map[string]interface{}{
+		"profile.json":    profile,
+		"posts.json":      exportedPosts,
+		"sessions.json":   sessions,
+		"logins.json":     logins,
+		"identities.json": identities,
+	} {
+		if err := writeZipJSON(archive, name, data); err != nil {
+			log.Error("Failed to write account export", "file", name, "error", err)
+			return
+		}
+	}
+
+	for _, post := range posts {
+		path, ok := utils.UploadPathFromURL(post.FileURL)
+		if !ok {
+			continue
+		}
+		if err := writeZipFile(archive, "uploads/"+filepath.Base(path), path); err != nil {
+			log.Warn("Failed to add upload to account export", "post_id", post.ID, "error", err)
+		}
+	}
+}
+
+func writeZipJSON(archive *zip.Writer, name string, data interface{}) error {
+	w, err := archive.Create(name)
+	if err != nil {
+		return err
+	}
+
+	encoder := json.NewEncoder(w)
+	encoder.SetIndent("", "  ")
+	return encoder.Encode(data)
+}
+
+func writeZipFile(archive *zip.Writer, name, path string) error {
+	file, err := os.Open(path)
+	if err != nil {
+		return err
+	}
+	defer file.Close()
+
+	w, err := archive.Create(name)
+	if err != nil {
+		return err
+	}
+
+	_, err = io.Copy(w, file)
+	return err
+}
@@ -0,0 +1,214 @@
+package controllers
+
+import (
+	"net/http"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/gin-gonic/gin"
+	"github.com/google/uuid"
+	"github.com/pramek008/go-jwt-project/database"
+	"github.com/pramek008/go-jwt-project/models"
+	"github.com/pramek008/go-jwt-project/utils"
+)
+
+// AdminListUsers lists users page by page, optionally filtered by q, which is
+// matched against email and nickname.
+func AdminListUsers(c *gin.Context) {
+	var users []models.User
+	var total int64
+
+	page, limit := paginationParams(c)
+	offset := (page - 1) * limit
+
+	query := database.DB.Db.Model(&models.User{})
+	if q := strings.TrimSpace(c.Query("q")); q != "" {
+		pattern := "%" + strings.ToLower(q) + "%"
+		query = query.Where("LOWER(email) LIKE ? OR LOWER(nickname) LIKE ?", pattern, pattern)
+	}
+
+	query.Count(&total)
+
+	if err := query.Preload("Roles").Order("created_at desc").Offset(offset).Limit(limit).Find(&users).Error; err != nil {
+		utils.SendErrorResponse(c, http.StatusInternalServerError, "Failed to fetch users")
+		return
+	}
+
+	utils.SendPaginatedResponse(c, http.StatusOK, true, "Users fetched successfully", users, int64(limit), int64(page), total)
+}
+
+func AdminGetUser(c *gin.Context) {
+	userID, ok := userIDParam(c)
+	if !ok {
+		return
+	}
+
+	var user models.User
+	if err := database.DB.Db.Preload("Roles").First(&user, "id = ?", userID).Error; err != nil {
+		utils.SendErrorResponse(c, http.StatusNotFound, "User not found")
+		return
+	}
+
+	utils.SendResponse(c, http.StatusOK, true, "User fetched successfully", user)
+}
+
+func AdminListUserSessions(c *gin.Context) {
+	userID, ok := userIDParam(c)
+	if !ok {
+		return
+	}
+
+	var sessions []models.Session
+	if err := database.DB.Db.Where("user_id = ? AND revoked_at IS NULL", userID).Order("last_seen_at desc").Find(&sessions).Error; err != nil {
+		utils.SendErrorResponse(c, http.StatusInternalServerError, "Failed to fetch sessions")
+		return
+	}
+
+	utils.SendResponse(c, http.StatusOK, true, "Sessions fetched successfully", sessions)
+}
+
+func AdminListUserPosts(c *gin.Context) {
+	userID, ok := userIDParam(c)
+	if !ok {
+		return
+	}
+
+	var posts []models.Post
+	var total int64
+
+	page, limit := paginationParams(c)
+	offset := (page - 1) * limit
+
+	query := database.DB.Db.Model(&models.Post{}).Where("user_id = ?", userID)
+	query.Count(&total)
+
+	if err := query.Order("created_at desc").Offset(offset).Limit(limit).Find(&posts).Error; err != nil {
+		utils.SendErrorResponse(c, http.StatusInternalServerError, "Failed to fetch posts")
+		return
+	}
+
+	utils.SendPaginatedResponse(c, http.StatusOK, true, "Posts fetched successfully", posts, int64(limit), int64(page), total)
+}
+
+// AdminSuspendUser suspends the user until the optional end date and logs
+// them out everywhere. Their tokens are rejected while the suspension lasts.
+func AdminSuspendUser(c *gin.Context) {
+	var request struct {
+		Reason string     `json:"reason" binding:"required,max=255"`
+		Until  *time.Time `json:"until"`
+	}
+
+	if err := c.ShouldBindJSON(&request); err != nil {
+		utils.SendErrorResponse(c, http.StatusBadRequest, err.Error())
+		return
+	}
+
+	if request.Until != nil && request.Until.Before(time.Now()) {
+		utils.SendErrorResponse(c, http.StatusBadRequest, "Suspension end date must be in the future")
+		return
+	}
+
+	userID, ok := userIDParam(c)
+	if !ok {
+		return
+	}
+
+	var user models.User
+	if err := database.DB.Db.First(&user, "id = ?", userID).Error; err != nil {
+		utils.SendErrorResponse(c, http.StatusNotFound, "User not found")
+		return
+	}
+
+	adminID, _ := c.Get("user_id")
+	if user.ID == adminID.(uuid.UUID) {
+		utils.SendErrorResponse(c, http.StatusBadRequest, "You cannot suspend yourself")
+		return
+	}
+
+	now := time.Now()
+	if err := database.DB.Db.Model(&user).Updates(map[string]interface{}{
+		"suspended_at":      now,
+		"suspended_until":   request.Until,
+		"suspension_reason": request.Reason,
+	}).Error; err != nil {
+		utils.SendErrorResponse(c, http.StatusInternalServerError, "Failed to suspend user")
+		return
+	}
+
+	if _, err := utils.RevokeAllSessions(user.ID); err != nil {
+		utils.SendErrorResponse(c, http.StatusInternalServerError, "User suspended but failed to revoke sessions")
+		return
+	}
+
+	utils.SendResponse(c, http.StatusOK, true, "User suspended successfully", gin.H{
+		"id":               user.ID,
+		"suspendedAt":      now,
+		"suspendedUntil":   request.Until,
+		"suspensionReason": request.Reason,
+	})
+}
+
+func AdminUnsuspendUser(c *gin.Context) {
+	userID, ok := userIDParam(c)
+	if !ok {
+		return
+	}
+
+	result := database.DB.Db.Model(&models.User{}).
+		Where("id = ?", userID).
+		Updates(map[string]interface{}{
+			"suspended_at":      nil,
+			"suspended_until":   nil,
+			"suspension_reason": "",
+		})
+	if result.Error != nil || result.RowsAffected == 0 {
+		utils.SendErrorResponse(c, http.StatusNotFound, "User not found")
+		return
+	}
+
+	utils.SendResponse[map[string]interface{}](c, http.StatusOK, true, "User unsuspended successfully", nil)
+}
+
+// AdminForceLogout revokes every session of the user.
+func AdminForceLogout(c *gin.Context) {
+	userID, ok := userIDParam(c)
+	if !ok {
+		return
+	}
+
+	revoked, err := utils.RevokeAllSessions(userID)
+	if err != nil {
+		utils.SendErrorResponse(c, http.StatusInternalServerError, "Failed to revoke sessions")
+		return
+	}
+
+	utils.SendResponse(c, http.StatusOK, true, "User logged out from all devices", gin.H{
+		"revoked": revoked,
+	})
+}
+
+// userIDParam parses the :id of the user routes, answering 400 when it is
+// not a UUID.
+func userIDParam(c *gin.Context) (uuid.UUID, bool) {
+	userID, err := uuid.Parse(c.Param("id"))
+	if err != nil {
+		utils.SendErrorResponse(c, http.StatusBadRequest, "Invalid user id")
+		return uuid.Nil, false
+	}
+	return userID, true
+}
+
+func paginationParams(c *gin.Context) (int, int) {
+	page, _ := strconv.Atoi(c.DefaultQuery("page", "1"))
+	limit, _ := strconv.Atoi(c.DefaultQuery("limit", "10"))
+
+	if page < 1 {
+		page = 1
+	}
+	if limit < 1 || limit > 100 {
+		limit = 10
+	}
+
+	return page, limit
+}
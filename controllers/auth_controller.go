@@ -2,8 +2,8 @@
 package controllers
 
 import (
+	"errors"
 	"fmt"
-	"log"
 	"net/http"
 	"strings"
 	"time"
@@ -11,15 +11,17 @@ import (
 	"github.com/gin-gonic/gin"
 	"github.com/google/uuid"
 	"github.com/pramek008/go-jwt-project/database"
+	"github.com/pramek008/go-jwt-project/logger"
 	"github.com/pramek008/go-jwt-project/models"
 	"github.com/pramek008/go-jwt-project/utils"
+	"gorm.io/gorm"
 )
 
 func InitiateRegistration(c *gin.Context) {
 	var userData struct {
 		Nickname string `json:"nickname" binding:"required"`
 		Email    string `json:"email" binding:"required,email"`
-		Password string `json:"password" binding:"required,min=8"`
+		Password string `json:"password" binding:"required"`
 	}
 
 	if err := c.ShouldBindJSON(&userData); err != nil {
@@ -38,6 +40,15 @@ func InitiateRegistration(c *gin.Context) {
 		return
 	}
 
+	if !checkPasswordPolicy(c, userData.Password, userData.Nickname, userData.Email) {
+		return
+	}
+
+	if utils.IsReservedNickname(userData.Nickname) {
+		utils.SendErrorResponse(c, http.StatusConflict, "User with this nickname already exists")
+		return
+	}
+
 	var existingTempUser models.TempUser
 	if err := database.DB.Db.Where("email = ? OR nickname = ?", userData.Email, userData.Nickname).First(&existingTempUser).Error; err == nil {
 		if existingTempUser.Email == userData.Email {
@@ -63,7 +74,7 @@ func InitiateRegistration(c *gin.Context) {
 	}
 
 	otpCode := utils.GenerateOTP()
-	if err := utils.SaveOTP(userData.Email, otpCode); err != nil {
+	if err := utils.SaveOTP(userData.Email, models.OTPPurposeRegistration, otpCode); err != nil {
 		utils.SendErrorResponse(c, http.StatusInternalServerError, "Failed to save OTP")
 		return
 	}
@@ -82,7 +93,6 @@ func InitiateRegistration(c *gin.Context) {
 		utils.SendErrorResponse(c, http.StatusInternalServerError, "Failed to hash password")
 		return
 	}
-	log.Printf("Hashed password for %s: %s", userData.Email, string(hashedPassword))
 
 	tempUser := models.TempUser{
 		Nickname:  userData.Nickname,
@@ -112,14 +122,14 @@ func CompleteRegistration(c *gin.Context) {
 		return
 	}
 
-	if !utils.ValidateOTP(verificationData.Email, verificationData.OTP) {
+	if !utils.ValidateOTP(verificationData.Email, models.OTPPurposeRegistration, verificationData.OTP) {
 		utils.SendErrorResponse(c, http.StatusBadRequest, "Invalid OTP or expired")
 		return
 	}
 
 	var tempUser models.TempUser
 	if err := database.DB.Db.Where("email = ?", verificationData.Email).First(&tempUser).Error; err != nil {
-		utils.SendErrorResponse(c, http.StatusNotFound, "User not found")
+		utils.SendErrorResponse(c, http.StatusBadRequest, "Invalid OTP or expired")
 		return
 	}
 
@@ -136,27 +146,33 @@ func CompleteRegistration(c *gin.Context) {
 		Password: tempUser.Password, // Use the hashed password from tempUser
 	}
 
-	log.Printf("Using hashed password for %s: %s", user.Email, user.Password)
-
-	if err := database.DB.Db.Create(&user).Error; err != nil {
+	err := database.DB.Db.Transaction(func(tx *gorm.DB) error {
+		if err := tx.Create(&user).Error; err != nil {
+			return err
+		}
+		return database.AssignRole(tx, user.ID, models.RoleUser)
+	})
+	if err != nil {
 		utils.SendErrorResponse(c, http.StatusInternalServerError, "Failed to create user")
 		return
 	}
 
 	database.DB.Db.Delete(&tempUser)
 
-	token, err := utils.GenerateToken(user.ID)
+	tokens, err := startSession(c, user, "", utils.LoginMethodRegistration)
 	if err != nil {
-		utils.SendErrorResponse(c, http.StatusInternalServerError, "Failed to generate token")
+		sendStartSessionError(c, err)
 		return
 	}
 
 	utils.SendResponse(c, http.StatusOK, true, "Registration completed successfully", gin.H{
-		"id":       user.ID,
-		"nickname": user.Nickname,
-		"email":    user.Email,
-		"created":  user.CreatedAt,
-		"token":    token,
+		"id":           user.ID,
+		"nickname":     user.Nickname,
+		"email":        user.Email,
+		"created":      user.CreatedAt,
+		"token":        tokens.AccessToken,
+		"refreshToken": tokens.RefreshToken,
+		"expiresIn":    tokens.ExpiresIn,
 	})
 }
 
@@ -170,40 +186,47 @@ func ResendOTP(c *gin.Context) {
 		return
 	}
 
-	// Check if the user can resend OTP or needs to wait
-	canResend, waitTime, err := utils.CanResendOTP(request.Email)
-	if err != nil {
-		utils.SendErrorResponse(c, http.StatusInternalServerError, "Server error")
-		return
-	}
+	// The response is the same whether or not the email has a pending
+	// registration, and whether or not it is still in its cooldown
+	const resendMessage = "If a registration is pending for this email, a new OTP has been sent"
 
-	if !canResend {
-		// Inform the user how long they need to wait
-		utils.SendErrorResponse(c, http.StatusTooManyRequests, "You must wait "+waitTime.String()+" before resending OTP")
+	var tempUser models.TempUser
+	if err := database.DB.Db.Where("email = ?", request.Email).First(&tempUser).Error; err != nil {
+		utils.SendResponse(c, http.StatusOK, true, resendMessage, gin.H{
+			"email": request.Email,
+		})
 		return
 	}
 
-	// Generate and save new OTP
-	otp := utils.GenerateOTP()
-	if otp == "" {
-		utils.SendErrorResponse(c, http.StatusInternalServerError, "Failed to generate OTP")
+	// Check if the user can resend OTP or needs to wait
+	canResend, _, err := utils.CanResendOTP(request.Email, models.OTPPurposeRegistration)
+	if err != nil {
+		utils.SendErrorResponse(c, http.StatusInternalServerError, "Server error")
 		return
 	}
 
-	if err := utils.SaveOTP(request.Email, otp); err != nil {
-		utils.SendErrorResponse(c, http.StatusInternalServerError, "Failed to save OTP")
-		return
-	}
+	if canResend {
+		// Generate and save new OTP
+		otp := utils.GenerateOTP()
+		if otp == "" {
+			utils.SendErrorResponse(c, http.StatusInternalServerError, "Failed to generate OTP")
+			return
+		}
 
-	// Send OTP via email or other methods here (omitted for brevity)
-	subject := "OTP Verification"
-	body := fmt.Sprintf("<h1>Your OTP is: %s</h1><p>This OTP will expire in 15 minutes.</p>", otp)
-	if err := utils.SendEmail(request.Email, subject, body); err != nil {
-		utils.SendErrorResponse(c, http.StatusInternalServerError, "Failed to send OTP email")
-		return
+		if err := utils.SaveOTP(request.Email, models.OTPPurposeRegistration, otp); err != nil {
+			utils.SendErrorResponse(c, http.StatusInternalServerError, "Failed to save OTP")
+			return
+		}
+
+		subject := "OTP Verification"
+		body := fmt.Sprintf("<h1>Your OTP is: %s</h1><p>This OTP will expire in 15 minutes.</p>", otp)
+		if err := utils.SendEmail(request.Email, subject, body); err != nil {
+			utils.SendErrorResponse(c, http.StatusInternalServerError, "Failed to send OTP email")
+			return
+		}
 	}
 
-	utils.SendResponse(c, http.StatusOK, true, "OTP sent successfully", gin.H{
+	utils.SendResponse(c, http.StatusOK, true, resendMessage, gin.H{
 		"email": request.Email,
 	})
 }
@@ -218,30 +241,43 @@ func ForgotPassword(c *gin.Context) {
 		return
 	}
 
+	const forgotMessage = "If an account exists for this email, an OTP for password reset has been sent"
+
 	var user models.User
 	if err := database.DB.Db.Where("email = ?", request.Email).First(&user).Error; err != nil {
-		utils.SendErrorResponse(c, http.StatusNotFound, "User not found")
-	}
-
-	otp := utils.GenerateOTP()
-	if otp == "" {
-		utils.SendErrorResponse(c, http.StatusInternalServerError, "Failed to generate OTP")
+		utils.SendResponse(c, http.StatusOK, true, forgotMessage, gin.H{
+			"email": request.Email,
+		})
 		return
 	}
 
-	if err := utils.SaveOTP(request.Email, otp); err != nil {
-		utils.SendErrorResponse(c, http.StatusInternalServerError, "Failed to save OTP")
+	canResend, _, err := utils.CanResendOTP(request.Email, models.OTPPurposePasswordReset)
+	if err != nil {
+		utils.SendErrorResponse(c, http.StatusInternalServerError, "Server error")
 		return
 	}
 
-	subject := "OTP for Password Reset"
-	body := fmt.Sprintf("<h1>Your OTP is: %s</h1><p>This OTP will expire in 15 minutes.</p>", otp)
-	if err := utils.SendEmail(request.Email, subject, body); err != nil {
-		utils.SendErrorResponse(c, http.StatusInternalServerError, "Failed to send OTP email")
-		return
+	if canResend {
+		otp := utils.GenerateOTP()
+		if otp == "" {
+			utils.SendErrorResponse(c, http.StatusInternalServerError, "Failed to generate OTP")
+			return
+		}
+
+		if err := utils.SaveOTP(request.Email, models.OTPPurposePasswordReset, otp); err != nil {
+			utils.SendErrorResponse(c, http.StatusInternalServerError, "Failed to save OTP")
+			return
+		}
+
+		subject := "OTP for Password Reset"
+		body := fmt.Sprintf("<h1>Your OTP is: %s</h1><p>This OTP will expire in 15 minutes.</p>", otp)
+		if err := utils.SendEmail(request.Email, subject, body); err != nil {
+			utils.SendErrorResponse(c, http.StatusInternalServerError, "Failed to send OTP email")
+			return
+		}
 	}
 
-	utils.SendResponse(c, http.StatusOK, true, "OTP for password reset sent successfully", gin.H{
+	utils.SendResponse(c, http.StatusOK, true, forgotMessage, gin.H{
 		"email": request.Email,
 	})
 }
@@ -258,13 +294,26 @@ func ResetPassword(c *gin.Context) {
 		return
 	}
 
-	if !utils.ValidateOTP(request.Email, request.OTP) {
+	// The policy is checked before the OTP so a rejected password does not
+	// use up the code. Only request data is used until the OTP is valid, or
+	// the nickname rule would tell anyone whether the account exists and
+	// what its nickname contains.
+	if !checkPasswordPolicy(c, request.Password, "", request.Email) {
+		return
+	}
+
+	if !utils.ValidateOTP(request.Email, models.OTPPurposePasswordReset, request.OTP) {
 		utils.SendErrorResponse(c, http.StatusBadRequest, "Invalid or expired OTP")
+		return
 	}
 
 	var user models.User
 	if err := database.DB.Db.Where("email = ?", request.Email).First(&user).Error; err != nil {
-		utils.SendErrorResponse(c, http.StatusNotFound, "User not found")
+		utils.SendErrorResponse(c, http.StatusBadRequest, "Invalid or expired OTP")
+		return
+	}
+	if !checkPasswordPolicy(c, request.Password, user.Nickname, request.Email) {
+		return
 	}
 
 	hashedPassword, err := utils.HashPassword(request.Password)
@@ -279,9 +328,21 @@ func ResetPassword(c *gin.Context) {
 		return
 	}
 
+	// Whoever knew the old password is logged out everywhere, and a lock from
+	// their failed guesses no longer applies
+	revoked, err := utils.RevokeCredentials(user.ID, uuid.Nil)
+	if err != nil {
+		utils.SendErrorResponse(c, http.StatusInternalServerError, "Password reset but failed to revoke sessions")
+		return
+	}
+	utils.ResetLoginFailures(utils.LoginThrottleKeyUser(user.ID))
+
+	sendPasswordChangedEmail(c, user)
+
 	utils.SendResponse(c, http.StatusOK, true, "Password reset successfully", gin.H{
-		"email":    request.Email,
-		"nickname": user.Nickname,
+		"email":           request.Email,
+		"nickname":        user.Nickname,
+		"revokedSessions": revoked,
 	})
 }
 
@@ -320,8 +381,9 @@ func ResetPassword(c *gin.Context) {
 
 func Login(c *gin.Context) {
 	var user struct {
-		Email    string `json:"email" binding:"required,email"`
-		Password string `json:"password" binding:"required"`
+		Email      string `json:"email" binding:"required,email"`
+		Password   string `json:"password" binding:"required"`
+		DeviceName string `json:"deviceName"`
 	}
 
 	if err := c.ShouldBindJSON(&user); err != nil {
@@ -329,8 +391,15 @@ func Login(c *gin.Context) {
 		return
 	}
 
+	clientIP := c.ClientIP()
+	if wait := utils.LoginRetryAfter(utils.LoginThrottleKeyIP(clientIP)); wait > 0 {
+		sendRetryAfter(c, wait)
+		return
+	}
+
 	var foundUser models.User
 	if err := database.DB.Db.Where("email = ?", user.Email).First(&foundUser).Error; err != nil {
+		utils.RecordIPLoginFailure(clientIP)
 		utils.SendErrorResponse(c, http.StatusUnauthorized, "User not found")
 		return
 	} else if foundUser.Email != user.Email {
@@ -339,29 +408,79 @@ func Login(c *gin.Context) {
 	} else if foundUser.Password == "" {
 		utils.SendErrorResponse(c, http.StatusUnauthorized, "Invalid password")
 		return
-	} else {
-		log.Printf("User found: %v", foundUser)
 	}
 
-	log.Printf("Stored hashed password for %s: %s", foundUser.Email, foundUser.Password)
-	log.Printf("Attempting to compare with provided password")
+	// The account is checked before the password so a locked account gives
+	// no hint whether the guess was right
+	if utils.IsAccountLocked(foundUser.ID) {
+		utils.SendErrorResponse(c, http.StatusLocked, "Account is temporarily locked, check your email for an unlock link")
+		return
+	}
+	if wait := utils.LoginRetryAfter(utils.LoginThrottleKeyUser(foundUser.ID)); wait > 0 {
+		sendRetryAfter(c, wait)
+		return
+	}
 
 	if err := utils.VerifyPassword(foundUser.Password, user.Password); err != nil {
+		utils.RecordIPLoginFailure(clientIP)
+		recordLoginEvent(c, foundUser.ID, nil, models.LoginEventFailure, utils.LoginMethodPassword, "invalid_password")
+		locked, _ := utils.RecordAccountLoginFailure(foundUser.ID)
+		if locked {
+			sendUnlockEmail(c, foundUser)
+			utils.SendErrorResponse(c, http.StatusLocked, "Account is temporarily locked, check your email for an unlock link")
+			return
+		}
 		utils.SendErrorResponse(c, http.StatusUnauthorized, "Invalid password")
 		return
 	}
 
-	token, err := utils.GenerateToken(foundUser.ID)
-	if err != nil {
-		utils.SendErrorResponse(c, http.StatusInternalServerError, "Failed to generate token")
+	// With a second factor the failures are only reset by LoginMFA, so wrong
+	// codes add up towards the lockout across logins
+	if !utils.UserHasMFA(foundUser.ID) {
+		utils.ResetLoginFailures(utils.LoginThrottleKeyUser(foundUser.ID))
+	}
+
+	// Upgrade bcrypt and outdated argon2id hashes while the plaintext is at
+	// hand. A failure here must not block the login.
+	if utils.PasswordNeedsRehash(foundUser.Password) {
+		if hashedPassword, err := utils.HashPassword(user.Password); err == nil {
+			if err := database.DB.Db.Model(&foundUser).Update("password", hashedPassword).Error; err != nil {
+				logger.FromContext(c).Warn("Failed to upgrade password hash", "user_id", foundUser.ID, "error", err)
+			}
+		}
+	}
+
+	completeLogin(c, foundUser, user.DeviceName, utils.LoginMethodPassword)
+}
+
+func RefreshToken(c *gin.Context) {
+	var request struct {
+		RefreshToken string `json:"refreshToken" binding:"required"`
+	}
+
+	if err := c.ShouldBindJSON(&request); err != nil {
+		utils.SendErrorResponse(c, http.StatusBadRequest, "Invalid request data")
+		return
+	}
+
+	tokens, err := utils.RotateRefreshToken(request.RefreshToken, "")
+	if errors.Is(err, utils.ErrRefreshTokenReused) {
+		utils.SendErrorResponse(c, http.StatusUnauthorized, "Refresh token has already been used, please log in again")
+		return
+	} else if errors.Is(err, utils.ErrInvalidRefreshToken) {
+		utils.SendErrorResponse(c, http.StatusUnauthorized, "Invalid or expired refresh token")
+		return
+	} else if err != nil {
+		utils.SendErrorResponse(c, http.StatusInternalServerError, "Failed to refresh token")
 		return
 	}
 
-	utils.SendResponse(c, http.StatusOK, true, "Login successful", gin.H{
-		"id":       foundUser.ID,
-		"nickname": foundUser.Nickname,
-		"email":    foundUser.Email,
-		"token":    token,
+	recordLoginEvent(c, tokens.UserID, &tokens.SessionID, models.LoginEventTokenRefresh, utils.LoginMethodRefreshToken, "")
+
+	utils.SendResponse(c, http.StatusOK, true, "Token refreshed successfully", gin.H{
+		"token":        tokens.AccessToken,
+		"refreshToken": tokens.RefreshToken,
+		"expiresIn":    tokens.ExpiresIn,
 	})
 }
 
@@ -391,16 +510,12 @@ func GetMe(c *gin.Context) {
 }
 
 func Logout(c *gin.Context) {
-	tokenString := c.GetHeader("Authorization")
-	tokenString = strings.TrimPrefix(tokenString, "Bearer ")
+	sessionID, _ := c.Get("session_id")
 
-	var Token models.Token
-	if err := database.DB.Db.Where("token = ?", tokenString).First(&Token).Error; err != nil {
-		utils.SendErrorResponse(c, http.StatusNotFound, "Token not found")
+	if err := utils.RevokeSession(sessionID.(uuid.UUID)); err != nil {
+		utils.SendErrorResponse(c, http.StatusInternalServerError, "Failed to logout")
 		return
 	}
 
-	database.DB.Db.Delete(&Token)
-
 	utils.SendResponse(c, http.StatusOK, true, "Logout successful", gin.H{})
 }
@@ -0,0 +1,297 @@
+package controllers
+
+import (
+	"errors"
+	"fmt"
+	"html"
+	"net/http"
+	"net/url"
+	"strings"
+	"time"
+
+	"github.com/gin-gonic/gin"
+	"github.com/google/uuid"
+	"github.com/pramek008/go-jwt-project/database"
+	"github.com/pramek008/go-jwt-project/logger"
+	"github.com/pramek008/go-jwt-project/models"
+	"github.com/pramek008/go-jwt-project/utils"
+	"gorm.io/gorm"
+)
+
+const (
+	emailChangeExpiryDuration = 15 * time.Minute
+	emailCancelExpiryDuration = 7 * 24 * time.Hour
+)
+
+var errEmailInUse = errors.New("email is already in use")
+
+// RequestEmailChange sends an OTP to the new address and a notice with a
+// cancel link to the current one. Nothing changes until ConfirmEmailChange.
+func RequestEmailChange(c *gin.Context) {
+	var request struct {
+		NewEmail string `json:"newEmail" binding:"required,email"`
+		Password string `json:"password" binding:"required"`
+	}
+
+	if err := c.ShouldBindJSON(&request); err != nil {
+		utils.SendErrorResponse(c, http.StatusBadRequest, "Invalid request data")
+		return
+	}
+
+	userID, _ := c.Get("user_id")
+
+	var user models.User
+	if err := database.DB.Db.First(&user, "id = ?", userID).Error; err != nil {
+		utils.SendErrorResponse(c, http.StatusNotFound, "User not found")
+		return
+	}
+
+	if err := utils.VerifyPassword(user.Password, request.Password); err != nil {
+		utils.SendErrorResponse(c, http.StatusUnauthorized, "Password is incorrect")
+		return
+	}
+
+	if strings.EqualFold(request.NewEmail, user.Email) {
+		utils.SendErrorResponse(c, http.StatusBadRequest, "New email must be different from the current email")
+		return
+	}
+
+	if err := checkEmailAvailable(database.DB.Db, request.NewEmail); err != nil {
+		sendEmailAvailabilityError(c, err)
+		return
+	}
+
+	changeRequest := models.EmailChangeRequest{
+		UserID:    user.ID,
+		OldEmail:  user.Email,
+		NewEmail:  request.NewEmail,
+		ExpiresAt: time.Now().Add(emailChangeExpiryDuration),
+	}
+
+	// A new request replaces the pending one
+	err := database.DB.Db.Transaction(func(tx *gorm.DB) error {
+		if err := tx.Where("user_id = ?", user.ID).Delete(&models.EmailChangeRequest{}).Error; err != nil {
+			return err
+		}
+		return tx.Create(&changeRequest).Error
+	})
+	if err != nil {
+		utils.SendErrorResponse(c, http.StatusInternalServerError, "Failed to save email change request")
+		return
+	}
+
+	otp := utils.GenerateOTP()
+	if otp == "" {
+		utils.SendErrorResponse(c, http.StatusInternalServerError, "Failed to generate OTP")
+		return
+	}
+
+	if err := utils.SaveOTP(request.NewEmail, models.OTPPurposeEmailChange, otp); err != nil {
+		utils.SendErrorResponse(c, http.StatusInternalServerError, "Failed to save OTP")
+		return
+	}
+
+	subject := "Confirm your new email address"
+	body := fmt.Sprintf("<h1>Your OTP is: %s</h1><p>Enter this code to confirm %s as the new email address of your account. It will expire in 15 minutes.</p>", otp, html.EscapeString(request.NewEmail))
+	if err := utils.SendEmail(request.NewEmail, subject, body); err != nil {
+		utils.SendErrorResponse(c, http.StatusInternalServerError, "Failed to send OTP email")
+		return
+	}
+
+	sendEmailChangeNotice(c, user, request.NewEmail)
+
+	utils.SendResponse(c, http.StatusOK, true, "OTP sent to the new email address", gin.H{
+		"newEmail":  request.NewEmail,
+		"expiresAt": changeRequest.ExpiresAt,
+	})
+}
+
+// sendEmailChangeNotice tells the current address about the request with a
+// link to cancel it, in case the account has been taken over.
+func sendEmailChangeNotice(c *gin.Context, user models.User, newEmail string) {
+	token, err := utils.CreateEmailCancelToken(user.ID, user.Email, emailCancelExpiryDuration)
+	if err != nil {
+		logger.FromContext(c).Error("Failed to create email change cancel token", "user_id", user.ID, "error", err)
+		return
+	}
+
+	link := fmt.Sprintf("%s/api/auth/change-email/cancel?token=%s", utils.PublicURL(), url.QueryEscape(token))
+
+	subject := "Your email address is being changed"
+	body := fmt.Sprintf("<h1>Email change requested</h1><p>Hi %s, someone asked to change the email address of your account to %s.</p><p>If this was not you, <a href=\"%s\">click here to cancel the change</a>. This also logs out every session on your account, and still restores this address for 7 days if the change has been confirmed.</p>", html.EscapeString(user.Nickname), html.EscapeString(newEmail), html.EscapeString(link))
+	if err := utils.SendEmail(user.Email, subject, body); err != nil {
+		logger.FromContext(c).Error("Failed to send email change notice", "user_id", user.ID, "error", err)
+	}
+}
+
+func ConfirmEmailChange(c *gin.Context) {
+	var request struct {
+		OTP string `json:"otp" binding:"required,min=6,max=6"`
+	}
+
+	if err := c.ShouldBindJSON(&request); err != nil {
+		utils.SendErrorResponse(c, http.StatusBadRequest, "Invalid request data")
+		return
+	}
+
+	userID, _ := c.Get("user_id")
+
+	var changeRequest models.EmailChangeRequest
+	if err := database.DB.Db.Where("user_id = ? AND expires_at > ?", userID, time.Now()).First(&changeRequest).Error; err != nil {
+		utils.SendErrorResponse(c, http.StatusNotFound, "No pending email change")
+		return
+	}
+
+	if !utils.ValidateOTP(changeRequest.NewEmail, models.OTPPurposeEmailChange, request.OTP) {
+		utils.SendErrorResponse(c, http.StatusBadRequest, "Invalid or expired OTP")
+		return
+	}
+
+	// The address may have been taken since the request, the unique index
+	// on users.email still guards against a race between the two checks
+	err := database.DB.Db.Transaction(func(tx *gorm.DB) error {
+		if err := checkEmailAvailable(tx, changeRequest.NewEmail); err != nil {
+			return err
+		}
+		if err := tx.Model(&models.User{}).Where("id = ?", changeRequest.UserID).Update("email", changeRequest.NewEmail).Error; err != nil {
+			if errors.Is(err, gorm.ErrDuplicatedKey) || strings.Contains(err.Error(), "duplicate key") {
+				return errEmailInUse
+			}
+			return err
+		}
+		return tx.Delete(&changeRequest).Error
+	})
+	if err != nil {
+		sendEmailAvailabilityError(c, err)
+		return
+	}
+
+	subject := "Your email address has been changed"
+	body := fmt.Sprintf("<h1>Email changed</h1><p>The email address of your account is now %s. This address will no longer receive messages about the account.</p><p>If this was not you, the cancel link in the earlier email restores this address for 7 days.</p>", html.EscapeString(changeRequest.NewEmail))
+	if err := utils.SendEmail(changeRequest.OldEmail, subject, body); err != nil {
+		logger.FromContext(c).Error("Failed to send email changed notice", "user_id", changeRequest.UserID, "error", err)
+	}
+
+	utils.SendResponse(c, http.StatusOK, true, "Email changed successfully", gin.H{
+		"email": changeRequest.NewEmail,
+	})
+}
+
+// CancelEmailChange is the link sent to the old address. Since the request
+// may come from someone who took over the account, it also logs out every
+// session and revokes personal access tokens. Until the link expires it
+// restores the old address even if the change has been confirmed.
+func CancelEmailChange(c *gin.Context) {
+	token := c.Query("token")
+	if token == "" {
+		utils.SendErrorResponse(c, http.StatusBadRequest, "Token is required")
+		return
+	}
+
+	actionToken, err := utils.ConsumeActionToken(token, utils.PurposeEmailCancel, "")
+	if err != nil {
+		utils.SendErrorResponse(c, http.StatusUnauthorized, "Invalid or expired cancel link")
+		return
+	}
+
+	var restoredEmail string
+	err = database.DB.Db.Transaction(func(tx *gorm.DB) error {
+		if err := tx.Where("user_id = ?", actionToken.UserID).Delete(&models.EmailChangeRequest{}).Error; err != nil {
+			return err
+		}
+
+		// Cancel links sent to addresses set after this one would undo the revert
+		newer := tx.Model(&models.ActionToken{}).Select("id").
+			Where("user_id = ? AND purpose = ? AND created_at > ?", actionToken.UserID, utils.PurposeEmailCancel, actionToken.CreatedAt)
+		if err := tx.Where("action_token_id IN (?)", newer).Delete(&models.EmailChangeRevert{}).Error; err != nil {
+			return err
+		}
+		if err := tx.Where("user_id = ? AND purpose = ? AND created_at > ?", actionToken.UserID, utils.PurposeEmailCancel, actionToken.CreatedAt).
+			Delete(&models.ActionToken{}).Error; err != nil {
+			return err
+		}
+
+		var revert models.EmailChangeRevert
+		if err := tx.Where("action_token_id = ?", actionToken.ID).First(&revert).Error; errors.Is(err, gorm.ErrRecordNotFound) {
+			return nil
+		} else if err != nil {
+			return err
+		}
+		if err := tx.Delete(&revert).Error; err != nil {
+			return err
+		}
+
+		var user models.User
+		if err := tx.First(&user, "id = ?", actionToken.UserID).Error; err != nil {
+			return err
+		}
+		if strings.EqualFold(user.Email, revert.Email) {
+			return nil
+		}
+
+		if err := checkEmailAvailable(tx, revert.Email); err != nil {
+			return err
+		}
+		if err := tx.Model(&user).Update("email", revert.Email).Error; err != nil {
+			if errors.Is(err, gorm.ErrDuplicatedKey) || strings.Contains(err.Error(), "duplicate key") {
+				return errEmailInUse
+			}
+			return err
+		}
+		restoredEmail = revert.Email
+		return nil
+	})
+	if err != nil && !errors.Is(err, errEmailInUse) {
+		utils.SendErrorResponse(c, http.StatusInternalServerError, "Failed to cancel email change")
+		return
+	}
+
+	// Log out everyone even if the old address could not be restored
+	if _, revokeErr := utils.RevokeCredentials(actionToken.UserID, uuid.Nil); revokeErr != nil {
+		utils.SendErrorResponse(c, http.StatusInternalServerError, "Email change cancelled but failed to revoke sessions")
+		return
+	}
+
+	if err != nil {
+		utils.SendErrorResponse(c, http.StatusConflict, "All sessions were logged out, but the previous email address is now used by another account")
+		return
+	}
+
+	if restoredEmail != "" {
+		logger.FromContext(c).Info("Email change reverted", "user_id", actionToken.UserID)
+		utils.SendResponse(c, http.StatusOK, true, "Email address restored and all sessions logged out, consider changing your password", gin.H{
+			"email": restoredEmail,
+		})
+		return
+	}
+
+	utils.SendResponse[map[string]interface{}](c, http.StatusOK, true, "Email change cancelled and all sessions logged out, consider changing your password", nil)
+}
+
+// checkEmailAvailable rejects addresses used by an account or by a pending
+// registration that has not expired yet.
+func checkEmailAvailable(db *gorm.DB, email string) error {
+	var count int64
+	if err := db.Model(&models.User{}).Where("LOWER(email) = LOWER(?)", email).Count(&count).Error; err != nil {
+		return err
+	}
+	if count > 0 {
+		return errEmailInUse
+	}
+
+	if err := db.Model(&models.TempUser{}).Where("LOWER(email) = LOWER(?) AND expires_at > ?", email, time.Now()).Count(&count).Error; err != nil {
+		return err
+	}
+	if count > 0 {
+		return errEmailInUse
+	}
+	return nil
+}
+
+func sendEmailAvailabilityError(c *gin.Context, err error) {
+	if errors.Is(err, errEmailInUse) {
+		utils.SendErrorResponse(c, http.StatusConflict, "Email is already in use")
+		return
+	}
+	utils.SendErrorResponse(c, http.StatusInternalServerError, "Failed to update email")
+}
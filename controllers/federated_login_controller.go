@@ -0,0 +1,306 @@
+package controllers
+
+import (
+	"crypto/subtle"
+	"errors"
+	"net/http"
+
+	"github.com/gin-gonic/gin"
+	"github.com/google/uuid"
+	"github.com/pramek008/go-jwt-project/database"
+	"github.com/pramek008/go-jwt-project/federation"
+	"github.com/pramek008/go-jwt-project/logger"
+	"github.com/pramek008/go-jwt-project/models"
+	"github.com/pramek008/go-jwt-project/utils"
+)
+
+const (
+	oidcStateCookie     = "oidc_state"
+	oidcLinkNonceCookie = "oidc_link_nonce"
+	oidcCookiePath      = "/api/auth/oidc"
+	oidcStateCookieAge  = 10 * 60
+)
+
+func ListIdentityProviders(c *gin.Context) {
+	providers := []gin.H{}
+	for _, provider := range federation.List() {
+		providers = append(providers, gin.H{
+			"id":   provider.ID,
+			"name": provider.Name,
+		})
+	}
+
+	utils.SendResponse(c, http.StatusOK, true, "Identity providers fetched successfully", providers)
+}
+
+// BeginFederatedLogin redirects the browser to the identity provider. The
+// state goes into a cookie as well, so the callback only works in the
+// browser that started the login.
+func BeginFederatedLogin(c *gin.Context) {
+	provider, err := federation.Get(c.Param("provider"))
+	if err != nil {
+		utils.SendErrorResponse(c, http.StatusNotFound, "Identity provider not found")
+		return
+	}
+
+	nonce, err := utils.GenerateNonce()
+	if err != nil {
+		utils.SendErrorResponse(c, http.StatusInternalServerError, "Failed to start login")
+		return
+	}
+	// 32 random bytes in base64url are a valid PKCE verifier
+	codeVerifier, err := utils.GenerateNonce()
+	if err != nil {
+		utils.SendErrorResponse(c, http.StatusInternalServerError, "Failed to start login")
+		return
+	}
+
+	redirectURL := utils.PublicURL() + oidcCookiePath + "/" + provider.ID + "/callback"
+
+	state, err := utils.SaveOIDCLoginState(provider.ID, nonce, codeVerifier, redirectURL, c.Query("deviceName"))
+	if err != nil {
+		utils.SendErrorResponse(c, http.StatusInternalServerError, "Failed to start login")
+		return
+	}
+
+	authURL, err := provider.AuthCodeURL(c.Request.Context(), redirectURL, state, nonce, codeVerifier)
+	if err != nil {
+		logger.FromContext(c).Error("Failed to reach identity provider", "provider", provider.ID, "error", err)
+		utils.SendErrorResponse(c, http.StatusBadGateway, "Identity provider is unavailable")
+		return
+	}
+
+	c.SetSameSite(http.SameSiteLaxMode)
+	c.SetCookie(oidcStateCookie, state, oidcStateCookieAge, oidcCookiePath, "", c.Request.TLS != nil, true)
+	c.Redirect(http.StatusFound, authURL)
+}
+
+// FederatedLoginCallback finishes a federated login, see
+// loginFederatedIdentity.
+func FederatedLoginCallback(c *gin.Context) {
+	provider, err := federation.Get(c.Param("provider"))
+	if err != nil {
+		utils.SendErrorResponse(c, http.StatusNotFound, "Identity provider not found")
+		return
+	}
+
+	state := c.Query("state")
+	cookieState, _ := c.Cookie(oidcStateCookie)
+	c.SetSameSite(http.SameSiteLaxMode)
+	c.SetCookie(oidcStateCookie, "", -1, oidcCookiePath, "", c.Request.TLS != nil, true)
+
+	if state == "" || subtle.ConstantTimeCompare([]byte(state), []byte(cookieState)) != 1 {
+		utils.SendErrorResponse(c, http.StatusUnauthorized, "Open the login in the browser where you started it")
+		return
+	}
+
+	loginState, err := utils.ConsumeOIDCLoginState(state, provider.ID)
+	if err != nil {
+		utils.SendErrorResponse(c, http.StatusUnauthorized, "Login has expired or was already completed, please try again")
+		return
+	}
+
+	if providerError := c.Query("error"); providerError != "" {
+		utils.SendErrorResponse(c, http.StatusUnauthorized, "Login was refused by the identity provider: "+providerError)
+		return
+	}
+
+	identity, err := provider.Exchange(c.Request.Context(), loginState.RedirectURL, c.Query("code"), loginState.CodeVerifier, loginState.Nonce)
+	if err != nil {
+		logger.FromContext(c).Warn("Federated login failed", "provider", provider.ID, "error", err)
+		utils.SendErrorResponse(c, http.StatusUnauthorized, "Failed to verify the login with the identity provider")
+		return
+	}
+
+	loginFederatedIdentity(c, provider.ID, identity, loginState.DeviceName, utils.LoginMethodOIDC, true)
+}
+
+// loginFederatedIdentity logs in the user an OIDC provider or SAML tenant
+// vouched for. A known identity logs its user in and, with provision set, a
+// new one gets an account, unless the email belongs to an existing account:
+// then the user has to confirm the link with their password at
+// ConfirmIdentityLink.
+func loginFederatedIdentity(c *gin.Context, provider string, identity *federation.Identity, deviceName, method string, provision bool) {
+	if userIdentity, err := utils.FindUserIdentity(provider, identity); err == nil {
+		var user models.User
+		if err := database.DB.Db.First(&user, "id = ?", userIdentity.UserID).Error; err != nil {
+			utils.SendErrorResponse(c, http.StatusUnauthorized, "User not found")
+			return
+		}
+
+		completeLogin(c, user, deviceName, method)
+		return
+	}
+
+	var existingUser models.User
+	if err := database.DB.Db.Where("LOWER(email) = LOWER(?)", identity.Email).First(&existingUser).Error; err == nil && identity.Email != "" {
+		if !identity.EmailVerified {
+			utils.SendErrorResponse(c, http.StatusConflict, "An account with this email already exists")
+			return
+		}
+
+		requestIdentityLink(c, existingUser, provider, identity, deviceName)
+		return
+	}
+
+	if !provision {
+		utils.SendErrorResponse(c, http.StatusForbidden, "No account exists for this user, ask your administrator for access")
+		return
+	}
+
+	user, err := utils.CreateFederatedUser(provider, identity)
+	if errors.Is(err, utils.ErrFederatedEmailUnusable) {
+		utils.SendErrorResponse(c, http.StatusForbidden, "The identity provider did not confirm your email address")
+		return
+	} else if errors.Is(err, utils.ErrFederatedEmailInUse) || errors.Is(err, utils.ErrIdentityAlreadyLinked) {
+		utils.SendErrorResponse(c, http.StatusConflict, "An account with this email already exists")
+		return
+	} else if err != nil {
+		logger.FromContext(c).Error("Failed to create federated user", "provider", provider, "error", err)
+		utils.SendErrorResponse(c, http.StatusInternalServerError, "Failed to create user")
+		return
+	}
+
+	logger.FromContext(c).Info("User created by federated login", "user_id", user.ID, "provider", provider)
+	completeLogin(c, *user, deviceName, method)
+}
+
+// requestIdentityLink asks the user to confirm linking an identity of an
+// OIDC provider or SAML tenant to their account at ConfirmIdentityLink.
+func requestIdentityLink(c *gin.Context, user models.User, provider string, identity *federation.Identity, deviceName string) {
+	nonce, err := utils.GenerateNonce()
+	if err != nil {
+		utils.SendErrorResponse(c, http.StatusInternalServerError, "Failed to start linking")
+		return
+	}
+
+	linkToken, err := utils.CreateIdentityLinkRequest(user.ID, provider, identity, deviceName, nonce)
+	if err != nil {
+		utils.SendErrorResponse(c, http.StatusInternalServerError, "Failed to start linking")
+		return
+	}
+
+	c.SetSameSite(http.SameSiteLaxMode)
+	c.SetCookie(oidcLinkNonceCookie, nonce, int(utils.IdentityLinkRequestExpiry.Seconds()), oidcCookiePath, "", c.Request.TLS != nil, true)
+
+	utils.SendResponse(c, http.StatusConflict, false, "An account with this email already exists, confirm your password to link it", gin.H{
+		"linkRequired": true,
+		"linkToken":    linkToken,
+		"provider":     provider,
+		"email":        user.Email,
+	})
+}
+
+// ConfirmIdentityLink links a federated identity to the existing account
+// with the same email once the user enters the account's password, then logs
+// them in.
+func ConfirmIdentityLink(c *gin.Context) {
+	var request struct {
+		LinkToken string `json:"linkToken" binding:"required"`
+		Password  string `json:"password" binding:"required"`
+	}
+
+	if err := c.ShouldBindJSON(&request); err != nil {
+		utils.SendErrorResponse(c, http.StatusBadRequest, "Invalid request data")
+		return
+	}
+
+	// The token is only consumed once the password is right, a typo should
+	// not force the user through the provider again
+	claims, err := utils.ExtractPurposeClaims(request.LinkToken, utils.PurposeIdentityLink)
+	if err != nil {
+		utils.SendErrorResponse(c, http.StatusUnauthorized, "Invalid or expired link request")
+		return
+	}
+
+	var user models.User
+	if err := database.DB.Db.First(&user, "id = ?", claims.UserID).Error; err != nil {
+		utils.SendErrorResponse(c, http.StatusUnauthorized, "User not found")
+		return
+	}
+
+	if utils.IsAccountLocked(user.ID) {
+		utils.SendErrorResponse(c, http.StatusLocked, "Account is temporarily locked, check your email for an unlock link")
+		return
+	}
+	if wait := utils.LoginRetryAfter(utils.LoginThrottleKeyUser(user.ID)); wait > 0 {
+		sendRetryAfter(c, wait)
+		return
+	}
+
+	if err := utils.VerifyPassword(user.Password, request.Password); err != nil {
+		recordLoginEvent(c, user.ID, nil, models.LoginEventFailure, utils.LoginMethodOIDC, "invalid_password")
+		locked, _ := utils.RecordAccountLoginFailure(user.ID)
+		if locked {
+			sendUnlockEmail(c, user)
+			utils.SendErrorResponse(c, http.StatusLocked, "Account is temporarily locked, check your email for an unlock link")
+			return
+		}
+		utils.SendErrorResponse(c, http.StatusUnauthorized, "Invalid password")
+		return
+	}
+	if !utils.UserHasMFA(user.ID) {
+		utils.ResetLoginFailures(utils.LoginThrottleKeyUser(user.ID))
+	}
+
+	nonce, _ := c.Cookie(oidcLinkNonceCookie)
+	actionToken, err := utils.ConsumeActionToken(request.LinkToken, utils.PurposeIdentityLink, nonce)
+	if err != nil {
+		utils.SendErrorResponse(c, http.StatusUnauthorized, "Invalid or expired link request")
+		return
+	}
+	c.SetSameSite(http.SameSiteLaxMode)
+	c.SetCookie(oidcLinkNonceCookie, "", -1, oidcCookiePath, "", c.Request.TLS != nil, true)
+
+	linkRequest, err := utils.ConfirmIdentityLink(user.ID, actionToken.ID)
+	if errors.Is(err, utils.ErrIdentityLinkInvalid) {
+		utils.SendErrorResponse(c, http.StatusUnauthorized, "Invalid or expired link request")
+		return
+	} else if errors.Is(err, utils.ErrIdentityAlreadyLinked) {
+		utils.SendErrorResponse(c, http.StatusConflict, "This identity is already linked to an account")
+		return
+	} else if err != nil {
+		utils.SendErrorResponse(c, http.StatusInternalServerError, "Failed to link identity")
+		return
+	}
+
+	logger.FromContext(c).Info("Federated identity linked", "user_id", user.ID, "provider", linkRequest.Provider)
+	completeLogin(c, user, linkRequest.DeviceName, utils.FederatedLoginMethod(linkRequest.Provider))
+}
+
+func ListIdentities(c *gin.Context) {
+	userID, _ := c.Get("user_id")
+
+	var identities []models.UserIdentity
+	if err := database.DB.Db.Where("user_id = ?", userID).Order("created_at").Find(&identities).Error; err != nil {
+		utils.SendErrorResponse(c, http.StatusInternalServerError, "Failed to fetch identities")
+		return
+	}
+
+	utils.SendResponse(c, http.StatusOK, true, "Identities fetched successfully", identities)
+}
+
+// UnlinkIdentity removes a federated identity. Accounts created by a
+// federated login have a random password, so after unlinking their last
+// identity the user logs in by setting one with the forgot password flow.
+func UnlinkIdentity(c *gin.Context) {
+	userID, _ := c.Get("user_id")
+
+	identityID, err := uuid.Parse(c.Param("id"))
+	if err != nil {
+		utils.SendErrorResponse(c, http.StatusBadRequest, "Invalid identity id")
+		return
+	}
+
+	result := database.DB.Db.Where("id = ? AND user_id = ?", identityID, userID).Delete(&models.UserIdentity{})
+	if result.Error != nil {
+		utils.SendErrorResponse(c, http.StatusInternalServerError, "Failed to unlink identity")
+		return
+	}
+	if result.RowsAffected == 0 {
+		utils.SendErrorResponse(c, http.StatusNotFound, "Identity not found")
+		return
+	}
+
+	utils.SendResponse[map[string]interface{}](c, http.StatusOK, true, "Identity unlinked successfully", nil)
+}
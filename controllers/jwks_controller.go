@@ -0,0 +1,17 @@
+package controllers
+
+import (
+	"net/http"
+
+	"github.com/gin-gonic/gin"
+	"github.com/pramek008/go-jwt-project/utils"
+)
+
+// GetJWKS publishes the public signing keys in the standard JWK Set format so
+// other services can verify our tokens without sharing a secret.
+func GetJWKS(c *gin.Context) {
+	c.Header("Cache-Control", "public, max-age=300")
+	c.JSON(http.StatusOK, gin.H{
+		"keys": utils.PublicJWKS(),
+	})
+}
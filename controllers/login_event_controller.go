@@ -0,0 +1,68 @@
+package controllers
+
+import (
+	"fmt"
+	"html"
+	"net/http"
+	"time"
+
+	"github.com/gin-gonic/gin"
+	"github.com/google/uuid"
+	"github.com/pramek008/go-jwt-project/database"
+	"github.com/pramek008/go-jwt-project/logger"
+	"github.com/pramek008/go-jwt-project/models"
+	"github.com/pramek008/go-jwt-project/utils"
+)
+
+// ListLoginEvents returns the current user's login history, newest first.
+// The event query parameter filters by event type.
+func ListLoginEvents(c *gin.Context) {
+	userID, _ := c.Get("user_id")
+	page, limit := paginationParams(c)
+
+	query := database.DB.Db.Model(&models.LoginEvent{}).Where("user_id = ?", userID)
+	if event := c.Query("event"); event != "" {
+		query = query.Where("event = ?", event)
+	}
+
+	var total int64
+	if err := query.Count(&total).Error; err != nil {
+		utils.SendErrorResponse(c, http.StatusInternalServerError, "Failed to fetch login history")
+		return
+	}
+
+	var events []models.LoginEvent
+	if err := query.Order("created_at desc").Offset((page - 1) * limit).Limit(limit).Find(&events).Error; err != nil {
+		utils.SendErrorResponse(c, http.StatusInternalServerError, "Failed to fetch login history")
+		return
+	}
+
+	utils.SendPaginatedResponse(c, http.StatusOK, true, "Login history fetched successfully", events, int64(limit), int64(page), total)
+}
+
+// recordLoginEvent adds an entry to the login history. It reports whether a
+// successful login came from a new device. Failing to record never fails the
+// request itself.
+func recordLoginEvent(c *gin.Context, userID uuid.UUID, sessionID *uuid.UUID, event, method, reason string) bool {
+	loginEvent := utils.NewLoginEvent(userID, sessionID, event, method, c.ClientIP(), c.Request.UserAgent())
+	loginEvent.Reason = reason
+
+	newDevice, err := utils.RecordLoginEvent(&loginEvent)
+	if err != nil {
+		logger.FromContext(c).Error("Failed to record login event", "user_id", userID, "event", event, "error", err)
+		return false
+	}
+	return newDevice
+}
+
+func sendNewDeviceEmail(c *gin.Context, user models.User, deviceName string) {
+	loginEvent := utils.NewLoginEvent(user.ID, nil, models.LoginEventSuccess, "", c.ClientIP(), c.Request.UserAgent())
+
+	subject := "New login to your account"
+	body := fmt.Sprintf("<h1>New device login</h1><p>Hi %s, your account was just used to log in from a device we have not seen before.</p><ul><li>Device: %s (%s)</li><li>Browser: %s</li><li>Operating system: %s</li><li>IP address: %s</li><li>Time: %s</li></ul><p>If this was not you, change your password and log out your other sessions.</p>",
+		html.EscapeString(user.Nickname), html.EscapeString(deviceName), html.EscapeString(loginEvent.Device), html.EscapeString(loginEvent.Browser),
+		html.EscapeString(loginEvent.OS), html.EscapeString(loginEvent.IPAddress), time.Now().Format(time.RFC1123))
+	if err := utils.SendEmail(user.Email, subject, body); err != nil {
+		logger.FromContext(c).Error("Failed to send new device email", "user_id", user.ID, "error", err)
+	}
+}
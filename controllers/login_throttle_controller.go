@@ -0,0 +1,68 @@
+package controllers
+
+import (
+	"fmt"
+	"html"
+	"math"
+	"net/http"
+	"net/url"
+	"strconv"
+	"time"
+
+	"github.com/gin-gonic/gin"
+	"github.com/pramek008/go-jwt-project/logger"
+	"github.com/pramek008/go-jwt-project/models"
+	"github.com/pramek008/go-jwt-project/utils"
+)
+
+const unlockLinkExpiryDuration = 24 * time.Hour
+
+// sendRetryAfter answers a throttled login with the number of seconds the
+// client has to wait.
+func sendRetryAfter(c *gin.Context, wait time.Duration) {
+	seconds := int(math.Ceil(wait.Seconds()))
+	c.Header("Retry-After", strconv.Itoa(seconds))
+	utils.SendResponse(c, http.StatusTooManyRequests, false, "Too many failed login attempts, please try again later", gin.H{
+		"retryAfter": seconds,
+	})
+}
+
+// sendUnlockEmail tells the owner their account was locked and gives them a
+// link to lift the lock early. Failing to send it does not change the login
+// response.
+func sendUnlockEmail(c *gin.Context, user models.User) {
+	token, err := utils.CreateActionToken(user.ID, utils.PurposeUnlock, "", unlockLinkExpiryDuration)
+	if err != nil {
+		logger.FromContext(c).Error("Failed to create unlock token", "user_id", user.ID, "error", err)
+		return
+	}
+
+	link := fmt.Sprintf("%s/api/auth/unlock?token=%s", utils.PublicURL(), url.QueryEscape(token))
+
+	subject := "Your account has been locked"
+	body := fmt.Sprintf("<h1>Account locked</h1><p>We locked your account after too many failed login attempts. If this was you, <a href=\"%s\">click here to unlock it</a>.</p><p>If it was not you, consider changing your password once you are back in.</p>", html.EscapeString(link))
+	if err := utils.SendEmail(user.Email, subject, body); err != nil {
+		logger.FromContext(c).Error("Failed to send unlock email", "user_id", user.ID, "error", err)
+	}
+}
+
+func UnlockAccount(c *gin.Context) {
+	token := c.Query("token")
+	if token == "" {
+		utils.SendErrorResponse(c, http.StatusBadRequest, "Token is required")
+		return
+	}
+
+	actionToken, err := utils.ConsumeActionToken(token, utils.PurposeUnlock, "")
+	if err != nil {
+		utils.SendErrorResponse(c, http.StatusUnauthorized, "Invalid or expired unlock link")
+		return
+	}
+
+	if err := utils.ResetLoginFailures(utils.LoginThrottleKeyUser(actionToken.UserID)); err != nil {
+		utils.SendErrorResponse(c, http.StatusInternalServerError, "Failed to unlock account")
+		return
+	}
+
+	utils.SendResponse[map[string]interface{}](c, http.StatusOK, true, "Account unlocked, you can log in again", nil)
+}
@@ -0,0 +1,97 @@
+package controllers
+
+import (
+	"fmt"
+	"html"
+	"net/http"
+	"net/url"
+	"time"
+
+	"github.com/gin-gonic/gin"
+	"github.com/pramek008/go-jwt-project/database"
+	"github.com/pramek008/go-jwt-project/models"
+	"github.com/pramek008/go-jwt-project/utils"
+)
+
+const (
+	magicLinkExpiryDuration = 15 * time.Minute
+	magicLinkNonceCookie    = "magic_link_nonce"
+	magicLinkCookiePath     = "/api/auth/magic-link"
+)
+
+// RequestMagicLink emails a single-use login link. The link only works in the
+// browser that asked for it, which holds the matching nonce cookie.
+func RequestMagicLink(c *gin.Context) {
+	var request struct {
+		Email string `json:"email" binding:"required,email"`
+	}
+
+	if err := c.ShouldBindJSON(&request); err != nil {
+		utils.SendErrorResponse(c, http.StatusBadRequest, "Invalid request data")
+		return
+	}
+
+	nonce, err := utils.GenerateNonce()
+	if err != nil {
+		utils.SendErrorResponse(c, http.StatusInternalServerError, "Failed to create login link")
+		return
+	}
+
+	// The cookie is set and the response is the same whether or not the
+	// email belongs to an account
+	c.SetSameSite(http.SameSiteLaxMode)
+	c.SetCookie(magicLinkNonceCookie, nonce, int(magicLinkExpiryDuration.Seconds()), magicLinkCookiePath, "", c.Request.TLS != nil, true)
+
+	var user models.User
+	if err := database.DB.Db.Where("email = ?", request.Email).First(&user).Error; err == nil {
+		token, err := utils.CreateActionToken(user.ID, utils.PurposeMagicLink, nonce, magicLinkExpiryDuration)
+		if err != nil {
+			utils.SendErrorResponse(c, http.StatusInternalServerError, "Failed to create login link")
+			return
+		}
+
+		link := fmt.Sprintf("%s/api/auth/magic-link/verify?token=%s", utils.PublicURL(), url.QueryEscape(token))
+
+		subject := "Your login link"
+		body := fmt.Sprintf("<h1>Log in</h1><p><a href=\"%s\">Click here to log in</a>.</p><p>This link will expire in 15 minutes and only works in the browser where you requested it.</p>", html.EscapeString(link))
+		if err := utils.SendEmail(user.Email, subject, body); err != nil {
+			utils.SendErrorResponse(c, http.StatusInternalServerError, "Failed to send login link email")
+			return
+		}
+	}
+
+	utils.SendResponse(c, http.StatusOK, true, "If an account exists for this email, a login link has been sent", gin.H{
+		"email": request.Email,
+	})
+}
+
+func VerifyMagicLink(c *gin.Context) {
+	token := c.Query("token")
+	if token == "" {
+		utils.SendErrorResponse(c, http.StatusBadRequest, "Token is required")
+		return
+	}
+
+	nonce, err := c.Cookie(magicLinkNonceCookie)
+	if err != nil || nonce == "" {
+		utils.SendErrorResponse(c, http.StatusUnauthorized, "Open the link in the browser where you requested it")
+		return
+	}
+
+	actionToken, err := utils.ConsumeActionToken(token, utils.PurposeMagicLink, nonce)
+	if err != nil {
+		utils.SendErrorResponse(c, http.StatusUnauthorized, "Invalid, expired or already used login link")
+		return
+	}
+
+	c.SetSameSite(http.SameSiteLaxMode)
+	c.SetCookie(magicLinkNonceCookie, "", -1, magicLinkCookiePath, "", c.Request.TLS != nil, true)
+
+	var user models.User
+	if err := database.DB.Db.Where("id = ?", actionToken.UserID).First(&user).Error; err != nil {
+		utils.SendErrorResponse(c, http.StatusUnauthorized, "User not found")
+		return
+	}
+
+	completeLogin(c, user, "", utils.LoginMethodMagicLink)
+}
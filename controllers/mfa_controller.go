@@ -0,0 +1,270 @@
+package controllers
+
+import (
+	"encoding/base64"
+	"errors"
+	"net/http"
+	"time"
+
+	"github.com/gin-gonic/gin"
+	"github.com/google/uuid"
+	"github.com/pramek008/go-jwt-project/database"
+	"github.com/pramek008/go-jwt-project/models"
+	"github.com/pramek008/go-jwt-project/utils"
+	"gorm.io/gorm"
+)
+
+func EnrollTOTP(c *gin.Context) {
+	userID, _ := c.Get("user_id")
+
+	var user models.User
+	if err := database.DB.Db.Where("id = ?", userID).First(&user).Error; err != nil {
+		utils.SendErrorResponse(c, http.StatusNotFound, "User not found")
+		return
+	}
+
+	if utils.UserHasMFA(user.ID) {
+		utils.SendErrorResponse(c, http.StatusConflict, "Two-factor authentication is already enabled")
+		return
+	}
+
+	key, err := utils.GenerateTOTPKey(user.Email)
+	if err != nil {
+		utils.SendErrorResponse(c, http.StatusInternalServerError, "Failed to generate TOTP secret")
+		return
+	}
+
+	qrCode, err := utils.TOTPQRCodePNG(key)
+	if err != nil {
+		utils.SendErrorResponse(c, http.StatusInternalServerError, "Failed to generate QR code")
+		return
+	}
+
+	// Starting over replaces any enrolment that was never confirmed
+	database.DB.Db.Where("user_id = ? AND confirmed_at IS NULL", user.ID).Delete(&models.TOTPFactor{})
+
+	factor := models.TOTPFactor{
+		UserID: user.ID,
+		Secret: key.Secret(),
+	}
+	if err := database.DB.Db.Create(&factor).Error; err != nil {
+		utils.SendErrorResponse(c, http.StatusInternalServerError, "Failed to save TOTP secret")
+		return
+	}
+
+	utils.SendResponse(c, http.StatusOK, true, "Scan the QR code and confirm with a code from your authenticator", gin.H{
+		"secret":     key.Secret(),
+		"otpauthUrl": key.URL(),
+		"qrCode":     "data:image/png;base64," + base64.StdEncoding.EncodeToString(qrCode),
+	})
+}
+
+func ConfirmTOTP(c *gin.Context) {
+	var request struct {
+		Code string `json:"code" binding:"required,len=6"`
+	}
+
+	if err := c.ShouldBindJSON(&request); err != nil {
+		utils.SendErrorResponse(c, http.StatusBadRequest, "Invalid request data")
+		return
+	}
+
+	userID, _ := c.Get("user_id")
+
+	var factor models.TOTPFactor
+	if err := database.DB.Db.Where("user_id = ? AND confirmed_at IS NULL", userID).First(&factor).Error; err != nil {
+		utils.SendErrorResponse(c, http.StatusNotFound, "No pending TOTP enrolment")
+		return
+	}
+
+	if !utils.ValidateTOTPCode(&factor, request.Code) {
+		utils.SendErrorResponse(c, http.StatusBadRequest, "Invalid TOTP code")
+		return
+	}
+
+	var recoveryCodes []string
+	err := database.DB.Db.Transaction(func(tx *gorm.DB) error {
+		if err := tx.Model(&factor).Update("confirmed_at", time.Now()).Error; err != nil {
+			return err
+		}
+
+		codes, err := utils.GenerateRecoveryCodes(tx, factor.UserID)
+		recoveryCodes = codes
+		return err
+	})
+	if err != nil {
+		utils.SendErrorResponse(c, http.StatusInternalServerError, "Failed to enable two-factor authentication")
+		return
+	}
+
+	utils.SendResponse(c, http.StatusOK, true, "Two-factor authentication enabled, store your recovery codes safely", gin.H{
+		"recoveryCodes": recoveryCodes,
+	})
+}
+
+func DisableTOTP(c *gin.Context) {
+	var request struct {
+		Password string `json:"password" binding:"required"`
+		Code     string `json:"code" binding:"required"`
+	}
+
+	if err := c.ShouldBindJSON(&request); err != nil {
+		utils.SendErrorResponse(c, http.StatusBadRequest, "Invalid request data")
+		return
+	}
+
+	userID, _ := c.Get("user_id")
+
+	var user models.User
+	if err := database.DB.Db.Where("id = ?", userID).First(&user).Error; err != nil {
+		utils.SendErrorResponse(c, http.StatusNotFound, "User not found")
+		return
+	}
+
+	if err := utils.VerifyPassword(user.Password, request.Password); err != nil {
+		utils.SendErrorResponse(c, http.StatusUnauthorized, "Invalid password")
+		return
+	}
+
+	factor, err := utils.FindConfirmedTOTPFactor(user.ID)
+	if err != nil {
+		utils.SendErrorResponse(c, http.StatusNotFound, "Two-factor authentication is not enabled")
+		return
+	}
+
+	if !utils.ValidateTOTPCode(factor, request.Code) && !utils.UseRecoveryCode(user.ID, request.Code) {
+		utils.SendErrorResponse(c, http.StatusUnauthorized, "Invalid TOTP or recovery code")
+		return
+	}
+
+	err = database.DB.Db.Transaction(func(tx *gorm.DB) error {
+		if err := tx.Where("user_id = ?", user.ID).Delete(&models.RecoveryCode{}).Error; err != nil {
+			return err
+		}
+		return tx.Where("user_id = ?", user.ID).Delete(&models.TOTPFactor{}).Error
+	})
+	if err != nil {
+		utils.SendErrorResponse(c, http.StatusInternalServerError, "Failed to disable two-factor authentication")
+		return
+	}
+
+	utils.SendResponse[map[string]interface{}](c, http.StatusOK, true, "Two-factor authentication disabled", nil)
+}
+
+func RegenerateRecoveryCodes(c *gin.Context) {
+	var request struct {
+		Code string `json:"code" binding:"required,len=6"`
+	}
+
+	if err := c.ShouldBindJSON(&request); err != nil {
+		utils.SendErrorResponse(c, http.StatusBadRequest, "Invalid request data")
+		return
+	}
+
+	userID, _ := c.Get("user_id")
+
+	factor, err := utils.FindConfirmedTOTPFactor(userID.(uuid.UUID))
+	if err != nil {
+		utils.SendErrorResponse(c, http.StatusNotFound, "Two-factor authentication is not enabled")
+		return
+	}
+
+	if !utils.ValidateTOTPCode(factor, request.Code) {
+		utils.SendErrorResponse(c, http.StatusUnauthorized, "Invalid TOTP code")
+		return
+	}
+
+	recoveryCodes, err := utils.GenerateRecoveryCodes(database.DB.Db, factor.UserID)
+	if err != nil {
+		utils.SendErrorResponse(c, http.StatusInternalServerError, "Failed to generate recovery codes")
+		return
+	}
+
+	utils.SendResponse(c, http.StatusOK, true, "Recovery codes regenerated", gin.H{
+		"recoveryCodes": recoveryCodes,
+	})
+}
+
+// LoginMFA exchanges the "mfa_pending" token returned by Login and a TOTP or
+// recovery code for a real session.
+func LoginMFA(c *gin.Context) {
+	var request struct {
+		MFAToken     string `json:"mfaToken" binding:"required"`
+		Code         string `json:"code"`
+		RecoveryCode string `json:"recoveryCode"`
+	}
+
+	if err := c.ShouldBindJSON(&request); err != nil {
+		utils.SendErrorResponse(c, http.StatusBadRequest, "Invalid request data")
+		return
+	}
+
+	if request.Code == "" && request.RecoveryCode == "" {
+		utils.SendErrorResponse(c, http.StatusBadRequest, "Code or recovery code is required")
+		return
+	}
+
+	challenge, err := utils.StartMFAChallengeAttempt(request.MFAToken)
+	if errors.Is(err, utils.ErrMFAChallengeInvalid) {
+		utils.SendErrorResponse(c, http.StatusUnauthorized, "MFA challenge is invalid or expired, please log in again")
+		return
+	} else if err != nil {
+		utils.SendErrorResponse(c, http.StatusInternalServerError, "Failed to verify MFA challenge")
+		return
+	}
+
+	var user models.User
+	if err := database.DB.Db.Where("id = ?", challenge.UserID).First(&user).Error; err != nil {
+		utils.SendErrorResponse(c, http.StatusUnauthorized, "User not found")
+		return
+	}
+
+	// Wrong codes count towards the same throttle and lockout as wrong
+	// passwords, a challenge only limits the guesses per login
+	if utils.IsAccountLocked(user.ID) {
+		utils.SendErrorResponse(c, http.StatusLocked, "Account is temporarily locked, check your email for an unlock link")
+		return
+	}
+	if wait := utils.LoginRetryAfter(utils.LoginThrottleKeyUser(user.ID)); wait > 0 {
+		sendRetryAfter(c, wait)
+		return
+	}
+
+	factor, err := utils.FindConfirmedTOTPFactor(user.ID)
+	if err != nil {
+		utils.SendErrorResponse(c, http.StatusUnauthorized, "Two-factor authentication is not enabled")
+		return
+	}
+
+	verified := false
+	if request.Code != "" {
+		verified = utils.ValidateTOTPCode(factor, request.Code)
+	} else {
+		verified = utils.UseRecoveryCode(user.ID, request.RecoveryCode)
+	}
+	if !verified {
+		recordLoginEvent(c, user.ID, nil, models.LoginEventFailure, utils.LoginMethodMFA, "invalid_code")
+		locked, _ := utils.RecordAccountLoginFailure(user.ID)
+		if locked {
+			sendUnlockEmail(c, user)
+			utils.SendErrorResponse(c, http.StatusLocked, "Account is temporarily locked, check your email for an unlock link")
+			return
+		}
+		utils.SendErrorResponse(c, http.StatusUnauthorized, "Invalid TOTP or recovery code")
+		return
+	}
+	utils.ResetLoginFailures(utils.LoginThrottleKeyUser(user.ID))
+
+	if !utils.CompleteMFAChallenge(challenge) {
+		utils.SendErrorResponse(c, http.StatusUnauthorized, "MFA challenge has already been used")
+		return
+	}
+
+	tokens, err := startSession(c, user, challenge.DeviceName, utils.LoginMethodMFA)
+	if err != nil {
+		sendStartSessionError(c, err)
+		return
+	}
+
+	utils.SendResponse(c, http.StatusOK, true, "Login successful", sessionResponse(user, tokens))
+}
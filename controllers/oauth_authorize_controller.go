@@ -0,0 +1,254 @@
+package controllers
+
+import (
+	"net/http"
+	"net/url"
+
+	"github.com/gin-gonic/gin"
+	"github.com/google/uuid"
+	"github.com/pramek008/go-jwt-project/database"
+	"github.com/pramek008/go-jwt-project/logger"
+	"github.com/pramek008/go-jwt-project/models"
+	"github.com/pramek008/go-jwt-project/utils"
+)
+
+// authorizationRequest is the authorization request of RFC 6749 section
+// 4.1.1. The GET endpoint reads it from the query string with the OAuth
+// parameter names, the consent form posts it back as JSON.
+type authorizationRequest struct {
+	ResponseType        string `form:"response_type" json:"responseType"`
+	ClientID            string `form:"client_id" json:"clientId"`
+	RedirectURI         string `form:"redirect_uri" json:"redirectUri"`
+	Scope               string `form:"scope" json:"scope"`
+	State               string `form:"state" json:"state"`
+	CodeChallenge       string `form:"code_challenge" json:"codeChallenge"`
+	CodeChallengeMethod string `form:"code_challenge_method" json:"codeChallengeMethod"`
+	Nonce               string `form:"nonce" json:"nonce" binding:"max=255"`
+}
+
+// Authorize validates an authorization request for the logged-in user. When
+// the user already granted the scopes the client is sent straight back with
+// a code, otherwise the frontend shows the consent screen and posts the
+// answer to ApproveAuthorization.
+func Authorize(c *gin.Context) {
+	var request authorizationRequest
+	if err := c.ShouldBindQuery(&request); err != nil {
+		utils.SendErrorResponse(c, http.StatusBadRequest, err.Error())
+		return
+	}
+	request.ResponseType = firstNonEmpty(request.ResponseType, "code")
+
+	client, scope, ok := validateAuthorizationRequest(c, &request)
+	if !ok {
+		return
+	}
+
+	userID, _ := c.Get("user_id")
+	if utils.HasRememberedGrant(userID.(uuid.UUID), client.ClientID, scope) {
+		issueAuthorizationCode(c, client, userID.(uuid.UUID), &request, scope)
+		return
+	}
+
+	utils.SendResponse(c, http.StatusOK, true, "Consent required", gin.H{
+		"consentRequired": true,
+		"client": gin.H{
+			"clientId": client.ClientID,
+			"name":     client.Name,
+		},
+		"scopes":  utils.ParseScopes(scope),
+		"request": request,
+	})
+}
+
+// ApproveAuthorization records the user's answer on the consent screen.
+func ApproveAuthorization(c *gin.Context) {
+	var request struct {
+		authorizationRequest
+		Approve  bool `json:"approve"`
+		Remember bool `json:"remember"`
+	}
+	if err := c.ShouldBindJSON(&request); err != nil {
+		utils.SendErrorResponse(c, http.StatusBadRequest, err.Error())
+		return
+	}
+	request.ResponseType = firstNonEmpty(request.ResponseType, "code")
+
+	client, scope, ok := validateAuthorizationRequest(c, &request.authorizationRequest)
+	if !ok {
+		return
+	}
+
+	if !request.Approve {
+		sendAuthorizationRedirect(c, request.RedirectURI, url.Values{
+			"error": {"access_denied"},
+			"state": {request.State},
+		})
+		return
+	}
+
+	userID, _ := c.Get("user_id")
+	if request.Remember {
+		if err := utils.RememberGrant(userID.(uuid.UUID), client.ClientID, scope); err != nil {
+			logger.FromContext(c).Error("Failed to remember OAuth grant", "client_id", client.ClientID, "error", err)
+			utils.SendErrorResponse(c, http.StatusInternalServerError, "Failed to save consent")
+			return
+		}
+	}
+
+	issueAuthorizationCode(c, client, userID.(uuid.UUID), &request.authorizationRequest, scope)
+}
+
+// validateAuthorizationRequest responds itself when the request is invalid.
+// An unknown client or redirect URI is reported to the user, since sending
+// them to an unverified URI would make this an open redirect; anything else
+// is reported to the client through the redirect URI.
+func validateAuthorizationRequest(c *gin.Context, request *authorizationRequest) (*models.OAuthClient, string, bool) {
+	client, err := utils.FindOAuthClient(request.ClientID)
+	if err != nil {
+		utils.SendErrorResponse(c, http.StatusBadRequest, "Unknown OAuth client")
+		return nil, "", false
+	}
+	if !client.HasRedirectURI(request.RedirectURI) {
+		utils.SendErrorResponse(c, http.StatusBadRequest, "Redirect URI is not registered for this client")
+		return nil, "", false
+	}
+
+	errorCode, description := "", ""
+	scope, scopeErr := utils.ValidateClientScope(client, request.Scope)
+	switch {
+	case !client.HasGrantType(models.GrantTypeAuthorizationCode):
+		errorCode, description = "unauthorized_client", "Client may not use the authorization code grant"
+	case request.ResponseType != "code":
+		errorCode, description = "unsupported_response_type", "Only the code response type is supported"
+	case request.CodeChallengeMethod != utils.CodeChallengeMethodS256 || !utils.IsValidCodeChallenge(request.CodeChallenge):
+		errorCode, description = "invalid_request", "PKCE with the S256 method is required"
+	case scopeErr != nil:
+		errorCode, description = "invalid_scope", "Requested scope is not allowed for this client"
+	}
+
+	if errorCode != "" {
+		sendAuthorizationRedirect(c, request.RedirectURI, url.Values{
+			"error":             {errorCode},
+			"error_description": {description},
+			"state":             {request.State},
+		})
+		return nil, "", false
+	}
+
+	return client, scope, true
+}
+
+func issueAuthorizationCode(c *gin.Context, client *models.OAuthClient, userID uuid.UUID, request *authorizationRequest, scope string) {
+	// auth_time in the id_token is when the user logged in to the session
+	// that approved the request
+	sessionID, _ := c.Get("session_id")
+	loginSessionID := sessionID.(uuid.UUID)
+	loginSession, err := utils.FindActiveSession(userID, loginSessionID)
+	if err != nil {
+		utils.SendErrorResponse(c, http.StatusUnauthorized, "Unauthorized, session has been revoked")
+		return
+	}
+
+	code, err := utils.CreateAuthorizationCode(client, models.OAuthAuthorizationCode{
+		UserID:         userID,
+		RedirectURI:    request.RedirectURI,
+		Scope:          scope,
+		CodeChallenge:  request.CodeChallenge,
+		Nonce:          request.Nonce,
+		AuthTime:       loginSession.CreatedAt,
+		LoginSessionID: &loginSessionID,
+	})
+	if err != nil {
+		logger.FromContext(c).Error("Failed to create authorization code", "client_id", client.ClientID, "error", err)
+		utils.SendErrorResponse(c, http.StatusInternalServerError, "Failed to create authorization code")
+		return
+	}
+
+	sendAuthorizationRedirect(c, request.RedirectURI, url.Values{
+		"code":  {code},
+		"state": {request.State},
+	})
+}
+
+// sendAuthorizationRedirect hands the frontend the URI to send the user back
+// to the client with. The API is called with a bearer token, so it cannot
+// redirect the browser itself.
+func sendAuthorizationRedirect(c *gin.Context, redirectURI string, params url.Values) {
+	target, _ := url.Parse(redirectURI)
+	query := target.Query()
+	for key, values := range params {
+		if values[0] != "" {
+			query.Set(key, values[0])
+		}
+	}
+	target.RawQuery = query.Encode()
+
+	utils.SendResponse(c, http.StatusOK, true, "Redirect to client", gin.H{
+		"consentRequired": false,
+		"redirectTo":      target.String(),
+	})
+}
+
+func firstNonEmpty(value, fallback string) string {
+	if value == "" {
+		return fallback
+	}
+	return value
+}
+
+// ListOAuthGrants lists the clients the user has given remembered consent to.
+func ListOAuthGrants(c *gin.Context) {
+	userID, _ := c.Get("user_id")
+
+	var grants []models.OAuthGrant
+	if err := database.DB.Db.Where("user_id = ?", userID).Order("updated_at desc").Find(&grants).Error; err != nil {
+		utils.SendErrorResponse(c, http.StatusInternalServerError, "Failed to fetch OAuth grants")
+		return
+	}
+
+	clientNames := map[string]string{}
+	var clients []models.OAuthClient
+	clientIDs := make([]string, 0, len(grants))
+	for _, grant := range grants {
+		clientIDs = append(clientIDs, grant.ClientID)
+	}
+	if err := database.DB.Db.Where("client_id IN ?", clientIDs).Find(&clients).Error; err == nil {
+		for _, client := range clients {
+			clientNames[client.ClientID] = client.Name
+		}
+	}
+
+	grantResponses := []gin.H{}
+	for _, grant := range grants {
+		grantResponses = append(grantResponses, gin.H{
+			"id":         grant.ID,
+			"clientId":   grant.ClientID,
+			"clientName": clientNames[grant.ClientID],
+			"scopes":     utils.ParseScopes(grant.Scope),
+			"createdAt":  grant.CreatedAt,
+			"updatedAt":  grant.UpdatedAt,
+		})
+	}
+
+	utils.SendResponse(c, http.StatusOK, true, "OAuth grants fetched successfully", grantResponses)
+}
+
+// RevokeOAuthGrant withdraws consent from a client and logs it out of the
+// account.
+func RevokeOAuthGrant(c *gin.Context) {
+	userID, _ := c.Get("user_id")
+
+	var grant models.OAuthGrant
+	if err := database.DB.Db.Where("id = ? AND user_id = ?", c.Param("id"), userID).First(&grant).Error; err != nil {
+		utils.SendErrorResponse(c, http.StatusNotFound, "OAuth grant not found")
+		return
+	}
+
+	if err := utils.RevokeGrant(grant.UserID, grant.ClientID); err != nil {
+		logger.FromContext(c).Error("Failed to revoke OAuth grant", "client_id", grant.ClientID, "error", err)
+		utils.SendErrorResponse(c, http.StatusInternalServerError, "Failed to revoke OAuth grant")
+		return
+	}
+
+	utils.SendResponse[map[string]interface{}](c, http.StatusOK, true, "OAuth grant revoked successfully", nil)
+}
@@ -0,0 +1,118 @@
+package controllers
+
+import (
+	"errors"
+	"net/http"
+
+	"github.com/gin-gonic/gin"
+	"github.com/google/uuid"
+	"github.com/pramek008/go-jwt-project/database"
+	"github.com/pramek008/go-jwt-project/models"
+	"github.com/pramek008/go-jwt-project/utils"
+)
+
+func AdminListOAuthClients(c *gin.Context) {
+	var clients []models.OAuthClient
+	if err := database.DB.Db.Order("created_at desc").Find(&clients).Error; err != nil {
+		utils.SendErrorResponse(c, http.StatusInternalServerError, "Failed to fetch OAuth clients")
+		return
+	}
+
+	utils.SendResponse(c, http.StatusOK, true, "OAuth clients fetched successfully", clients)
+}
+
+func AdminCreateOAuthClient(c *gin.Context) {
+	var request struct {
+		Name         string   `json:"name" binding:"required,max=255"`
+		ClientType   string   `json:"clientType" binding:"omitempty,oneof=confidential public"`
+		RedirectURIs []string `json:"redirectUris" binding:"omitempty,dive,url"`
+		// Where RP-initiated logout may send the user afterwards
+		PostLogoutRedirectURIs []string `json:"postLogoutRedirectUris" binding:"omitempty,dive,url"`
+		Scopes                 []string `json:"scopes"`
+		GrantTypes             []string `json:"grantTypes" binding:"omitempty,dive,oneof=authorization_code refresh_token client_credentials"`
+		// Lets a backend service call token introspection
+		Introspection bool `json:"introspection"`
+	}
+
+	if err := c.ShouldBindJSON(&request); err != nil {
+		utils.SendErrorResponse(c, http.StatusBadRequest, err.Error())
+		return
+	}
+
+	if request.ClientType == "" {
+		request.ClientType = models.OAuthClientConfidential
+	}
+
+	if request.Introspection && request.ClientType == models.OAuthClientPublic {
+		utils.SendErrorResponse(c, http.StatusBadRequest, "Public clients cannot introspect tokens")
+		return
+	}
+
+	// "*" is reserved for interactive sessions
+	for _, scope := range request.Scopes {
+		if !utils.IsClientScope(scope) {
+			utils.SendErrorResponse(c, http.StatusBadRequest, "Unknown scope "+scope)
+			return
+		}
+	}
+
+	for _, grantType := range request.GrantTypes {
+		switch grantType {
+		case models.GrantTypeAuthorizationCode:
+			if len(request.RedirectURIs) == 0 {
+				utils.SendErrorResponse(c, http.StatusBadRequest, "The authorization_code grant needs at least one redirect URI")
+				return
+			}
+		case models.GrantTypeClientCredentials:
+			if request.ClientType == models.OAuthClientPublic {
+				utils.SendErrorResponse(c, http.StatusBadRequest, "Public clients cannot use the client_credentials grant")
+				return
+			}
+		}
+	}
+
+	secret, client, err := utils.CreateOAuthClient(request.Name, request.ClientType, request.RedirectURIs, request.PostLogoutRedirectURIs, request.Scopes, request.GrantTypes, request.Introspection)
+	if err != nil {
+		utils.SendErrorResponse(c, http.StatusInternalServerError, "Failed to create OAuth client")
+		return
+	}
+
+	if client.IsPublic() {
+		utils.SendResponse(c, http.StatusCreated, true, "OAuth client created successfully", client)
+		return
+	}
+
+	utils.SendResponse(c, http.StatusCreated, true, "OAuth client created, copy the secret now as it will not be shown again", gin.H{
+		"id":                     client.ID,
+		"name":                   client.Name,
+		"clientId":               client.ClientID,
+		"clientSecret":           secret,
+		"clientType":             client.ClientType,
+		"redirectUris":           client.RedirectURIs,
+		"postLogoutRedirectUris": client.PostLogoutRedirectURIs,
+		"scopes":                 client.Scopes,
+		"grantTypes":             client.GrantTypes,
+		"introspection":          client.Introspection,
+	})
+}
+
+// AdminRevokeOAuthClient disables the client and revokes every session and
+// token it holds, so users stop being accessed through it right away.
+func AdminRevokeOAuthClient(c *gin.Context) {
+	id, err := uuid.Parse(c.Param("id"))
+	if err != nil {
+		utils.SendErrorResponse(c, http.StatusBadRequest, "Invalid client id")
+		return
+	}
+
+	err = utils.RevokeOAuthClient(id)
+	if errors.Is(err, utils.ErrInvalidOAuthClient) {
+		utils.SendErrorResponse(c, http.StatusNotFound, "OAuth client not found")
+		return
+	} else if err != nil {
+		utils.SendErrorResponse(c, http.StatusInternalServerError, "Failed to revoke OAuth client")
+		return
+	}
+
+	utils.SendResponse[map[string]interface{}](c, http.StatusOK, true, "OAuth client revoked successfully", nil)
+}
@@ -0,0 +1,182 @@
+package controllers
+
+import (
+	"errors"
+	"net/http"
+	"strings"
+
+	"github.com/gin-gonic/gin"
+	"github.com/pramek008/go-jwt-project/logger"
+	"github.com/pramek008/go-jwt-project/models"
+	"github.com/pramek008/go-jwt-project/utils"
+)
+
+// IntrospectToken implements RFC 7662 for our backend services. Responses
+// are plain RFC-shaped JSON, not the usual API envelope. Partner clients
+// are not allowed to look into other clients' tokens.
+func IntrospectToken(c *gin.Context) {
+	client := c.MustGet("oauth_client").(*models.OAuthClient)
+	if !client.Introspection {
+		sendOAuthError(c, http.StatusForbidden, "unauthorized_client", "Client may not introspect tokens")
+		return
+	}
+
+	token := c.PostForm("token")
+	if token == "" {
+		sendOAuthError(c, http.StatusBadRequest, "invalid_request", "token is required")
+		return
+	}
+
+	c.Header("Cache-Control", "no-store")
+	c.JSON(http.StatusOK, utils.IntrospectToken(token, c.PostForm("token_type_hint")))
+}
+
+// RevokeToken implements RFC 7009. The response is 200 whether or not the
+// token was valid, so callers cannot probe for tokens. A client may only
+// revoke tokens issued to itself.
+func RevokeToken(c *gin.Context) {
+	client := c.MustGet("oauth_client").(*models.OAuthClient)
+
+	token := c.PostForm("token")
+	if token == "" {
+		sendOAuthError(c, http.StatusBadRequest, "invalid_request", "token is required")
+		return
+	}
+
+	err := utils.RevokeToken(token, c.PostForm("token_type_hint"), client.ClientID)
+	if errors.Is(err, utils.ErrTokenNotIssuedToClient) {
+		sendOAuthError(c, http.StatusBadRequest, "unauthorized_client", "Token was not issued to this client")
+		return
+	} else if err != nil {
+		logger.FromContext(c).Error("Failed to revoke token", "error", err)
+		sendOAuthError(c, http.StatusServiceUnavailable, "temporarily_unavailable", "Failed to revoke token")
+		return
+	}
+
+	c.Status(http.StatusOK)
+}
+
+// Token implements the token endpoint for the authorization_code,
+// refresh_token and client_credentials grants.
+func Token(c *gin.Context) {
+	client := c.MustGet("oauth_client").(*models.OAuthClient)
+
+	grantType := c.PostForm("grant_type")
+	switch grantType {
+	case models.GrantTypeAuthorizationCode, models.GrantTypeRefreshToken, models.GrantTypeClientCredentials:
+	default:
+		sendOAuthError(c, http.StatusBadRequest, "unsupported_grant_type", "Unsupported grant_type")
+		return
+	}
+
+	if !client.HasGrantType(grantType) || (grantType == models.GrantTypeClientCredentials && client.IsPublic()) {
+		sendOAuthError(c, http.StatusBadRequest, "unauthorized_client", "Client may not use the "+grantType+" grant")
+		return
+	}
+
+	switch grantType {
+	case models.GrantTypeAuthorizationCode:
+		exchangeAuthorizationCode(c, client)
+	case models.GrantTypeRefreshToken:
+		refreshDelegatedToken(c, client)
+	case models.GrantTypeClientCredentials:
+		issueClientCredentialsToken(c, client)
+	}
+}
+
+func exchangeAuthorizationCode(c *gin.Context, client *models.OAuthClient) {
+	code := c.PostForm("code")
+	redirectURI := c.PostForm("redirect_uri")
+	codeVerifier := c.PostForm("code_verifier")
+	if code == "" || redirectURI == "" || codeVerifier == "" {
+		sendOAuthError(c, http.StatusBadRequest, "invalid_request", "code, redirect_uri and code_verifier are required")
+		return
+	}
+
+	tokens, err := utils.ExchangeAuthorizationCode(client, code, redirectURI, codeVerifier, utils.OIDCIssuer())
+	if errors.Is(err, utils.ErrInvalidGrant) {
+		sendOAuthError(c, http.StatusBadRequest, "invalid_grant", "Invalid, expired or already used authorization code")
+		return
+	} else if err != nil {
+		logger.FromContext(c).Error("Failed to exchange authorization code", "error", err)
+		sendOAuthError(c, http.StatusInternalServerError, "server_error", "Failed to issue tokens")
+		return
+	}
+
+	recordLoginEvent(c, tokens.UserID, &tokens.SessionID, models.LoginEventSuccess, utils.LoginMethodOAuth, "")
+	sendTokenResponse(c, tokens.AccessToken, tokens.RefreshToken, tokens.IDToken, tokens.ExpiresIn, tokens.Scope)
+}
+
+func refreshDelegatedToken(c *gin.Context, client *models.OAuthClient) {
+	refreshToken := c.PostForm("refresh_token")
+	if refreshToken == "" {
+		sendOAuthError(c, http.StatusBadRequest, "invalid_request", "refresh_token is required")
+		return
+	}
+
+	tokens, err := utils.RotateRefreshToken(refreshToken, client.ClientID)
+	if errors.Is(err, utils.ErrRefreshTokenReused) || errors.Is(err, utils.ErrInvalidRefreshToken) {
+		sendOAuthError(c, http.StatusBadRequest, "invalid_grant", "Invalid, expired or already used refresh token")
+		return
+	} else if err != nil {
+		logger.FromContext(c).Error("Failed to refresh delegated token", "error", err)
+		sendOAuthError(c, http.StatusInternalServerError, "server_error", "Failed to issue tokens")
+		return
+	}
+
+	recordLoginEvent(c, tokens.UserID, &tokens.SessionID, models.LoginEventTokenRefresh, utils.LoginMethodRefreshToken, "")
+	sendTokenResponse(c, tokens.AccessToken, tokens.RefreshToken, "", tokens.ExpiresIn, tokens.Scope)
+}
+
+func issueClientCredentialsToken(c *gin.Context, client *models.OAuthClient) {
+	scope := c.PostForm("scope")
+	if scope == "" {
+		scope = utils.ScopePostsRead
+	}
+
+	scope, err := utils.ValidateClientScope(client, scope)
+	if err != nil {
+		sendOAuthError(c, http.StatusBadRequest, "invalid_scope", "Requested scope is not allowed for this client")
+		return
+	}
+
+	accessToken, expiresIn, err := utils.IssueClientCredentialsToken(client, scope)
+	if errors.Is(err, utils.ErrInvalidScope) {
+		sendOAuthError(c, http.StatusBadRequest, "invalid_scope", "Client credentials tokens are limited to "+strings.Join(utils.ClientCredentialsScopes, " "))
+		return
+	} else if err != nil {
+		logger.FromContext(c).Error("Failed to issue client credentials token", "error", err)
+		sendOAuthError(c, http.StatusInternalServerError, "server_error", "Failed to issue token")
+		return
+	}
+
+	sendTokenResponse(c, accessToken, "", "", expiresIn, scope)
+}
+
+// sendTokenResponse writes the RFC 6749 section 5.1 token response, with the
+// OpenID Connect id_token when there is one.
+func sendTokenResponse(c *gin.Context, accessToken, refreshToken, idToken string, expiresIn int64, scope string) {
+	response := gin.H{
+		"access_token": accessToken,
+		"token_type":   "Bearer",
+		"expires_in":   expiresIn,
+		"scope":        scope,
+	}
+	if refreshToken != "" {
+		response["refresh_token"] = refreshToken
+	}
+	if idToken != "" {
+		response["id_token"] = idToken
+	}
+
+	c.Header("Cache-Control", "no-store")
+	c.JSON(http.StatusOK, response)
+}
+
+func sendOAuthError(c *gin.Context, status int, code, description string) {
+	c.Header("Cache-Control", "no-store")
+	c.JSON(status, gin.H{
+		"error":             code,
+		"error_description": description,
+	})
+}
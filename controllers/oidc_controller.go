@@ -0,0 +1,152 @@
+package controllers
+
+import (
+	"net/http"
+	"net/url"
+	"os"
+	"strings"
+
+	"github.com/gin-gonic/gin"
+	"github.com/google/uuid"
+	"github.com/pramek008/go-jwt-project/database"
+	"github.com/pramek008/go-jwt-project/logger"
+	"github.com/pramek008/go-jwt-project/models"
+	"github.com/pramek008/go-jwt-project/utils"
+)
+
+// GetOpenIDConfiguration publishes the OpenID Connect discovery document.
+// The authorization endpoint is the frontend page that shows the consent
+// screen, set with OIDC_AUTHORIZATION_ENDPOINT, since the API endpoint
+// behind it needs the user's bearer token. OIDC_END_SESSION_ENDPOINT is
+// likewise the page that confirms a logout.
+func GetOpenIDConfiguration(c *gin.Context) {
+	apiURL := utils.PublicURL()
+
+	authorizationEndpoint := os.Getenv("OIDC_AUTHORIZATION_ENDPOINT")
+	if authorizationEndpoint == "" {
+		authorizationEndpoint = apiURL + "/api/oauth/authorize"
+	}
+	endSessionEndpoint := os.Getenv("OIDC_END_SESSION_ENDPOINT")
+	if endSessionEndpoint == "" {
+		endSessionEndpoint = apiURL + "/api/oauth/logout"
+	}
+
+	scopes := append(append([]string{}, utils.OIDCScopes...), utils.KnownScopes...)
+
+	c.Header("Cache-Control", "public, max-age=300")
+	c.JSON(http.StatusOK, gin.H{
+		"issuer":                                utils.OIDCIssuer(),
+		"authorization_endpoint":                authorizationEndpoint,
+		"token_endpoint":                        apiURL + "/api/oauth/token",
+		"userinfo_endpoint":                     apiURL + "/api/oauth/userinfo",
+		"jwks_uri":                              apiURL + "/.well-known/jwks.json",
+		"end_session_endpoint":                  endSessionEndpoint,
+		"introspection_endpoint":                apiURL + "/api/oauth/introspect",
+		"revocation_endpoint":                   apiURL + "/api/oauth/revoke",
+		"scopes_supported":                      scopes,
+		"response_types_supported":              []string{"code"},
+		"response_modes_supported":              []string{"query"},
+		"grant_types_supported":                 []string{models.GrantTypeAuthorizationCode, models.GrantTypeRefreshToken, models.GrantTypeClientCredentials},
+		"subject_types_supported":               []string{"public"},
+		"id_token_signing_alg_values_supported": []string{utils.IDTokenSigningAlg()},
+		"token_endpoint_auth_methods_supported": []string{"client_secret_basic", "client_secret_post", "none"},
+		"code_challenge_methods_supported":      []string{utils.CodeChallengeMethodS256},
+		"claims_supported":                      []string{"sub", "iss", "aud", "exp", "iat", "auth_time", "nonce", "sid", "email", "email_verified", "preferred_username", "nickname", "updated_at"},
+	})
+}
+
+// UserInfo returns the standard claims of the token's user, limited to the
+// scopes the client was granted.
+func UserInfo(c *gin.Context) {
+	userID, _ := c.Get("user_id")
+	scopes, _ := c.Get("scopes")
+
+	var user models.User
+	if err := database.DB.Db.First(&user, "id = ?", userID).Error; err != nil {
+		utils.SendErrorResponse(c, http.StatusNotFound, "User not found")
+		return
+	}
+
+	c.Header("Cache-Control", "no-store")
+	c.JSON(http.StatusOK, utils.UserInfoClaims(user, scopes.([]string)))
+}
+
+// EndSession implements OpenID Connect RP-initiated logout. The user is
+// identified by id_token_hint, there is no browser cookie to go by. Any page
+// can send the browser here, so a GET only describes the logout and the
+// frontend confirms it by posting the same parameters. The POST revokes the
+// client's own sessions, and the login session the id_token came from only
+// when the request carries an access token of that session. The user is
+// then sent to post_logout_redirect_uri when it is registered for the
+// client.
+func EndSession(c *gin.Context) {
+	idTokenHint := c.Request.FormValue("id_token_hint")
+	if idTokenHint == "" {
+		utils.SendErrorResponse(c, http.StatusBadRequest, "id_token_hint is required")
+		return
+	}
+
+	claims, err := utils.ParseIDTokenHint(idTokenHint, utils.OIDCIssuer())
+	if err != nil {
+		utils.SendErrorResponse(c, http.StatusBadRequest, "Invalid id_token_hint")
+		return
+	}
+
+	clientID := claims.Audience[0]
+	if requested := c.Request.FormValue("client_id"); requested != "" && requested != clientID {
+		utils.SendErrorResponse(c, http.StatusBadRequest, "client_id does not match id_token_hint")
+		return
+	}
+
+	client, err := utils.FindOAuthClient(clientID)
+	if err != nil {
+		utils.SendErrorResponse(c, http.StatusBadRequest, "Unknown OAuth client")
+		return
+	}
+
+	postLogoutRedirectURI := c.Request.FormValue("post_logout_redirect_uri")
+	if postLogoutRedirectURI != "" && !client.HasPostLogoutRedirectURI(postLogoutRedirectURI) {
+		utils.SendErrorResponse(c, http.StatusBadRequest, "post_logout_redirect_uri is not registered for this client")
+		return
+	}
+
+	if c.Request.Method == http.MethodGet {
+		utils.SendResponse(c, http.StatusOK, true, "Logout confirmation required", gin.H{
+			"confirmationRequired": true,
+			"client": gin.H{
+				"clientId": client.ClientID,
+				"name":     client.Name,
+			},
+			"postLogoutRedirectUri": postLogoutRedirectURI,
+		})
+		return
+	}
+
+	userID := uuid.MustParse(claims.Subject)
+	var loginSessionID *uuid.UUID
+	if sid, err := uuid.Parse(claims.Sid); err == nil {
+		bearer := strings.TrimPrefix(c.GetHeader("Authorization"), "Bearer ")
+		if bearer != "" && utils.IsLoginSessionToken(bearer, userID, sid) {
+			loginSessionID = &sid
+		}
+	}
+
+	if err := utils.EndOIDCSession(userID, loginSessionID, client.ClientID); err != nil {
+		logger.FromContext(c).Error("Failed to end OIDC session", "user_id", userID, "client_id", client.ClientID, "error", err)
+		utils.SendErrorResponse(c, http.StatusInternalServerError, "Failed to log out")
+		return
+	}
+
+	if postLogoutRedirectURI == "" {
+		utils.SendResponse[map[string]interface{}](c, http.StatusOK, true, "Logout successful", nil)
+		return
+	}
+
+	target, _ := url.Parse(postLogoutRedirectURI)
+	if state := c.Request.FormValue("state"); state != "" {
+		query := target.Query()
+		query.Set("state", state)
+		target.RawQuery = query.Encode()
+	}
+	c.Redirect(http.StatusFound, target.String())
+}
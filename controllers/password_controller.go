@@ -0,0 +1,101 @@
+package controllers
+
+import (
+	"fmt"
+	"html"
+	"net/http"
+	"time"
+
+	"github.com/gin-gonic/gin"
+	"github.com/google/uuid"
+	"github.com/pramek008/go-jwt-project/database"
+	"github.com/pramek008/go-jwt-project/logger"
+	"github.com/pramek008/go-jwt-project/models"
+	"github.com/pramek008/go-jwt-project/passpolicy"
+	"github.com/pramek008/go-jwt-project/utils"
+)
+
+// ChangePassword sets a new password after checking the current one. The
+// current session stays logged in, every other session is revoked.
+func ChangePassword(c *gin.Context) {
+	var request struct {
+		CurrentPassword string `json:"currentPassword" binding:"required"`
+		NewPassword     string `json:"newPassword" binding:"required"`
+	}
+
+	if err := c.ShouldBindJSON(&request); err != nil {
+		utils.SendErrorResponse(c, http.StatusBadRequest, "Invalid request data")
+		return
+	}
+
+	userID, _ := c.Get("user_id")
+	currentSessionID, _ := c.Get("session_id")
+
+	var user models.User
+	if err := database.DB.Db.First(&user, "id = ?", userID).Error; err != nil {
+		utils.SendErrorResponse(c, http.StatusNotFound, "User not found")
+		return
+	}
+
+	if err := utils.VerifyPassword(user.Password, request.CurrentPassword); err != nil {
+		utils.SendErrorResponse(c, http.StatusUnauthorized, "Current password is incorrect")
+		return
+	}
+
+	if request.NewPassword == request.CurrentPassword {
+		utils.SendErrorResponse(c, http.StatusBadRequest, "New password must be different from the current password")
+		return
+	}
+
+	if !checkPasswordPolicy(c, request.NewPassword, user.Nickname, user.Email) {
+		return
+	}
+
+	hashedPassword, err := utils.HashPassword(request.NewPassword)
+	if err != nil {
+		utils.SendErrorResponse(c, http.StatusInternalServerError, "Failed to hash password")
+		return
+	}
+
+	if err := database.DB.Db.Model(&user).Update("password", hashedPassword).Error; err != nil {
+		utils.SendErrorResponse(c, http.StatusInternalServerError, "Failed to save user")
+		return
+	}
+
+	revoked, err := utils.RevokeCredentials(user.ID, currentSessionID.(uuid.UUID))
+	if err != nil {
+		utils.SendErrorResponse(c, http.StatusInternalServerError, "Password changed but failed to revoke other sessions")
+		return
+	}
+
+	sendPasswordChangedEmail(c, user)
+
+	utils.SendResponse(c, http.StatusOK, true, "Password changed successfully", gin.H{
+		"revokedSessions": revoked,
+	})
+}
+
+// sendPasswordChangedEmail warns the owner about the change, in case it was
+// not them. Failing to send it does not undo the change.
+func sendPasswordChangedEmail(c *gin.Context, user models.User) {
+	subject := "Your password has been changed"
+	body := fmt.Sprintf("<h1>Password changed</h1><p>Hi %s, the password for your account was changed on %s from %s. Other sessions on your account have been logged out and its personal access tokens revoked.</p><p>If this was not you, reset your password right away with the forgot password option.</p>",
+		html.EscapeString(user.Nickname), time.Now().Format(time.RFC1123), html.EscapeString(c.ClientIP()))
+	if err := utils.SendEmail(user.Email, subject, body); err != nil {
+		logger.FromContext(c).Error("Failed to send password changed email", "user_id", user.ID, "error", err)
+	}
+}
+
+// checkPasswordPolicy answers 422 with every violation when the new password
+// does not meet the policy, and reports whether it did.
+func checkPasswordPolicy(c *gin.Context, password, nickname, email string) bool {
+	violations := passpolicy.Validate(password, nickname, email)
+	if len(violations) == 0 {
+		return true
+	}
+
+	utils.SendResponse(c, http.StatusUnprocessableEntity, false, "Password does not meet the password policy", gin.H{
+		"violations": violations,
+	})
+	return false
+}
@@ -0,0 +1,82 @@
+package controllers
+
+import (
+	"net/http"
+	"time"
+
+	"github.com/gin-gonic/gin"
+	"github.com/google/uuid"
+	"github.com/pramek008/go-jwt-project/database"
+	"github.com/pramek008/go-jwt-project/models"
+	"github.com/pramek008/go-jwt-project/utils"
+)
+
+const maxPersonalAccessTokenDays = 365
+
+func CreatePersonalAccessToken(c *gin.Context) {
+	var request struct {
+		Name          string   `json:"name" binding:"required,max=255"`
+		Scopes        []string `json:"scopes" binding:"required,min=1"`
+		ExpiresInDays int      `json:"expiresInDays" binding:"required,min=1"`
+	}
+
+	if err := c.ShouldBindJSON(&request); err != nil {
+		utils.SendErrorResponse(c, http.StatusBadRequest, err.Error())
+		return
+	}
+
+	if request.ExpiresInDays > maxPersonalAccessTokenDays {
+		utils.SendErrorResponse(c, http.StatusBadRequest, "Tokens can be valid for at most 365 days")
+		return
+	}
+
+	for _, scope := range request.Scopes {
+		if !utils.IsKnownScope(scope) {
+			utils.SendErrorResponse(c, http.StatusBadRequest, "Unknown scope: "+scope)
+			return
+		}
+	}
+
+	userID, _ := c.Get("user_id")
+	expiresAt := time.Now().AddDate(0, 0, request.ExpiresInDays)
+
+	rawToken, token, err := utils.CreatePersonalAccessToken(userID.(uuid.UUID), request.Name, request.Scopes, expiresAt)
+	if err != nil {
+		utils.SendErrorResponse(c, http.StatusInternalServerError, "Failed to create personal access token")
+		return
+	}
+
+	utils.SendResponse(c, http.StatusCreated, true, "Personal access token created, copy it now as it will not be shown again", gin.H{
+		"id":        token.ID,
+		"name":      token.Name,
+		"scopes":    utils.ParseScopes(token.Scopes),
+		"expiresAt": token.ExpiresAt,
+		"token":     rawToken,
+	})
+}
+
+func ListPersonalAccessTokens(c *gin.Context) {
+	userID, _ := c.Get("user_id")
+
+	var tokens []models.PersonalAccessToken
+	if err := database.DB.Db.Where("user_id = ? AND revoked_at IS NULL", userID).Order("created_at desc").Find(&tokens).Error; err != nil {
+		utils.SendErrorResponse(c, http.StatusInternalServerError, "Failed to fetch personal access tokens")
+		return
+	}
+
+	utils.SendResponse(c, http.StatusOK, true, "Personal access tokens fetched successfully", tokens)
+}
+
+func RevokePersonalAccessToken(c *gin.Context) {
+	userID, _ := c.Get("user_id")
+
+	result := database.DB.Db.Model(&models.PersonalAccessToken{}).
+		Where("id = ? AND user_id = ? AND revoked_at IS NULL", c.Param("id"), userID).
+		Update("revoked_at", time.Now())
+	if result.Error != nil || result.RowsAffected == 0 {
+		utils.SendErrorResponse(c, http.StatusNotFound, "Personal access token not found")
+		return
+	}
+
+	utils.SendResponse[map[string]interface{}](c, http.StatusOK, true, "Personal access token revoked successfully", nil)
+}
@@ -69,7 +69,7 @@ func UpdatePost(c *gin.Context) {
 	}
 
 	userID, _ := c.Get("user_id")
-	if post.UserID != userID.(uuid.UUID) {
+	if post.UserID != userID.(uuid.UUID) && !utils.ContextHasPermission(c, models.PermissionPostsUpdateAny) {
 		utils.SendErrorResponse(c, http.StatusForbidden, "Not authorized to update this post")
 		return
 	}
@@ -115,7 +115,7 @@ func DeletePost(c *gin.Context) {
 	}
 
 	userID, _ := c.Get("user_id")
-	if post.UserID != userID.(uuid.UUID) {
+	if post.UserID != userID.(uuid.UUID) && !utils.ContextHasPermission(c, models.PermissionPostsDeleteAny) {
 		utils.SendErrorResponse(c, http.StatusForbidden, "Not authorized to delete this post")
 		return
 	}
@@ -0,0 +1,225 @@
+package controllers
+
+import (
+	"net/http"
+
+	"github.com/gin-gonic/gin"
+	"github.com/pramek008/go-jwt-project/database"
+	"github.com/pramek008/go-jwt-project/models"
+	"github.com/pramek008/go-jwt-project/utils"
+	"gorm.io/gorm"
+)
+
+func ListRoles(c *gin.Context) {
+	var roles []models.Role
+	if err := database.DB.Db.Preload("Permissions").Order("name").Find(&roles).Error; err != nil {
+		utils.SendErrorResponse(c, http.StatusInternalServerError, "Failed to fetch roles")
+		return
+	}
+
+	utils.SendResponse(c, http.StatusOK, true, "Roles fetched successfully", roles)
+}
+
+func ListPermissions(c *gin.Context) {
+	var permissions []models.Permission
+	if err := database.DB.Db.Order("name").Find(&permissions).Error; err != nil {
+		utils.SendErrorResponse(c, http.StatusInternalServerError, "Failed to fetch permissions")
+		return
+	}
+
+	utils.SendResponse(c, http.StatusOK, true, "Permissions fetched successfully", permissions)
+}
+
+func CreateRole(c *gin.Context) {
+	var request struct {
+		Name        string   `json:"name" binding:"required,max=64"`
+		Description string   `json:"description" binding:"max=255"`
+		Permissions []string `json:"permissions"`
+	}
+
+	if err := c.ShouldBindJSON(&request); err != nil {
+		utils.SendErrorResponse(c, http.StatusBadRequest, err.Error())
+		return
+	}
+
+	permissions, ok := findPermissions(c, request.Permissions)
+	if !ok {
+		return
+	}
+
+	role := models.Role{
+		Name:        request.Name,
+		Description: request.Description,
+		Permissions: permissions,
+	}
+	if err := database.DB.Db.Create(&role).Error; err != nil {
+		utils.SendErrorResponse(c, http.StatusConflict, "Role with this name already exists")
+		return
+	}
+
+	utils.SendResponse(c, http.StatusCreated, true, "Role created successfully", role)
+}
+
+func UpdateRolePermissions(c *gin.Context) {
+	var request struct {
+		Permissions []string `json:"permissions"`
+	}
+
+	if err := c.ShouldBindJSON(&request); err != nil {
+		utils.SendErrorResponse(c, http.StatusBadRequest, "Invalid request data")
+		return
+	}
+
+	var role models.Role
+	if err := database.DB.Db.First(&role, "id = ?", c.Param("id")).Error; err != nil {
+		utils.SendErrorResponse(c, http.StatusNotFound, "Role not found")
+		return
+	}
+
+	// Admins must not be able to lock everybody out of role management
+	if role.Name == models.RoleAdmin {
+		utils.SendErrorResponse(c, http.StatusBadRequest, "Permissions of the admin role cannot be changed")
+		return
+	}
+
+	permissions, ok := findPermissions(c, request.Permissions)
+	if !ok {
+		return
+	}
+
+	if err := database.DB.Db.Model(&role).Association("Permissions").Replace(permissions); err != nil {
+		utils.SendErrorResponse(c, http.StatusInternalServerError, "Failed to update role permissions")
+		return
+	}
+	role.Permissions = permissions
+
+	utils.SendResponse(c, http.StatusOK, true, "Role permissions updated successfully", role)
+}
+
+func DeleteRole(c *gin.Context) {
+	var role models.Role
+	if err := database.DB.Db.First(&role, "id = ?", c.Param("id")).Error; err != nil {
+		utils.SendErrorResponse(c, http.StatusNotFound, "Role not found")
+		return
+	}
+
+	switch role.Name {
+	case models.RoleAdmin, models.RoleModerator, models.RoleUser:
+		utils.SendErrorResponse(c, http.StatusBadRequest, "Built-in roles cannot be deleted")
+		return
+	}
+
+	err := database.DB.Db.Transaction(func(tx *gorm.DB) error {
+		if err := tx.Model(&role).Association("Permissions").Clear(); err != nil {
+			return err
+		}
+		if err := tx.Exec("DELETE FROM user_roles WHERE role_id = ?", role.ID).Error; err != nil {
+			return err
+		}
+		return tx.Delete(&role).Error
+	})
+	if err != nil {
+		utils.SendErrorResponse(c, http.StatusInternalServerError, "Failed to delete role")
+		return
+	}
+
+	utils.SendResponse[map[string]interface{}](c, http.StatusOK, true, "Role deleted successfully", nil)
+}
+
+func AssignUserRole(c *gin.Context) {
+	var request struct {
+		Role string `json:"role" binding:"required"`
+	}
+
+	if err := c.ShouldBindJSON(&request); err != nil {
+		utils.SendErrorResponse(c, http.StatusBadRequest, "Invalid request data")
+		return
+	}
+
+	userID, ok := userIDParam(c)
+	if !ok {
+		return
+	}
+
+	var user models.User
+	if err := database.DB.Db.First(&user, "id = ?", userID).Error; err != nil {
+		utils.SendErrorResponse(c, http.StatusNotFound, "User not found")
+		return
+	}
+
+	if err := database.AssignRole(database.DB.Db, user.ID, request.Role); err != nil {
+		utils.SendErrorResponse(c, http.StatusNotFound, "Role not found")
+		return
+	}
+
+	sendUserRoles(c, user, "Role assigned successfully")
+}
+
+func RemoveUserRole(c *gin.Context) {
+	userID, ok := userIDParam(c)
+	if !ok {
+		return
+	}
+
+	var user models.User
+	if err := database.DB.Db.First(&user, "id = ?", userID).Error; err != nil {
+		utils.SendErrorResponse(c, http.StatusNotFound, "User not found")
+		return
+	}
+
+	var role models.Role
+	if err := database.DB.Db.Where("name = ?", c.Param("role")).First(&role).Error; err != nil {
+		utils.SendErrorResponse(c, http.StatusNotFound, "Role not found")
+		return
+	}
+
+	if role.Name == models.RoleAdmin {
+		var admins int64
+		database.DB.Db.Table("user_roles").Where("role_id = ?", role.ID).Count(&admins)
+		if admins <= 1 {
+			utils.SendErrorResponse(c, http.StatusBadRequest, "Cannot remove the last admin")
+			return
+		}
+	}
+
+	if err := database.DB.Db.Model(&user).Association("Roles").Delete(&role); err != nil {
+		utils.SendErrorResponse(c, http.StatusInternalServerError, "Failed to remove role")
+		return
+	}
+
+	sendUserRoles(c, user, "Role removed successfully")
+}
+
+func sendUserRoles(c *gin.Context, user models.User, message string) {
+	roles, err := utils.UserRoleNames(user.ID)
+	if err != nil {
+		utils.SendErrorResponse(c, http.StatusInternalServerError, "Failed to fetch user roles")
+		return
+	}
+
+	utils.SendResponse(c, http.StatusOK, true, message, gin.H{
+		"id":    user.ID,
+		"roles": roles,
+	})
+}
+
+// findPermissions resolves permission names and responds with an error if
+// any of them does not exist.
+func findPermissions(c *gin.Context, names []string) ([]models.Permission, bool) {
+	permissions := []models.Permission{}
+	if len(names) == 0 {
+		return permissions, true
+	}
+
+	if err := database.DB.Db.Where("name IN ?", names).Find(&permissions).Error; err != nil {
+		utils.SendErrorResponse(c, http.StatusInternalServerError, "Failed to fetch permissions")
+		return nil, false
+	}
+
+	if len(permissions) != len(names) {
+		utils.SendErrorResponse(c, http.StatusBadRequest, "Unknown permission in list")
+		return nil, false
+	}
+
+	return permissions, true
+}
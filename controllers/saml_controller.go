@@ -0,0 +1,132 @@
+package controllers
+
+import (
+	"crypto/subtle"
+	"errors"
+	"net/http"
+
+	"github.com/gin-gonic/gin"
+	"github.com/pramek008/go-jwt-project/federation"
+	"github.com/pramek008/go-jwt-project/logger"
+	"github.com/pramek008/go-jwt-project/utils"
+)
+
+const (
+	samlRelayStateCookie    = "saml_relay_state"
+	samlCookiePath          = "/api/auth/saml"
+	samlRelayStateCookieAge = 10 * 60
+)
+
+// GetSAMLMetadata serves the service provider metadata the tenant registers
+// at their identity provider.
+func GetSAMLMetadata(c *gin.Context) {
+	tenant, err := federation.GetSAMLTenant(c.Param("tenant"))
+	if err != nil {
+		utils.SendErrorResponse(c, http.StatusNotFound, "SAML tenant not found")
+		return
+	}
+
+	metadataURL, acsURL := samlURLs(tenant)
+	metadata, err := tenant.Metadata(metadataURL, acsURL)
+	if err != nil {
+		logger.FromContext(c).Error("Failed to generate SAML metadata", "tenant", tenant.ID, "error", err)
+		utils.SendErrorResponse(c, http.StatusInternalServerError, "Failed to generate metadata")
+		return
+	}
+
+	c.Data(http.StatusOK, "application/samlmetadata+xml", metadata)
+}
+
+// BeginSAMLLogin redirects the browser to the tenant's identity provider
+// with a signed AuthnRequest. Like BeginFederatedLogin, the relay state also
+// goes into a cookie so the ACS only accepts the browser that started it.
+func BeginSAMLLogin(c *gin.Context) {
+	tenant, err := federation.GetSAMLTenant(c.Param("tenant"))
+	if err != nil {
+		utils.SendErrorResponse(c, http.StatusNotFound, "SAML tenant not found")
+		return
+	}
+
+	relayState, err := utils.GenerateNonce()
+	if err != nil {
+		utils.SendErrorResponse(c, http.StatusInternalServerError, "Failed to start login")
+		return
+	}
+
+	metadataURL, acsURL := samlURLs(tenant)
+	redirectURL, requestID, err := tenant.AuthnRequestURL(c.Request.Context(), metadataURL, acsURL, relayState)
+	if err != nil {
+		logger.FromContext(c).Error("Failed to create SAML request", "tenant", tenant.ID, "error", err)
+		utils.SendErrorResponse(c, http.StatusBadGateway, "Identity provider is unavailable")
+		return
+	}
+
+	if err := utils.SaveSAMLRequest(relayState, tenant.ID, requestID, c.Query("deviceName")); err != nil {
+		utils.SendErrorResponse(c, http.StatusInternalServerError, "Failed to start login")
+		return
+	}
+
+	setSAMLRelayStateCookie(c, relayState, samlRelayStateCookieAge)
+	c.Redirect(http.StatusFound, redirectURL)
+}
+
+// SAMLAssertionConsumer is the ACS the identity provider posts its response
+// to. The assertion is checked by federation, used at most once, and then
+// logs the user in like a federated OIDC login. New users only get an
+// account if the tenant has just-in-time provisioning on.
+func SAMLAssertionConsumer(c *gin.Context) {
+	tenant, err := federation.GetSAMLTenant(c.Param("tenant"))
+	if err != nil {
+		utils.SendErrorResponse(c, http.StatusNotFound, "SAML tenant not found")
+		return
+	}
+
+	relayState := c.PostForm("RelayState")
+	cookieRelayState, _ := c.Cookie(samlRelayStateCookie)
+	setSAMLRelayStateCookie(c, "", -1)
+
+	if relayState == "" || subtle.ConstantTimeCompare([]byte(relayState), []byte(cookieRelayState)) != 1 {
+		utils.SendErrorResponse(c, http.StatusUnauthorized, "Open the login in the browser where you started it")
+		return
+	}
+
+	request, err := utils.ConsumeSAMLRequest(relayState, tenant.ID)
+	if err != nil {
+		utils.SendErrorResponse(c, http.StatusUnauthorized, "Login has expired or was already completed, please try again")
+		return
+	}
+
+	metadataURL, acsURL := samlURLs(tenant)
+	assertion, err := tenant.ParseResponse(c.Request.Context(), metadataURL, acsURL, c.Request, request.RequestID)
+	if err != nil {
+		logger.FromContext(c).Warn("SAML login failed", "tenant", tenant.ID, "error", err)
+		utils.SendErrorResponse(c, http.StatusUnauthorized, "Failed to verify the login with the identity provider")
+		return
+	}
+
+	if err := utils.RecordSAMLAssertion(tenant.ID, assertion.ID, assertion.ExpiresAt); errors.Is(err, utils.ErrSAMLReplay) {
+		logger.FromContext(c).Warn("SAML assertion replayed", "tenant", tenant.ID, "assertion_id", assertion.ID)
+		utils.SendErrorResponse(c, http.StatusUnauthorized, "Failed to verify the login with the identity provider")
+		return
+	} else if err != nil {
+		utils.SendErrorResponse(c, http.StatusInternalServerError, "Failed to complete login")
+		return
+	}
+
+	loginFederatedIdentity(c, utils.SAMLIdentityProvider(tenant.ID), &assertion.Identity, request.DeviceName, utils.LoginMethodSAML, tenant.JITProvisioning)
+}
+
+// samlURLs returns the tenant's entity ID, which is also its metadata URL,
+// and its ACS URL.
+func samlURLs(tenant *federation.SAMLTenant) (string, string) {
+	tenantURL := utils.PublicURL() + samlCookiePath + "/" + tenant.ID
+	return tenantURL + "/metadata", tenantURL + "/acs"
+}
+
+// The identity provider posts to the ACS from its own site, and browsers
+// only send SameSite=None cookies along with a cross-site POST. Those have
+// to be Secure, which browsers also accept on http://localhost.
+func setSAMLRelayStateCookie(c *gin.Context, value string, maxAge int) {
+	c.SetSameSite(http.SameSiteNoneMode)
+	c.SetCookie(samlRelayStateCookie, value, maxAge, samlCookiePath, "", true, true)
+}
@@ -0,0 +1,180 @@
+package controllers
+
+import (
+	"errors"
+	"net/http"
+	"time"
+
+	"github.com/gin-gonic/gin"
+	"github.com/google/uuid"
+	"github.com/pramek008/go-jwt-project/database"
+	"github.com/pramek008/go-jwt-project/logger"
+	"github.com/pramek008/go-jwt-project/models"
+	"github.com/pramek008/go-jwt-project/utils"
+)
+
+var (
+	errUserSuspended  = errors.New("user is suspended")
+	errAccountDeleted = errors.New("account has been deleted")
+)
+
+// startSession records a new device session for the user and issues the
+// token pair bound to it. Every successful login ends here, so this is also
+// where it is added to the login history.
+func startSession(c *gin.Context, user models.User, deviceName, method string) (*utils.TokenPair, error) {
+	if user.IsSuspended(time.Now()) {
+		return nil, errUserSuspended
+	}
+
+	// Logging in during the grace period restores a deleted account
+	if user.DeletionScheduledAt != nil {
+		if !time.Now().Before(*user.DeletionScheduledAt) {
+			return nil, errAccountDeleted
+		}
+		if _, err := utils.RestoreAccount(user.ID); err != nil {
+			return nil, err
+		}
+		logger.FromContext(c).Info("Account restored by login", "user_id", user.ID)
+	}
+
+	if deviceName == "" {
+		deviceName = "Unknown device"
+	}
+
+	session, err := utils.CreateSession(user.ID, deviceName, c.Request.UserAgent(), c.ClientIP())
+	if err != nil {
+		return nil, err
+	}
+
+	tokens, err := utils.GenerateTokenPair(user.ID, session.ID)
+	if err != nil {
+		return nil, err
+	}
+
+	if recordLoginEvent(c, user.ID, &session.ID, models.LoginEventSuccess, method, "") {
+		sendNewDeviceEmail(c, user, deviceName)
+	}
+
+	return tokens, nil
+}
+
+// sendStartSessionError responds to a failed startSession call.
+func sendStartSessionError(c *gin.Context, err error) {
+	if errors.Is(err, errUserSuspended) {
+		utils.SendErrorResponse(c, http.StatusForbidden, "Account is suspended")
+		return
+	}
+	if errors.Is(err, errAccountDeleted) {
+		utils.SendErrorResponse(c, http.StatusGone, "Account has been deleted")
+		return
+	}
+	utils.SendErrorResponse(c, http.StatusInternalServerError, "Failed to generate token")
+}
+
+// completeLogin finishes a login once the user has proven the first factor.
+// Users with an authenticator only get an MFA challenge token at this point.
+func completeLogin(c *gin.Context, user models.User, deviceName, method string) {
+	if user.IsSuspended(time.Now()) {
+		sendStartSessionError(c, errUserSuspended)
+		return
+	}
+
+	if utils.UserHasMFA(user.ID) {
+		mfaToken, err := utils.CreateMFAChallenge(user.ID, deviceName)
+		if err != nil {
+			utils.SendErrorResponse(c, http.StatusInternalServerError, "Failed to create MFA challenge")
+			return
+		}
+		recordLoginEvent(c, user.ID, nil, models.LoginEventMFAChallenge, method, "")
+
+		utils.SendResponse(c, http.StatusOK, true, "Two-factor authentication required", gin.H{
+			"mfaRequired": true,
+			"mfaToken":    mfaToken,
+		})
+		return
+	}
+
+	tokens, err := startSession(c, user, deviceName, method)
+	if err != nil {
+		sendStartSessionError(c, err)
+		return
+	}
+
+	utils.SendResponse(c, http.StatusOK, true, "Login successful", sessionResponse(user, tokens))
+}
+
+// sessionResponse is the payload returned by every login endpoint.
+func sessionResponse(user models.User, tokens *utils.TokenPair) gin.H {
+	return gin.H{
+		"id":           user.ID,
+		"nickname":     user.Nickname,
+		"email":        user.Email,
+		"token":        tokens.AccessToken,
+		"refreshToken": tokens.RefreshToken,
+		"expiresIn":    tokens.ExpiresIn,
+	}
+}
+
+func ListSessions(c *gin.Context) {
+	userID, _ := c.Get("user_id")
+	currentSessionID, _ := c.Get("session_id")
+
+	var sessions []models.Session
+	if err := database.DB.Db.Where("user_id = ? AND revoked_at IS NULL", userID).Order("last_seen_at desc").Find(&sessions).Error; err != nil {
+		utils.SendErrorResponse(c, http.StatusInternalServerError, "Failed to fetch sessions")
+		return
+	}
+
+	sessionResponses := []gin.H{}
+	for _, session := range sessions {
+		sessionResponses = append(sessionResponses, gin.H{
+			"id":         session.ID,
+			"deviceName": session.DeviceName,
+			"userAgent":  session.UserAgent,
+			"ipAddress":  session.IPAddress,
+			"lastSeenAt": session.LastSeenAt,
+			"createdAt":  session.CreatedAt,
+			"current":    session.ID == currentSessionID.(uuid.UUID),
+		})
+	}
+
+	utils.SendResponse(c, http.StatusOK, true, "Sessions fetched successfully", sessionResponses)
+}
+
+func RevokeSession(c *gin.Context) {
+	userID, _ := c.Get("user_id")
+
+	sessionID, err := uuid.Parse(c.Param("id"))
+	if err != nil {
+		utils.SendErrorResponse(c, http.StatusBadRequest, "Invalid session id")
+		return
+	}
+
+	if _, err := utils.FindActiveSession(userID.(uuid.UUID), sessionID); err != nil {
+		utils.SendErrorResponse(c, http.StatusNotFound, "Session not found")
+		return
+	}
+
+	if err := utils.RevokeSession(sessionID); err != nil {
+		utils.SendErrorResponse(c, http.StatusInternalServerError, "Failed to revoke session")
+		return
+	}
+
+	utils.SendResponse[map[string]interface{}](c, http.StatusOK, true, "Session revoked successfully", nil)
+}
+
+// RevokeOtherSessions logs the user out everywhere except the current device.
+func RevokeOtherSessions(c *gin.Context) {
+	userID, _ := c.Get("user_id")
+	currentSessionID, _ := c.Get("session_id")
+
+	revoked, err := utils.RevokeOtherSessions(userID.(uuid.UUID), currentSessionID.(uuid.UUID))
+	if err != nil {
+		utils.SendErrorResponse(c, http.StatusInternalServerError, "Failed to revoke sessions")
+		return
+	}
+
+	utils.SendResponse(c, http.StatusOK, true, "Other sessions revoked successfully", gin.H{
+		"revoked": revoked,
+	})
+}
@@ -0,0 +1,271 @@
+package controllers
+
+import (
+	"bytes"
+	"encoding/json"
+	"errors"
+	"io"
+	"net/http"
+	"time"
+
+	"github.com/gin-gonic/gin"
+	"github.com/go-webauthn/webauthn/protocol"
+	"github.com/go-webauthn/webauthn/webauthn"
+	"github.com/google/uuid"
+	"github.com/pramek008/go-jwt-project/database"
+	"github.com/pramek008/go-jwt-project/models"
+	"github.com/pramek008/go-jwt-project/utils"
+)
+
+func BeginWebAuthnRegistration(c *gin.Context) {
+	userID, _ := c.Get("user_id")
+
+	user, err := utils.LoadWebAuthnUser(userID.(uuid.UUID))
+	if err != nil {
+		utils.SendErrorResponse(c, http.StatusNotFound, "User not found")
+		return
+	}
+
+	relyingParty, err := utils.NewWebAuthn()
+	if err != nil {
+		utils.SendErrorResponse(c, http.StatusInternalServerError, "WebAuthn is not configured")
+		return
+	}
+
+	options, session, err := relyingParty.BeginRegistration(user, webauthn.WithExclusions(user.CredentialExclusions()))
+	if err != nil {
+		utils.SendErrorResponse(c, http.StatusInternalServerError, "Failed to start passkey registration")
+		return
+	}
+
+	sessionID, err := utils.SaveWebAuthnSession(&user.User.ID, utils.WebAuthnCeremonyRegistration, session)
+	if err != nil {
+		utils.SendErrorResponse(c, http.StatusInternalServerError, "Failed to save passkey registration")
+		return
+	}
+
+	utils.SendResponse(c, http.StatusOK, true, "Passkey registration started", gin.H{
+		"sessionId": sessionID,
+		"options":   options,
+	})
+}
+
+func FinishWebAuthnRegistration(c *gin.Context) {
+	var request struct {
+		SessionID  uuid.UUID       `json:"sessionId" binding:"required"`
+		Name       string          `json:"name"`
+		Credential json.RawMessage `json:"credential" binding:"required"`
+	}
+
+	if err := c.ShouldBindJSON(&request); err != nil {
+		utils.SendErrorResponse(c, http.StatusBadRequest, "Invalid request data")
+		return
+	}
+
+	userID, _ := c.Get("user_id")
+
+	record, session, err := utils.ConsumeWebAuthnSession(request.SessionID, utils.WebAuthnCeremonyRegistration)
+	if err != nil || record.UserID == nil || *record.UserID != userID.(uuid.UUID) {
+		utils.SendErrorResponse(c, http.StatusBadRequest, "Passkey registration is invalid or expired")
+		return
+	}
+
+	user, err := utils.LoadWebAuthnUser(*record.UserID)
+	if err != nil {
+		utils.SendErrorResponse(c, http.StatusNotFound, "User not found")
+		return
+	}
+
+	parsed, err := protocol.ParseCredentialCreationResponseBody(bytes.NewReader(request.Credential))
+	if err != nil {
+		utils.SendErrorResponse(c, http.StatusBadRequest, "Invalid passkey response")
+		return
+	}
+
+	relyingParty, err := utils.NewWebAuthn()
+	if err != nil {
+		utils.SendErrorResponse(c, http.StatusInternalServerError, "WebAuthn is not configured")
+		return
+	}
+
+	credential, err := relyingParty.CreateCredential(user, *session, parsed)
+	if err != nil {
+		utils.SendErrorResponse(c, http.StatusBadRequest, "Passkey verification failed")
+		return
+	}
+
+	if request.Name == "" {
+		request.Name = "Passkey"
+	}
+
+	stored := utils.CredentialToModel(user.User.ID, request.Name, credential)
+	if err := database.DB.Db.Create(&stored).Error; err != nil {
+		utils.SendErrorResponse(c, http.StatusConflict, "Passkey is already registered")
+		return
+	}
+
+	utils.SendResponse(c, http.StatusCreated, true, "Passkey registered successfully", stored)
+}
+
+// BeginWebAuthnLogin starts an assertion. With an email the user's own
+// credentials are requested; without one any discoverable passkey for this
+// site may answer.
+func BeginWebAuthnLogin(c *gin.Context) {
+	var request struct {
+		Email string `json:"email" binding:"omitempty,email"`
+	}
+
+	if err := c.ShouldBindJSON(&request); err != nil && !errors.Is(err, io.EOF) {
+		utils.SendErrorResponse(c, http.StatusBadRequest, "Invalid request data")
+		return
+	}
+
+	relyingParty, err := utils.NewWebAuthn()
+	if err != nil {
+		utils.SendErrorResponse(c, http.StatusInternalServerError, "WebAuthn is not configured")
+		return
+	}
+
+	var (
+		options *protocol.CredentialAssertion
+		session *webauthn.SessionData
+		userID  *uuid.UUID
+	)
+
+	var found models.User
+	if request.Email != "" && database.DB.Db.Where("email = ?", request.Email).First(&found).Error == nil {
+		user, err := utils.LoadWebAuthnUser(found.ID)
+		if err == nil && len(user.Credentials) > 0 {
+			options, session, err = relyingParty.BeginLogin(user)
+			if err != nil {
+				utils.SendErrorResponse(c, http.StatusInternalServerError, "Failed to start passkey login")
+				return
+			}
+			userID = &found.ID
+		}
+	}
+
+	// Unknown emails and users without credentials fall back to a
+	// discoverable login, so the response does not reveal which one it was
+	if options == nil {
+		options, session, err = relyingParty.BeginDiscoverableLogin()
+		if err != nil {
+			utils.SendErrorResponse(c, http.StatusInternalServerError, "Failed to start passkey login")
+			return
+		}
+	}
+
+	sessionID, err := utils.SaveWebAuthnSession(userID, utils.WebAuthnCeremonyLogin, session)
+	if err != nil {
+		utils.SendErrorResponse(c, http.StatusInternalServerError, "Failed to save passkey login")
+		return
+	}
+
+	utils.SendResponse(c, http.StatusOK, true, "Passkey login started", gin.H{
+		"sessionId": sessionID,
+		"options":   options,
+	})
+}
+
+func FinishWebAuthnLogin(c *gin.Context) {
+	var request struct {
+		SessionID  uuid.UUID       `json:"sessionId" binding:"required"`
+		DeviceName string          `json:"deviceName"`
+		Credential json.RawMessage `json:"credential" binding:"required"`
+	}
+
+	if err := c.ShouldBindJSON(&request); err != nil {
+		utils.SendErrorResponse(c, http.StatusBadRequest, "Invalid request data")
+		return
+	}
+
+	_, session, err := utils.ConsumeWebAuthnSession(request.SessionID, utils.WebAuthnCeremonyLogin)
+	if err != nil {
+		utils.SendErrorResponse(c, http.StatusUnauthorized, "Passkey login is invalid or expired")
+		return
+	}
+
+	parsed, err := protocol.ParseCredentialRequestResponseBody(bytes.NewReader(request.Credential))
+	if err != nil {
+		utils.SendErrorResponse(c, http.StatusBadRequest, "Invalid passkey response")
+		return
+	}
+
+	relyingParty, err := utils.NewWebAuthn()
+	if err != nil {
+		utils.SendErrorResponse(c, http.StatusInternalServerError, "WebAuthn is not configured")
+		return
+	}
+
+	var (
+		user       *utils.WebAuthnUser
+		credential *webauthn.Credential
+	)
+	if session.UserID == nil {
+		credential, err = relyingParty.ValidateDiscoverableLogin(func(rawID, userHandle []byte) (webauthn.User, error) {
+			user, err = utils.LoadWebAuthnUserByHandle(userHandle)
+			return user, err
+		}, *session, parsed)
+	} else {
+		userID, parseErr := uuid.FromBytes(session.UserID)
+		if parseErr == nil {
+			user, err = utils.LoadWebAuthnUser(userID)
+		}
+		if parseErr == nil && err == nil {
+			credential, err = relyingParty.ValidateLogin(user, *session, parsed)
+		}
+	}
+	if err != nil || credential == nil {
+		utils.SendErrorResponse(c, http.StatusUnauthorized, "Passkey verification failed")
+		return
+	}
+
+	// A sign count that did not increase means the key may have been cloned
+	if credential.Authenticator.CloneWarning {
+		database.DB.Db.Model(&models.WebAuthnCredential{}).
+			Where("credential_id = ?", credential.ID).
+			Update("clone_warning", true)
+		utils.SendErrorResponse(c, http.StatusUnauthorized, "Passkey has been disabled, its signature counter went backwards")
+		return
+	}
+
+	database.DB.Db.Model(&models.WebAuthnCredential{}).
+		Where("credential_id = ? AND user_id = ?", credential.ID, user.User.ID).
+		Updates(map[string]interface{}{
+			"sign_count":   int64(credential.Authenticator.SignCount),
+			"backup_state": credential.Flags.BackupState,
+			"last_used_at": time.Now(),
+		})
+
+	tokens, err := startSession(c, user.User, request.DeviceName, utils.LoginMethodPasskey)
+	if err != nil {
+		sendStartSessionError(c, err)
+		return
+	}
+
+	utils.SendResponse(c, http.StatusOK, true, "Login successful", sessionResponse(user.User, tokens))
+}
+
+func ListWebAuthnCredentials(c *gin.Context) {
+	userID, _ := c.Get("user_id")
+
+	var credentials []models.WebAuthnCredential
+	if err := database.DB.Db.Where("user_id = ?", userID).Order("created_at desc").Find(&credentials).Error; err != nil {
+		utils.SendErrorResponse(c, http.StatusInternalServerError, "Failed to fetch passkeys")
+		return
+	}
+
+	utils.SendResponse(c, http.StatusOK, true, "Passkeys fetched successfully", credentials)
+}
+
+func DeleteWebAuthnCredential(c *gin.Context) {
+	userID, _ := c.Get("user_id")
+
+	result := database.DB.Db.Where("id = ? AND user_id = ?", c.Param("id"), userID).Delete(&models.WebAuthnCredential{})
+	if result.Error != nil || result.RowsAffected == 0 {
+		utils.SendErrorResponse(c, http.StatusNotFound, "Passkey not found")
+		return
+	}
+
+	utils.SendResponse[map[string]interface{}](c, http.StatusOK, true, "Passkey deleted successfully", nil)
+}
@@ -2,17 +2,18 @@ package database
 
 import (
 	"fmt"
-	"log"
+	"log/slog"
 	"os"
 	"strings"
 	"time"
 
 	"github.com/google/uuid"
+	"github.com/pramek008/go-jwt-project/logger"
 	"github.com/pramek008/go-jwt-project/models"
-	"golang.org/x/crypto/bcrypt"
+	"github.com/pramek008/go-jwt-project/passhash"
 	"gorm.io/driver/postgres"
 	"gorm.io/gorm"
-	"gorm.io/gorm/logger"
+	gormlogger "gorm.io/gorm/logger"
 )
 
 type Dbinstance struct {
@@ -32,10 +33,10 @@ func ConnectDb() {
 	)
 
 	defaultDB, err := gorm.Open(postgres.Open(defaultDSN), &gorm.Config{
-		Logger: logger.Default.LogMode(logger.Info),
+		Logger: newGormLogger(),
 	})
 	if err != nil {
-		log.Fatal("Failed to connect to default database: ", err)
+		slog.Error("Failed to connect to default database", "error", err)
 		os.Exit(2)
 	}
 
@@ -45,7 +46,7 @@ func ConnectDb() {
 	if err != nil {
 		// If the database already exists, this is not a fatal error
 		if !strings.Contains(err.Error(), "already exists") {
-			log.Fatal("Failed to create database: ", err)
+			slog.Error("Failed to create database", "error", err)
 			os.Exit(2)
 		}
 	}
@@ -53,7 +54,7 @@ func ConnectDb() {
 	// Close the connection to the default database
 	sqlDB, err := defaultDB.DB()
 	if err != nil {
-		log.Fatal("Failed to get database instance: ", err)
+		slog.Error("Failed to get database instance", "error", err)
 		os.Exit(2)
 	}
 	sqlDB.Close()
@@ -72,40 +73,100 @@ func ConnectDb() {
 	retries := 5
 	for i := 0; i < retries; i++ {
 		db, err = gorm.Open(postgres.Open(appDSN), &gorm.Config{
-			Logger: logger.Default.LogMode(logger.Info),
+			Logger: newGormLogger(),
 		})
 		if err == nil {
 			break
 		}
-		log.Printf("Failed to connect to application database. Retrying in 5 seconds... (%d/%d)\n", i+1, retries)
+		slog.Warn("Failed to connect to application database, retrying in 5 seconds", "attempt", i+1, "retries", retries)
 		time.Sleep(5 * time.Second)
 	}
 
 	if err != nil {
-		log.Fatal("Failed to connect to application database after multiple retries: ", err)
+		slog.Error("Failed to connect to application database after multiple retries", "error", err)
 		os.Exit(2)
 	}
 
-	log.Println("Connected to application database")
-	db.Logger = logger.Default.LogMode(logger.Info)
+	slog.Info("Connected to application database")
+	db.Logger = newGormLogger()
 
 	// Ensure UUID extension is created
 	if err := db.Exec("CREATE EXTENSION IF NOT EXISTS \"uuid-ossp\"").Error; err != nil {
-		log.Fatal("Failed to create UUID extension: ", err)
+		slog.Error("Failed to create UUID extension", "error", err)
 		os.Exit(2)
 	}
 
-	log.Println("Running Migrations")
-	err = db.AutoMigrate(&models.User{}, &models.Post{}, &models.Token{}, &models.TempUser{}, &models.OTP{})
+	slog.Info("Running Migrations")
+
+	// Tokens used to be stored and looked up by the full token string, they
+	// are indexed by jti now. Old rows cannot be mapped so they are dropped,
+	// which logs everybody out once.
+	if db.Migrator().HasTable(&models.Token{}) && db.Migrator().HasColumn(&models.Token{}, "token") {
+		if err := db.Exec("DELETE FROM tokens").Error; err != nil {
+			logger.Fatal("Failed to clear legacy tokens", "error", err)
+		}
+		if err := db.Migrator().DropColumn(&models.Token{}, "token"); err != nil {
+			logger.Fatal("Failed to drop legacy token column", "error", err)
+		}
+	}
+
+	// OTPs used to be stored in plaintext without a purpose. They only live
+	// for a few minutes, so pending codes are dropped and have to be resent.
+	if db.Migrator().HasTable(&models.OTP{}) && db.Migrator().HasColumn(&models.OTP{}, "code") {
+		if err := db.Exec("DELETE FROM otps").Error; err != nil {
+			logger.Fatal("Failed to clear legacy OTPs", "error", err)
+		}
+		if err := db.Migrator().DropColumn(&models.OTP{}, "code"); err != nil {
+			logger.Fatal("Failed to drop legacy OTP code column", "error", err)
+		}
+	}
+
+	err = db.AutoMigrate(
+		&models.User{},
+		&models.Post{},
+		&models.Token{},
+		&models.TempUser{},
+		&models.OTP{},
+		&models.RefreshToken{},
+		&models.Session{},
+		&models.TOTPFactor{},
+		&models.RecoveryCode{},
+		&models.MFAChallenge{},
+		&models.WebAuthnCredential{},
+		&models.WebAuthnSession{},
+		&models.ActionToken{},
+		&models.PersonalAccessToken{},
+		&models.Role{},
+		&models.Permission{},
+		&models.LoginThrottle{},
+		&models.EmailChangeRequest{},
+		&models.EmailChangeRevert{},
+		&models.LoginEvent{},
+		&models.OAuthClient{},
+		&models.OAuthGrant{},
+		&models.OAuthAuthorizationCode{},
+		&models.OAuthClientToken{},
+		&models.UserIdentity{},
+		&models.OIDCLoginState{},
+		&models.IdentityLinkRequest{},
+		&models.SAMLRequest{},
+		&models.SAMLAssertion{},
+	)
 	if err != nil {
-		log.Fatal("Failed to auto migrate: ", err)
+		slog.Error("Failed to auto migrate", "error", err)
+		os.Exit(2)
+	}
+	slog.Info("Migrations completed")
+
+	// Roles and permissions are kept in sync on every start
+	if err := seedRoles(db); err != nil {
+		slog.Error("Failed to seed roles", "error", err)
 		os.Exit(2)
 	}
-	log.Println("Migrations completed")
 
 	// Run seeder
 	if err := seedData(db); err != nil {
-		log.Fatalf("Failed to seed data. Error: %v\n", err)
+		slog.Error("Failed to seed data", "error", err)
 		os.Exit(2)
 	}
 
@@ -114,26 +175,59 @@ func ConnectDb() {
 	}
 }
 
+// newGormLogger sends gorm output through slog. Queries are logged with
+// placeholders instead of their values, which include password hashes and
+// token hashes, and SQL is only logged at LOG_LEVEL=debug.
+func newGormLogger() gormlogger.Interface {
+	level := gormlogger.Warn
+	if logger.ParseLevel(os.Getenv("LOG_LEVEL")) <= slog.LevelDebug {
+		level = gormlogger.Info
+	}
+
+	return gormlogger.New(gormWriter{}, gormlogger.Config{
+		SlowThreshold:             200 * time.Millisecond,
+		LogLevel:                  level,
+		IgnoreRecordNotFoundError: true,
+		ParameterizedQueries:      true,
+	})
+}
+
+type gormWriter struct{}
+
+func (gormWriter) Printf(format string, args ...interface{}) {
+	slog.Info(fmt.Sprintf(format, args...), "component", "gorm")
+}
+
 func seedData(db *gorm.DB) error {
 	// Check if data already exists
 	var userCount int64
 	db.Model(&models.User{}).Count(&userCount)
 	if userCount > 0 {
-		log.Println("Data already seeded")
+		slog.Info("Data already seeded")
 		return nil
 	}
 
 	// Create sample users with UUIDs
 	users := []models.User{
-		{ID: uuid.New(), Nickname: "user1", Email: "user1@example.com", Password: HashPassword("password1")},
-		{ID: uuid.New(), Nickname: "user2", Email: "user2@example.com", Password: HashPassword("password2")},
+		{ID: uuid.New(), Nickname: "user1", Email: "user1@example.com", Password: mustHashPassword("password1")},
+		{ID: uuid.New(), Nickname: "user2", Email: "user2@example.com", Password: mustHashPassword("password2")},
 	}
 
 	for _, user := range users {
 		if err := db.Create(&user).Error; err != nil {
 			return err
 		}
-		log.Printf("Created user: %v", user)
+		slog.Info("Created user", "user", user)
+	}
+
+	// The first sample user administers the others
+	if err := AssignRole(db, users[0].ID, models.RoleAdmin); err != nil {
+		return err
+	}
+	for _, user := range users {
+		if err := AssignRole(db, user.ID, models.RoleUser); err != nil {
+			return err
+		}
 	}
 
 	// Create sample posts
@@ -145,20 +239,58 @@ func seedData(db *gorm.DB) error {
 
 	for _, post := range posts {
 		if err := db.Create(&post).Error; err != nil {
-			log.Printf("Failed to create post: %v, error: %v", post, err)
+			slog.Error("Failed to create post", "title", post.Title, "error", err)
 			return err
 		}
-		log.Printf("Created post: %v", post)
+		slog.Info("Created post", "post_id", post.ID, "title", post.Title)
 	}
 
-	log.Println("Data seeded successfully")
+	slog.Info("Data seeded successfully")
 	return nil
 }
 
-func HashPassword(password string) string {
-	hashedPassword, err := bcrypt.GenerateFromPassword([]byte(password), bcrypt.DefaultCost)
+func seedRoles(db *gorm.DB) error {
+	for _, name := range []string{models.RoleAdmin, models.RoleModerator, models.RoleUser} {
+		role := models.Role{Name: name}
+		if err := db.Where(models.Role{Name: name}).FirstOrCreate(&role).Error; err != nil {
+			return err
+		}
+	}
+
+	for name, roleNames := range models.DefaultPermissions {
+		permission := models.Permission{Name: name}
+		if err := db.Where(models.Permission{Name: name}).FirstOrCreate(&permission).Error; err != nil {
+			return err
+		}
+
+		for _, roleName := range roleNames {
+			var role models.Role
+			if err := db.Where("name = ?", roleName).First(&role).Error; err != nil {
+				return err
+			}
+			if err := db.Model(&role).Association("Permissions").Append(&permission); err != nil {
+				return err
+			}
+		}
+	}
+
+	return nil
+}
+
+// AssignRole gives the user the named role. It lives here rather than in
+// utils since seeding needs it and utils imports this package.
+func AssignRole(db *gorm.DB, userID uuid.UUID, roleName string) error {
+	var role models.Role
+	if err := db.Where("name = ?", roleName).First(&role).Error; err != nil {
+		return err
+	}
+	return db.Model(&models.User{ID: userID}).Association("Roles").Append(&role)
+}
+
+func mustHashPassword(password string) string {
+	hashedPassword, err := passhash.Hash(password)
 	if err != nil {
-		log.Fatalf("Failed to hash password. Error: %v\n", err)
+		logger.Fatal("Failed to hash password", "error", err)
 	}
 	return string(hashedPassword)
 }
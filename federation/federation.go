@@ -0,0 +1,304 @@
+// Package federation logs users in with external identity providers: OpenID
+// Connect providers such as Google, and the SAML 2.0 identity providers of
+// enterprise tenants. Both are listed in JSON files; discovery happens on
+// first use so an unreachable provider does not stop the API from starting.
+// The package only speaks the protocols, linking the result to a
+// models.User is up to the caller.
+package federation
+
+import (
+	"context"
+	"crypto/subtle"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"net/http"
+	"os"
+	"regexp"
+	"sync"
+	"time"
+
+	"github.com/coreos/go-oidc/v3/oidc"
+	"golang.org/x/oauth2"
+)
+
+const httpTimeout = 10 * time.Second
+
+var (
+	ErrUnknownProvider = errors.New("unknown identity provider")
+	ErrMissingIDToken  = errors.New("token response has no id_token")
+	ErrNonceMismatch   = errors.New("id_token nonce does not match")
+)
+
+var providerIDPattern = regexp.MustCompile(`^[a-z0-9_-]{1,64}$`)
+
+var defaultScopes = []string{oidc.ScopeOpenID, "email", "profile"}
+
+// ProviderConfig is one entry of the providers file. ID appears in the login
+// and callback URLs and is stored with every linked identity, so it must not
+// change once users have logged in with the provider.
+type ProviderConfig struct {
+	ID           string   `json:"id"`
+	Name         string   `json:"name"`
+	Issuer       string   `json:"issuer"`
+	ClientID     string   `json:"clientId"`
+	ClientSecret string   `json:"clientSecret"`
+	Scopes       []string `json:"scopes"`
+}
+
+// Identity is what a provider asserts about the user in its id_token.
+type Identity struct {
+	Subject           string
+	Email             string
+	EmailVerified     bool
+	Name              string
+	PreferredUsername string
+}
+
+// Provider is a configured identity provider.
+type Provider struct {
+	ProviderConfig
+
+	mu       sync.Mutex
+	provider *oidc.Provider
+}
+
+// Registry holds the configured providers in file order.
+type Registry struct {
+	providers []*Provider
+}
+
+var (
+	registryMu      sync.RWMutex
+	defaultRegistry = &Registry{}
+)
+
+// Init loads the providers file named by OIDC_PROVIDERS_FILE and the SAML
+// tenants. Without the files no provider is available and federated login
+// is off.
+func Init() error {
+	if err := initSAML(); err != nil {
+		return err
+	}
+
+	path := os.Getenv("OIDC_PROVIDERS_FILE")
+	if path == "" {
+		return nil
+	}
+
+	registry, err := LoadFile(path)
+	if err != nil {
+		return err
+	}
+
+	registryMu.Lock()
+	defaultRegistry = registry
+	registryMu.Unlock()
+	return nil
+}
+
+// Get returns a provider of the registry loaded by Init.
+func Get(id string) (*Provider, error) {
+	registryMu.RLock()
+	defer registryMu.RUnlock()
+	return defaultRegistry.Get(id)
+}
+
+// List returns the providers of the registry loaded by Init.
+func List() []*Provider {
+	registryMu.RLock()
+	defer registryMu.RUnlock()
+	return defaultRegistry.Providers()
+}
+
+// LoadFile reads a providers file. ${VAR} references are expanded from the
+// environment first, so client secrets can stay out of the file:
+//
+//	{"providers": [{"id": "google", "name": "Google",
+//	  "issuer": "https://accounts.google.com",
+//	  "clientId": "...", "clientSecret": "${GOOGLE_CLIENT_SECRET}"}]}
+func LoadFile(path string) (*Registry, error) {
+	data, err := os.ReadFile(path)
+	if err != nil {
+		return nil, err
+	}
+
+	configs, err := ParseConfig(data)
+	if err != nil {
+		return nil, fmt.Errorf("%s: %w", path, err)
+	}
+	return NewRegistry(configs), nil
+}
+
+// ParseConfig parses and validates the providers file contents. Providers
+// without scopes get openid, email and profile; openid is always added.
+func ParseConfig(data []byte) ([]ProviderConfig, error) {
+	var file struct {
+		Providers []ProviderConfig `json:"providers"`
+	}
+	if err := json.Unmarshal([]byte(os.ExpandEnv(string(data))), &file); err != nil {
+		return nil, err
+	}
+
+	seen := map[string]bool{}
+	for i := range file.Providers {
+		config := &file.Providers[i]
+		if !providerIDPattern.MatchString(config.ID) {
+			return nil, fmt.Errorf("provider %d: id must be 1 to 64 lowercase letters, digits, - or _", i)
+		}
+		if seen[config.ID] {
+			return nil, fmt.Errorf("provider %s: duplicate id", config.ID)
+		}
+		seen[config.ID] = true
+
+		if config.Issuer == "" || config.ClientID == "" {
+			return nil, fmt.Errorf("provider %s: issuer and clientId are required", config.ID)
+		}
+		if config.Name == "" {
+			config.Name = config.ID
+		}
+
+		if len(config.Scopes) == 0 {
+			config.Scopes = append([]string{}, defaultScopes...)
+		} else if !contains(config.Scopes, oidc.ScopeOpenID) {
+			config.Scopes = append([]string{oidc.ScopeOpenID}, config.Scopes...)
+		}
+	}
+
+	return file.Providers, nil
+}
+
+func NewRegistry(configs []ProviderConfig) *Registry {
+	registry := &Registry{}
+	for _, config := range configs {
+		registry.providers = append(registry.providers, &Provider{ProviderConfig: config})
+	}
+	return registry
+}
+
+func (r *Registry) Get(id string) (*Provider, error) {
+	for _, provider := range r.providers {
+		if provider.ID == id {
+			return provider, nil
+		}
+	}
+	return nil, ErrUnknownProvider
+}
+
+func (r *Registry) Providers() []*Provider {
+	return append([]*Provider{}, r.providers...)
+}
+
+// AuthCodeURL returns the provider URL to send the user to. The state, nonce
+// and PKCE verifier must be kept until the callback.
+func (p *Provider) AuthCodeURL(ctx context.Context, redirectURL, state, nonce, codeVerifier string) (string, error) {
+	provider, err := p.discover(ctx)
+	if err != nil {
+		return "", err
+	}
+
+	return p.oauth2Config(provider, redirectURL).AuthCodeURL(state,
+		oidc.Nonce(nonce),
+		oauth2.S256ChallengeOption(codeVerifier),
+	), nil
+}
+
+// Exchange redeems the authorization code from the callback and verifies the
+// id_token: signature, issuer, audience, expiry and the nonce sent with
+// AuthCodeURL.
+func (p *Provider) Exchange(ctx context.Context, redirectURL, code, codeVerifier, nonce string) (*Identity, error) {
+	provider, err := p.discover(ctx)
+	if err != nil {
+		return nil, err
+	}
+	ctx = oidc.ClientContext(ctx, &http.Client{Timeout: httpTimeout})
+
+	token, err := p.oauth2Config(provider, redirectURL).Exchange(ctx, code, oauth2.VerifierOption(codeVerifier))
+	if err != nil {
+		return nil, err
+	}
+
+	rawIDToken, ok := token.Extra("id_token").(string)
+	if !ok || rawIDToken == "" {
+		return nil, ErrMissingIDToken
+	}
+
+	idToken, err := provider.Verifier(&oidc.Config{ClientID: p.ClientID}).Verify(ctx, rawIDToken)
+	if err != nil {
+		return nil, err
+	}
+	if subtle.ConstantTimeCompare([]byte(idToken.Nonce), []byte(nonce)) != 1 {
+		return nil, ErrNonceMismatch
+	}
+
+	var claims struct {
+		Email             string    `json:"email"`
+		EmailVerified     claimBool `json:"email_verified"`
+		Name              string    `json:"name"`
+		PreferredUsername string    `json:"preferred_username"`
+	}
+	if err := idToken.Claims(&claims); err != nil {
+		return nil, err
+	}
+
+	return &Identity{
+		Subject:           idToken.Subject,
+		Email:             claims.Email,
+		EmailVerified:     bool(claims.EmailVerified),
+		Name:              claims.Name,
+		PreferredUsername: claims.PreferredUsername,
+	}, nil
+}
+
+// discover fetches the discovery document once. Failures are not cached, so
+// a provider that was down is retried on the next login.
+func (p *Provider) discover(ctx context.Context) (*oidc.Provider, error) {
+	p.mu.Lock()
+	defer p.mu.Unlock()
+
+	if p.provider != nil {
+		return p.provider, nil
+	}
+
+	ctx = oidc.ClientContext(ctx, &http.Client{Timeout: httpTimeout})
+	provider, err := oidc.NewProvider(ctx, p.Issuer)
+	if err != nil {
+		return nil, fmt.Errorf("discovery for %s failed: %w", p.ID, err)
+	}
+
+	p.provider = provider
+	return provider, nil
+}
+
+func (p *Provider) oauth2Config(provider *oidc.Provider, redirectURL string) *oauth2.Config {
+	return &oauth2.Config{
+		ClientID:     p.ClientID,
+		ClientSecret: p.ClientSecret,
+		Endpoint:     provider.Endpoint(),
+		RedirectURL:  redirectURL,
+		Scopes:       p.Scopes,
+	}
+}
+
+// claimBool accepts email_verified as a JSON boolean or, as some providers
+// send it, the string "true".
+type claimBool bool
+
+func (b *claimBool) UnmarshalJSON(data []byte) error {
+	switch string(data) {
+	case `true`, `"true"`:
+		*b = true
+	default:
+		*b = false
+	}
+	return nil
+}
+
+func contains(list []string, value string) bool {
+	for _, item := range list {
+		if item == value {
+			return true
+		}
+	}
+	return false
+}
@@ -0,0 +1,313 @@
+package federation
+
+import (
+	"context"
+	"crypto/rand"
+	"crypto/rsa"
+	"crypto/sha256"
+	"encoding/base64"
+	"encoding/json"
+	"errors"
+	"math/big"
+	"net/http"
+	"net/http/httptest"
+	"net/url"
+	"strings"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/golang-jwt/jwt/v5"
+	"golang.org/x/oauth2"
+)
+
+const (
+	testClientID     = "test-client"
+	testClientSecret = "test-secret"
+	testRedirectURL  = "http://localhost/api/auth/oidc/mock/callback"
+)
+
+// mockIssuer is a minimal OpenID provider: discovery, JWKS and a token
+// endpoint that checks PKCE and the client secret. authorize stands in for
+// the user logging in at the provider.
+type mockIssuer struct {
+	server *httptest.Server
+	key    *rsa.PrivateKey
+
+	mu    sync.Mutex
+	codes map[string]pendingCode
+
+	// Overrides for the next id_token
+	audience      string
+	issuer        string
+	emailVerified interface{}
+}
+
+type pendingCode struct {
+	challenge string
+	nonce     string
+}
+
+func newMockIssuer(t *testing.T) *mockIssuer {
+	t.Helper()
+
+	key, err := rsa.GenerateKey(rand.Reader, 2048)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	m := &mockIssuer{key: key, codes: map[string]pendingCode{}, emailVerified: true}
+	mux := http.NewServeMux()
+	mux.HandleFunc("/.well-known/openid-configuration", m.discovery)
+	mux.HandleFunc("/keys", m.jwks)
+	mux.HandleFunc("/token", m.token)
+	m.server = httptest.NewServer(mux)
+	t.Cleanup(m.server.Close)
+	return m
+}
+
+func (m *mockIssuer) discovery(w http.ResponseWriter, r *http.Request) {
+	json.NewEncoder(w).Encode(map[string]interface{}{
+		"issuer":                                m.server.URL,
+		"authorization_endpoint":                m.server.URL + "/authorize",
+		"token_endpoint":                        m.server.URL + "/token",
+		"jwks_uri":                              m.server.URL + "/keys",
+		"id_token_signing_alg_values_supported": []string{"RS256"},
+	})
+}
+
+func (m *mockIssuer) jwks(w http.ResponseWriter, r *http.Request) {
+	json.NewEncoder(w).Encode(map[string]interface{}{
+		"keys": []map[string]string{{
+			"kty": "RSA",
+			"kid": "mock",
+			"alg": "RS256",
+			"use": "sig",
+			"n":   base64.RawURLEncoding.EncodeToString(m.key.N.Bytes()),
+			"e":   base64.RawURLEncoding.EncodeToString(big.NewInt(int64(m.key.E)).Bytes()),
+		}},
+	})
+}
+
+// authorize plays the provider's login page: it reads the authorization URL
+// our side built and returns the code the provider would redirect back with.
+func (m *mockIssuer) authorize(t *testing.T, authURL string) (code, state string) {
+	t.Helper()
+
+	parsed, err := url.Parse(authURL)
+	if err != nil {
+		t.Fatal(err)
+	}
+	query := parsed.Query()
+	if query.Get("code_challenge_method") != "S256" || query.Get("client_id") != testClientID || query.Get("redirect_uri") != testRedirectURL {
+		t.Fatalf("unexpected authorization request %s", authURL)
+	}
+
+	code = base64.RawURLEncoding.EncodeToString([]byte(time.Now().String()))
+	m.mu.Lock()
+	m.codes[code] = pendingCode{challenge: query.Get("code_challenge"), nonce: query.Get("nonce")}
+	m.mu.Unlock()
+	return code, query.Get("state")
+}
+
+func (m *mockIssuer) token(w http.ResponseWriter, r *http.Request) {
+	clientID, secret, ok := r.BasicAuth()
+	if !ok {
+		clientID, secret = r.PostFormValue("client_id"), r.PostFormValue("client_secret")
+	}
+	if clientID != testClientID || secret != testClientSecret {
+		http.Error(w, `{"error":"invalid_client"}`, http.StatusUnauthorized)
+		return
+	}
+
+	m.mu.Lock()
+	pending, ok := m.codes[r.PostFormValue("code")]
+	delete(m.codes, r.PostFormValue("code"))
+	m.mu.Unlock()
+
+	sum := sha256.Sum256([]byte(r.PostFormValue("code_verifier")))
+	if !ok || base64.RawURLEncoding.EncodeToString(sum[:]) != pending.challenge {
+		w.Header().Set("Content-Type", "application/json")
+		w.WriteHeader(http.StatusBadRequest)
+		w.Write([]byte(`{"error":"invalid_grant"}`))
+		return
+	}
+
+	issuer, audience := m.server.URL, testClientID
+	if m.issuer != "" {
+		issuer = m.issuer
+	}
+	if m.audience != "" {
+		audience = m.audience
+	}
+
+	now := time.Now()
+	idToken := jwt.NewWithClaims(jwt.SigningMethodRS256, jwt.MapClaims{
+		"iss":                issuer,
+		"sub":                "user-123",
+		"aud":                audience,
+		"iat":                now.Unix(),
+		"exp":                now.Add(time.Minute).Unix(),
+		"nonce":              pending.nonce,
+		"email":              "jane@example.com",
+		"email_verified":     m.emailVerified,
+		"name":               "Jane Doe",
+		"preferred_username": "jane",
+	})
+	idToken.Header["kid"] = "mock"
+	signed, err := idToken.SignedString(m.key)
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusInternalServerError)
+		return
+	}
+
+	w.Header().Set("Content-Type", "application/json")
+	json.NewEncoder(w).Encode(map[string]interface{}{
+		"access_token": "mock-access-token",
+		"token_type":   "Bearer",
+		"expires_in":   60,
+		"id_token":     signed,
+	})
+}
+
+func (m *mockIssuer) provider() *Provider {
+	return NewRegistry([]ProviderConfig{{
+		ID:           "mock",
+		Issuer:       m.server.URL,
+		ClientID:     testClientID,
+		ClientSecret: testClientSecret,
+		Scopes:       defaultScopes,
+	}}).providers[0]
+}
+
+// login runs AuthCodeURL, the provider login and Exchange. exchangeNonce is
+// the nonce our side kept for the callback.
+func login(t *testing.T, m *mockIssuer, nonce, exchangeNonce string) (*Identity, error) {
+	t.Helper()
+
+	provider := m.provider()
+	verifier := oauth2.GenerateVerifier()
+
+	authURL, err := provider.AuthCodeURL(context.Background(), testRedirectURL, "state-1", nonce, verifier)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	code, state := m.authorize(t, authURL)
+	if state != "state-1" {
+		t.Fatalf("state = %q, want state-1", state)
+	}
+
+	return provider.Exchange(context.Background(), testRedirectURL, code, verifier, exchangeNonce)
+}
+
+func TestExchangeReturnsIdentity(t *testing.T) {
+	m := newMockIssuer(t)
+
+	identity, err := login(t, m, "nonce-1", "nonce-1")
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	want := Identity{Subject: "user-123", Email: "jane@example.com", EmailVerified: true, Name: "Jane Doe", PreferredUsername: "jane"}
+	if *identity != want {
+		t.Fatalf("identity = %+v, want %+v", *identity, want)
+	}
+}
+
+func TestExchangeRejectsNonceMismatch(t *testing.T) {
+	m := newMockIssuer(t)
+
+	if _, err := login(t, m, "nonce-1", "nonce-2"); !errors.Is(err, ErrNonceMismatch) {
+		t.Fatalf("err = %v, want ErrNonceMismatch", err)
+	}
+}
+
+func TestExchangeRejectsWrongAudience(t *testing.T) {
+	m := newMockIssuer(t)
+	m.audience = "another-client"
+
+	if _, err := login(t, m, "nonce-1", "nonce-1"); err == nil {
+		t.Fatal("id_token for another client was accepted")
+	}
+}
+
+func TestExchangeRejectsWrongIssuer(t *testing.T) {
+	m := newMockIssuer(t)
+	m.issuer = "https://evil.example.com"
+
+	if _, err := login(t, m, "nonce-1", "nonce-1"); err == nil {
+		t.Fatal("id_token from another issuer was accepted")
+	}
+}
+
+func TestExchangeRejectsWrongCodeVerifier(t *testing.T) {
+	m := newMockIssuer(t)
+	provider := m.provider()
+
+	authURL, err := provider.AuthCodeURL(context.Background(), testRedirectURL, "state-1", "nonce-1", oauth2.GenerateVerifier())
+	if err != nil {
+		t.Fatal(err)
+	}
+	code, _ := m.authorize(t, authURL)
+
+	if _, err := provider.Exchange(context.Background(), testRedirectURL, code, oauth2.GenerateVerifier(), "nonce-1"); err == nil {
+		t.Fatal("code was redeemed with another PKCE verifier")
+	}
+}
+
+func TestExchangeAcceptsStringEmailVerified(t *testing.T) {
+	m := newMockIssuer(t)
+	m.emailVerified = "true"
+
+	identity, err := login(t, m, "nonce-1", "nonce-1")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !identity.EmailVerified {
+		t.Fatal(`email_verified "true" was not accepted`)
+	}
+}
+
+func TestParseConfig(t *testing.T) {
+	t.Setenv("TEST_IDP_SECRET", "from-env")
+
+	configs, err := ParseConfig([]byte(`{"providers": [
+		{"id": "corp", "issuer": "https://sso.example.com", "clientId": "abc", "clientSecret": "${TEST_IDP_SECRET}", "scopes": ["email"]},
+		{"id": "google", "name": "Google", "issuer": "https://accounts.google.com", "clientId": "def"}
+	]}`))
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if configs[0].ClientSecret != "from-env" {
+		t.Errorf("client secret = %q, want it expanded from the environment", configs[0].ClientSecret)
+	}
+	if configs[0].Name != "corp" {
+		t.Errorf("name = %q, want the id as fallback", configs[0].Name)
+	}
+	if strings.Join(configs[0].Scopes, " ") != "openid email" {
+		t.Errorf("scopes = %v, want openid added", configs[0].Scopes)
+	}
+	if strings.Join(configs[1].Scopes, " ") != "openid email profile" {
+		t.Errorf("scopes = %v, want the defaults", configs[1].Scopes)
+	}
+}
+
+func TestParseConfigRejectsInvalidProviders(t *testing.T) {
+	for name, data := range map[string]string{
+		"missing issuer": `{"providers": [{"id": "a", "clientId": "abc"}]}`,
+		"invalid id":     `{"providers": [{"id": "Bad ID", "issuer": "https://a", "clientId": "abc"}]}`,
+		"duplicate id":   `{"providers": [{"id": "a", "issuer": "https://a", "clientId": "x"}, {"id": "a", "issuer": "https://b", "clientId": "y"}]}`,
+	} {
+		if _, err := ParseConfig([]byte(data)); err == nil {
+			t.Errorf("%s: no error", name)
+		}
+	}
+}
+
+func TestRegistryGetUnknownProvider(t *testing.T) {
+	if _, err := NewRegistry(nil).Get("missing"); !errors.Is(err, ErrUnknownProvider) {
+		t.Fatalf("err = %v, want ErrUnknownProvider", err)
+	}
+}
@@ -0,0 +1,526 @@
+package federation
+
+import (
+	"bytes"
+	"context"
+	"crypto/rsa"
+	"crypto/tls"
+	"crypto/x509"
+	"encoding/base64"
+	"encoding/json"
+	"encoding/xml"
+	"errors"
+	"fmt"
+	"io"
+	"net/http"
+	"net/url"
+	"os"
+	"regexp"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/beevik/etree"
+	"github.com/crewjam/saml"
+	"github.com/crewjam/saml/xmlenc"
+	xrv "github.com/mattermost/xml-roundtrip-validator"
+	dsig "github.com/russellhaering/goxmldsig"
+)
+
+var (
+	ErrUnknownTenant       = errors.New("unknown SAML tenant")
+	ErrNoRedirectBinding   = errors.New("identity provider has no HTTP-Redirect single sign-on endpoint")
+	ErrMissingAudience     = errors.New("assertion has no audience restriction")
+	ErrMissingNameID       = errors.New("assertion has no NameID")
+	ErrMalformedAssertion  = errors.New("assertion is missing its subject or conditions")
+	ErrSAMLKeyNotSupported = errors.New("SAML service provider key must be RSA")
+)
+
+var tenantIDPattern = regexp.MustCompile(`^[a-z0-9_-]{1,32}$`)
+
+const samlAssertionNamespace = "urn:oasis:names:tc:SAML:2.0:assertion"
+
+var nameIDFormats = map[string]saml.NameIDFormat{
+	"":            saml.PersistentNameIDFormat,
+	"persistent":  saml.PersistentNameIDFormat,
+	"email":       saml.EmailAddressNameIDFormat,
+	"unspecified": saml.UnspecifiedNameIDFormat,
+}
+
+// Attribute names tried when a tenant does not map an attribute: the common
+// short names, then the LDAP OIDs most identity providers send.
+var (
+	defaultEmailAttributes    = []string{"email", "mail", "urn:oid:0.9.2342.19200300.100.1.3"}
+	defaultNicknameAttributes = []string{"uid", "username", "urn:oid:0.9.2342.19200300.100.1.1"}
+	defaultNameAttributes     = []string{"displayName", "name", "urn:oid:2.16.840.1.113730.3.1.241"}
+)
+
+// SAMLTenantConfig is one entry of the SAML tenants file. ID appears in the
+// service provider URLs registered at the customer's identity provider and
+// is stored with every linked identity, so it must not change.
+//
+// SAML has no email_verified claim: an email is only trusted when its domain
+// is one of EmailDomains. JITProvisioning creates accounts for users that
+// log in for the first time; without it they need an existing account.
+type SAMLTenantConfig struct {
+	ID              string               `json:"id"`
+	Name            string               `json:"name"`
+	IDPMetadataURL  string               `json:"idpMetadataUrl"`
+	IDPMetadataFile string               `json:"idpMetadataFile"`
+	NameIDFormat    string               `json:"nameIdFormat"`
+	EmailDomains    []string             `json:"emailDomains"`
+	JITProvisioning bool                 `json:"jitProvisioning"`
+	Attributes      SAMLAttributeMapping `json:"attributes"`
+}
+
+// SAMLAttributeMapping names the assertion attributes that hold the user's
+// email, nickname and display name. Names match either the attribute Name or
+// its FriendlyName.
+type SAMLAttributeMapping struct {
+	Email    string `json:"email"`
+	Nickname string `json:"nickname"`
+	Name     string `json:"name"`
+}
+
+// SAMLTenant is a customer's SAML identity provider.
+type SAMLTenant struct {
+	SAMLTenantConfig
+
+	key         *rsa.PrivateKey
+	certificate *x509.Certificate
+
+	mu          sync.Mutex
+	idpMetadata *saml.EntityDescriptor
+}
+
+// SAMLAssertion is a verified assertion: who logged in, plus the assertion
+// ID and expiry to reject replays with.
+type SAMLAssertion struct {
+	Identity  Identity
+	ID        string
+	ExpiresAt time.Time
+}
+
+var (
+	samlTenantsMu      sync.RWMutex
+	defaultSAMLTenants []*SAMLTenant
+)
+
+// initSAML loads the tenants file named by SAML_TENANTS_FILE, signing with
+// the key pair in SAML_SP_KEY_FILE and SAML_SP_CERT_FILE. Without the file
+// SAML login is off.
+func initSAML() error {
+	path := os.Getenv("SAML_TENANTS_FILE")
+	if path == "" {
+		return nil
+	}
+
+	tenants, err := LoadSAMLFile(path, os.Getenv("SAML_SP_KEY_FILE"), os.Getenv("SAML_SP_CERT_FILE"))
+	if err != nil {
+		return err
+	}
+
+	samlTenantsMu.Lock()
+	defaultSAMLTenants = tenants
+	samlTenantsMu.Unlock()
+	return nil
+}
+
+// GetSAMLTenant returns a tenant loaded by Init.
+func GetSAMLTenant(id string) (*SAMLTenant, error) {
+	samlTenantsMu.RLock()
+	defer samlTenantsMu.RUnlock()
+
+	for _, tenant := range defaultSAMLTenants {
+		if tenant.ID == id {
+			return tenant, nil
+		}
+	}
+	return nil, ErrUnknownTenant
+}
+
+// LoadSAMLFile reads a tenants file and the service provider key pair. As
+// with the providers file, ${VAR} references are expanded from the
+// environment:
+//
+//	{"tenants": [{"id": "acme", "name": "Acme Corp",
+//	  "idpMetadataUrl": "https://idp.acme.com/metadata",
+//	  "emailDomains": ["acme.com"], "jitProvisioning": true,
+//	  "attributes": {"email": "mail", "nickname": "sAMAccountName"}}]}
+func LoadSAMLFile(path, keyFile, certFile string) ([]*SAMLTenant, error) {
+	data, err := os.ReadFile(path)
+	if err != nil {
+		return nil, err
+	}
+
+	configs, err := ParseSAMLConfig(data)
+	if err != nil {
+		return nil, fmt.Errorf("%s: %w", path, err)
+	}
+
+	keyPair, err := tls.LoadX509KeyPair(certFile, keyFile)
+	if err != nil {
+		return nil, fmt.Errorf("SAML service provider key pair: %w", err)
+	}
+	key, ok := keyPair.PrivateKey.(*rsa.PrivateKey)
+	if !ok {
+		return nil, ErrSAMLKeyNotSupported
+	}
+	certificate, err := x509.ParseCertificate(keyPair.Certificate[0])
+	if err != nil {
+		return nil, err
+	}
+
+	tenants := make([]*SAMLTenant, 0, len(configs))
+	for _, config := range configs {
+		tenants = append(tenants, &SAMLTenant{SAMLTenantConfig: config, key: key, certificate: certificate})
+	}
+	return tenants, nil
+}
+
+// ParseSAMLConfig parses and validates the tenants file contents.
+func ParseSAMLConfig(data []byte) ([]SAMLTenantConfig, error) {
+	var file struct {
+		Tenants []SAMLTenantConfig `json:"tenants"`
+	}
+	if err := json.Unmarshal([]byte(os.ExpandEnv(string(data))), &file); err != nil {
+		return nil, err
+	}
+
+	seen := map[string]bool{}
+	for i := range file.Tenants {
+		config := &file.Tenants[i]
+		if !tenantIDPattern.MatchString(config.ID) {
+			return nil, fmt.Errorf("tenant %d: id must be 1 to 32 lowercase letters, digits, - or _", i)
+		}
+		if seen[config.ID] {
+			return nil, fmt.Errorf("tenant %s: duplicate id", config.ID)
+		}
+		seen[config.ID] = true
+
+		if (config.IDPMetadataURL == "") == (config.IDPMetadataFile == "") {
+			return nil, fmt.Errorf("tenant %s: exactly one of idpMetadataUrl and idpMetadataFile is required", config.ID)
+		}
+		if _, ok := nameIDFormats[config.NameIDFormat]; !ok {
+			return nil, fmt.Errorf("tenant %s: nameIdFormat must be persistent, email or unspecified", config.ID)
+		}
+		if config.Name == "" {
+			config.Name = config.ID
+		}
+
+		for j, domain := range config.EmailDomains {
+			config.EmailDomains[j] = strings.ToLower(strings.TrimPrefix(domain, "@"))
+		}
+	}
+
+	return file.Tenants, nil
+}
+
+// Metadata returns the service provider metadata to register at the
+// tenant's identity provider. It does not need the identity provider, so it
+// works before the tenant is set up on their side.
+func (t *SAMLTenant) Metadata(metadataURL, acsURL string) ([]byte, error) {
+	sp, err := t.serviceProvider(metadataURL, acsURL, nil)
+	if err != nil {
+		return nil, err
+	}
+
+	data, err := xml.MarshalIndent(sp.Metadata(), "", "  ")
+	if err != nil {
+		return nil, err
+	}
+	return append([]byte(xml.Header), data...), nil
+}
+
+// AuthnRequestURL returns the identity provider URL with a signed
+// AuthnRequest to send the user to, and the request ID the response has to
+// answer. relayState must be URL safe, it is added to the URL as is.
+func (t *SAMLTenant) AuthnRequestURL(ctx context.Context, metadataURL, acsURL, relayState string) (string, string, error) {
+	idpMetadata, err := t.discover(ctx)
+	if err != nil {
+		return "", "", err
+	}
+	sp, err := t.serviceProvider(metadataURL, acsURL, idpMetadata)
+	if err != nil {
+		return "", "", err
+	}
+
+	location := sp.GetSSOBindingLocation(saml.HTTPRedirectBinding)
+	if location == "" {
+		return "", "", ErrNoRedirectBinding
+	}
+
+	request, err := sp.MakeAuthenticationRequest(location, saml.HTTPRedirectBinding, saml.HTTPPostBinding)
+	if err != nil {
+		return "", "", err
+	}
+	redirectURL, err := request.Redirect(relayState, sp)
+	if err != nil {
+		return "", "", err
+	}
+
+	return redirectURL.String(), request.ID, nil
+}
+
+// ParseResponse verifies the SAMLResponse posted to the ACS: the signature
+// against the identity provider's certificates, issuer, destination and
+// recipient, that it answers requestID, the validity window and the
+// audience. Replays of the returned assertion ID are up to the caller.
+func (t *SAMLTenant) ParseResponse(ctx context.Context, metadataURL, acsURL string, r *http.Request, requestID string) (*SAMLAssertion, error) {
+	idpMetadata, err := t.discover(ctx)
+	if err != nil {
+		return nil, err
+	}
+	sp, err := t.serviceProvider(metadataURL, acsURL, idpMetadata)
+	if err != nil {
+		return nil, err
+	}
+
+	if err := r.ParseForm(); err != nil {
+		return nil, err
+	}
+	responseXML, err := base64.StdEncoding.DecodeString(r.PostForm.Get("SAMLResponse"))
+	if err != nil {
+		return nil, fmt.Errorf("invalid SAML response: %w", err)
+	}
+
+	if err := t.checkAssertionElements(responseXML); err != nil {
+		return nil, err
+	}
+
+	parsed, err := sp.ParseXMLResponse(responseXML, []string{requestID})
+	if err != nil {
+		var invalid *saml.InvalidResponseError
+		if errors.As(err, &invalid) {
+			return nil, fmt.Errorf("invalid SAML response: %w", invalid.PrivateErr)
+		}
+		return nil, err
+	}
+
+	// An assertion without audience restriction passes crewjam/saml, but
+	// could have been issued for any service provider
+	if parsed.Conditions == nil || len(parsed.Conditions.AudienceRestrictions) == 0 {
+		return nil, ErrMissingAudience
+	}
+	if parsed.Subject == nil || parsed.Subject.NameID == nil || parsed.Subject.NameID.Value == "" {
+		return nil, ErrMissingNameID
+	}
+
+	return &SAMLAssertion{
+		Identity:  t.identity(parsed),
+		ID:        parsed.ID,
+		ExpiresAt: parsed.Conditions.NotOnOrAfter.Add(saml.MaxClockSkew),
+	}, nil
+}
+
+// checkAssertionElements makes sure every assertion in the response has a
+// Subject and Conditions. crewjam/saml reads both once an assertion's
+// signature checks out, without checking that they are there, so encrypted
+// assertions are decrypted here the same way to look inside them.
+func (t *SAMLTenant) checkAssertionElements(responseXML []byte) error {
+	if err := xrv.Validate(bytes.NewReader(responseXML)); err != nil {
+		return fmt.Errorf("invalid SAML response: %w", err)
+	}
+	doc := etree.NewDocument()
+	if err := doc.ReadFromBytes(responseXML); err != nil || doc.Root() == nil {
+		return fmt.Errorf("invalid SAML response: %w", ErrMalformedAssertion)
+	}
+
+	for _, element := range doc.Root().ChildElements() {
+		if element.NamespaceURI() != samlAssertionNamespace {
+			continue
+		}
+
+		assertion := element
+		switch element.Tag {
+		case "Assertion":
+		case "EncryptedAssertion":
+			var err error
+			if assertion, err = t.decryptAssertion(element); err != nil {
+				return fmt.Errorf("invalid SAML response: %w", err)
+			}
+		default:
+			continue
+		}
+
+		if !hasAssertionChild(assertion, "Subject") || !hasAssertionChild(assertion, "Conditions") {
+			return ErrMalformedAssertion
+		}
+	}
+	return nil
+}
+
+func (t *SAMLTenant) decryptAssertion(encryptedAssertion *etree.Element) (*etree.Element, error) {
+	encryptedData := encryptedAssertion.FindElement("./EncryptedData")
+	if encryptedData == nil {
+		return nil, ErrMalformedAssertion
+	}
+
+	var key interface{} = t.key
+	if encryptedKey := encryptedAssertion.FindElement("./EncryptedKey"); encryptedKey != nil {
+		var err error
+		if key, err = xmlenc.Decrypt(t.key, encryptedKey); err != nil {
+			return nil, err
+		}
+	}
+
+	plaintext, err := xmlenc.Decrypt(key, encryptedData)
+	if err != nil {
+		return nil, err
+	}
+	if err := xrv.Validate(bytes.NewReader(plaintext)); err != nil {
+		return nil, err
+	}
+
+	doc := etree.NewDocument()
+	if err := doc.ReadFromBytes(plaintext); err != nil || doc.Root() == nil {
+		return nil, ErrMalformedAssertion
+	}
+	return doc.Root(), nil
+}
+
+func hasAssertionChild(element *etree.Element, tag string) bool {
+	for _, child := range element.ChildElements() {
+		if child.NamespaceURI() == samlAssertionNamespace && child.Tag == tag {
+			return true
+		}
+	}
+	return false
+}
+
+func (t *SAMLTenant) identity(assertion *saml.Assertion) Identity {
+	attributes := map[string]string{}
+	for _, statement := range assertion.AttributeStatements {
+		for _, attribute := range statement.Attributes {
+			if len(attribute.Values) == 0 {
+				continue
+			}
+			for _, name := range []string{attribute.Name, attribute.FriendlyName} {
+				if _, ok := attributes[name]; name != "" && !ok {
+					attributes[name] = attribute.Values[0].Value
+				}
+			}
+		}
+	}
+
+	identity := Identity{
+		Subject:           assertion.Subject.NameID.Value,
+		Email:             strings.TrimSpace(attributeValue(attributes, t.Attributes.Email, defaultEmailAttributes)),
+		Name:              attributeValue(attributes, t.Attributes.Name, defaultNameAttributes),
+		PreferredUsername: attributeValue(attributes, t.Attributes.Nickname, defaultNicknameAttributes),
+	}
+	if identity.Email == "" && assertion.Subject.NameID.Format == string(saml.EmailAddressNameIDFormat) {
+		identity.Email = identity.Subject
+	}
+
+	_, domain, _ := strings.Cut(identity.Email, "@")
+	identity.EmailVerified = domain != "" && contains(t.EmailDomains, strings.ToLower(domain))
+	return identity
+}
+
+func attributeValue(attributes map[string]string, mapped string, defaults []string) string {
+	if mapped != "" {
+		return attributes[mapped]
+	}
+	for _, name := range defaults {
+		if value := attributes[name]; value != "" {
+			return value
+		}
+	}
+	return ""
+}
+
+func (t *SAMLTenant) serviceProvider(metadataURL, acsURL string, idpMetadata *saml.EntityDescriptor) (*saml.ServiceProvider, error) {
+	parsedMetadataURL, err := url.Parse(metadataURL)
+	if err != nil {
+		return nil, err
+	}
+	parsedACSURL, err := url.Parse(acsURL)
+	if err != nil {
+		return nil, err
+	}
+
+	return &saml.ServiceProvider{
+		EntityID:          metadataURL,
+		Key:               t.key,
+		Certificate:       t.certificate,
+		MetadataURL:       *parsedMetadataURL,
+		AcsURL:            *parsedACSURL,
+		IDPMetadata:       idpMetadata,
+		AuthnNameIDFormat: nameIDFormats[t.NameIDFormat],
+		SignatureMethod:   dsig.RSASHA256SignatureMethod,
+		HTTPClient:        &http.Client{Timeout: httpTimeout},
+	}, nil
+}
+
+// discover loads the identity provider metadata once. Like OIDC discovery,
+// failures are not cached; picking up a rotated IdP certificate takes a
+// restart.
+func (t *SAMLTenant) discover(ctx context.Context) (*saml.EntityDescriptor, error) {
+	t.mu.Lock()
+	defer t.mu.Unlock()
+
+	if t.idpMetadata != nil {
+		return t.idpMetadata, nil
+	}
+
+	data, err := t.readIDPMetadata(ctx)
+	if err != nil {
+		return nil, fmt.Errorf("metadata for %s: %w", t.ID, err)
+	}
+	idpMetadata, err := parseIDPMetadata(data)
+	if err != nil {
+		return nil, fmt.Errorf("metadata for %s: %w", t.ID, err)
+	}
+
+	t.idpMetadata = idpMetadata
+	return idpMetadata, nil
+}
+
+func (t *SAMLTenant) readIDPMetadata(ctx context.Context) ([]byte, error) {
+	if t.IDPMetadataFile != "" {
+		return os.ReadFile(t.IDPMetadataFile)
+	}
+
+	request, err := http.NewRequestWithContext(ctx, http.MethodGet, t.IDPMetadataURL, nil)
+	if err != nil {
+		return nil, err
+	}
+	response, err := (&http.Client{Timeout: httpTimeout}).Do(request)
+	if err != nil {
+		return nil, err
+	}
+	defer response.Body.Close()
+
+	if response.StatusCode != http.StatusOK {
+		return nil, fmt.Errorf("unexpected status %s", response.Status)
+	}
+	return io.ReadAll(io.LimitReader(response.Body, 1<<20))
+}
+
+// parseIDPMetadata accepts an EntityDescriptor, or an EntitiesDescriptor
+// holding one for an identity provider, as federations publish them.
+func parseIDPMetadata(data []byte) (*saml.EntityDescriptor, error) {
+	if err := xrv.Validate(bytes.NewReader(data)); err != nil {
+		return nil, err
+	}
+
+	var entities saml.EntitiesDescriptor
+	if err := xml.Unmarshal(data, &entities); err == nil {
+		for i, entity := range entities.EntityDescriptors {
+			if len(entity.IDPSSODescriptors) > 0 {
+				return &entities.EntityDescriptors[i], nil
+			}
+		}
+		return nil, errors.New("no identity provider in EntitiesDescriptor")
+	}
+
+	var entity saml.EntityDescriptor
+	if err := xml.Unmarshal(data, &entity); err != nil {
+		return nil, err
+	}
+	if len(entity.IDPSSODescriptors) == 0 {
+		return nil, errors.New("metadata does not describe an identity provider")
+	}
+	return &entity, nil
+}
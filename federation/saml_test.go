@@ -0,0 +1,308 @@
+package federation
+
+import (
+	"context"
+	"crypto/rand"
+	"crypto/rsa"
+	"crypto/x509"
+	"crypto/x509/pkix"
+	"encoding/xml"
+	"errors"
+	"math/big"
+	"net/http"
+	"net/http/httptest"
+	"net/url"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/crewjam/saml"
+	dsig "github.com/russellhaering/goxmldsig"
+)
+
+const (
+	testSPMetadataURL = "http://localhost/api/auth/saml/acme/metadata"
+	testSPACSURL      = "http://localhost/api/auth/saml/acme/acs"
+)
+
+// mockIDP is a local SAML identity provider built on crewjam/saml. Its
+// metadata is written to a file the tenant loads, and login answers an
+// AuthnRequest of the tenant with a signed, encrypted assertion.
+type mockIDP struct {
+	idp    *saml.IdentityProvider
+	tenant *SAMLTenant
+}
+
+func newMockIDP(t *testing.T, config SAMLTenantConfig) *mockIDP {
+	t.Helper()
+
+	idpKey, idpCertificate := newTestKeyPair(t, "idp.example.com")
+	spKey, spCertificate := newTestKeyPair(t, "localhost")
+
+	m := &mockIDP{
+		idp: &saml.IdentityProvider{
+			Key:             idpKey,
+			Certificate:     idpCertificate,
+			MetadataURL:     url.URL{Scheme: "https", Host: "idp.example.com", Path: "/metadata"},
+			SSOURL:          url.URL{Scheme: "https", Host: "idp.example.com", Path: "/sso"},
+			SignatureMethod: dsig.RSASHA256SignatureMethod,
+		},
+	}
+	m.idp.ServiceProviderProvider = m
+
+	metadata, err := xml.Marshal(m.idp.Metadata())
+	if err != nil {
+		t.Fatal(err)
+	}
+	config.ID = "acme"
+	config.IDPMetadataFile = filepath.Join(t.TempDir(), "idp.xml")
+	if err := os.WriteFile(config.IDPMetadataFile, metadata, 0o600); err != nil {
+		t.Fatal(err)
+	}
+
+	m.tenant = &SAMLTenant{SAMLTenantConfig: config, key: spKey, certificate: spCertificate}
+	return m
+}
+
+func newTestKeyPair(t *testing.T, commonName string) (*rsa.PrivateKey, *x509.Certificate) {
+	t.Helper()
+
+	key, err := rsa.GenerateKey(rand.Reader, 2048)
+	if err != nil {
+		t.Fatal(err)
+	}
+	template := &x509.Certificate{
+		SerialNumber: big.NewInt(1),
+		Subject:      pkix.Name{CommonName: commonName},
+		NotBefore:    time.Now().Add(-time.Hour),
+		NotAfter:     time.Now().Add(time.Hour),
+	}
+	der, err := x509.CreateCertificate(rand.Reader, template, template, &key.PublicKey, key)
+	if err != nil {
+		t.Fatal(err)
+	}
+	certificate, err := x509.ParseCertificate(der)
+	if err != nil {
+		t.Fatal(err)
+	}
+	return key, certificate
+}
+
+// GetServiceProvider implements saml.ServiceProviderProvider with the
+// tenant's own service provider metadata.
+func (m *mockIDP) GetServiceProvider(r *http.Request, serviceProviderID string) (*saml.EntityDescriptor, error) {
+	if serviceProviderID != testSPMetadataURL {
+		return nil, os.ErrNotExist
+	}
+	data, err := m.tenant.Metadata(testSPMetadataURL, testSPACSURL)
+	if err != nil {
+		return nil, err
+	}
+
+	var metadata saml.EntityDescriptor
+	if err := xml.Unmarshal(data, &metadata); err != nil {
+		return nil, err
+	}
+	return &metadata, nil
+}
+
+// login sends an AuthnRequest of the tenant to the identity provider and
+// returns its SAMLResponse along with the request ID. tamper, when set,
+// changes the assertion before it is signed.
+func (m *mockIDP) login(t *testing.T, session *saml.Session, tamper func(*saml.IdpAuthnRequest)) (string, string) {
+	t.Helper()
+
+	redirectURL, requestID, err := m.tenant.AuthnRequestURL(context.Background(), testSPMetadataURL, testSPACSURL, "relay-state")
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	request, err := saml.NewIdpAuthnRequest(m.idp, httptest.NewRequest(http.MethodGet, redirectURL, nil))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if err := request.Validate(); err != nil {
+		t.Fatal(err)
+	}
+	if err := (saml.DefaultAssertionMaker{}).MakeAssertion(request, session); err != nil {
+		t.Fatal(err)
+	}
+	if tamper != nil {
+		tamper(request)
+	}
+
+	form, err := request.PostBinding()
+	if err != nil {
+		t.Fatal(err)
+	}
+	return form.SAMLResponse, requestID
+}
+
+func (m *mockIDP) parse(samlResponse, requestID string) (*SAMLAssertion, error) {
+	form := url.Values{"SAMLResponse": {samlResponse}, "RelayState": {"relay-state"}}
+	r := httptest.NewRequest(http.MethodPost, testSPACSURL, strings.NewReader(form.Encode()))
+	r.Header.Set("Content-Type", "application/x-www-form-urlencoded")
+
+	return m.tenant.ParseResponse(context.Background(), testSPMetadataURL, testSPACSURL, r, requestID)
+}
+
+func testSAMLSession(email string) *saml.Session {
+	return &saml.Session{
+		NameID:   "u-1234",
+		UserName: "hal",
+		CustomAttributes: []saml.Attribute{
+			{Name: "mail", Values: []saml.AttributeValue{{Value: email}}},
+			{Name: "displayName", Values: []saml.AttributeValue{{Value: "Hal Jordan"}}},
+		},
+	}
+}
+
+func TestSAMLParseResponseReturnsIdentity(t *testing.T) {
+	m := newMockIDP(t, SAMLTenantConfig{EmailDomains: []string{"acme.com"}})
+
+	samlResponse, requestID := m.login(t, testSAMLSession("Hal@Acme.com"), nil)
+	assertion, err := m.parse(samlResponse, requestID)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	want := Identity{Subject: "u-1234", Email: "Hal@Acme.com", EmailVerified: true, Name: "Hal Jordan", PreferredUsername: "hal"}
+	if assertion.Identity != want {
+		t.Fatalf("identity = %+v, want %+v", assertion.Identity, want)
+	}
+	if assertion.ID == "" || !assertion.ExpiresAt.After(time.Now()) {
+		t.Fatalf("assertion ID %q expiring at %s cannot be recorded against replays", assertion.ID, assertion.ExpiresAt)
+	}
+}
+
+func TestSAMLEmailOutsideDomainsIsNotVerified(t *testing.T) {
+	m := newMockIDP(t, SAMLTenantConfig{EmailDomains: []string{"acme.com"}})
+
+	for _, email := range []string{"hal@gmail.com", "hal@acme.com.evil.test", "hal@sub.acme.com"} {
+		samlResponse, requestID := m.login(t, testSAMLSession(email), nil)
+		assertion, err := m.parse(samlResponse, requestID)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if assertion.Identity.EmailVerified {
+			t.Errorf("email %s outside the tenant's domains is verified", email)
+		}
+	}
+}
+
+func TestSAMLAttributeMapping(t *testing.T) {
+	m := newMockIDP(t, SAMLTenantConfig{
+		EmailDomains: []string{"acme.com"},
+		Attributes:   SAMLAttributeMapping{Email: "eduPersonPrincipalName", Nickname: "urn:oid:2.5.4.4", Name: "cn"},
+	})
+
+	session := testSAMLSession("unmapped@acme.com")
+	session.UserEmail = "hal.jordan@acme.com"
+	session.UserSurname = "jordan"
+	session.UserCommonName = "Harold Jordan"
+
+	samlResponse, requestID := m.login(t, session, nil)
+	assertion, err := m.parse(samlResponse, requestID)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	want := Identity{Subject: "u-1234", Email: "hal.jordan@acme.com", EmailVerified: true, Name: "Harold Jordan", PreferredUsername: "jordan"}
+	if assertion.Identity != want {
+		t.Fatalf("identity = %+v, want %+v", assertion.Identity, want)
+	}
+}
+
+func TestSAMLEmailNameIDWithoutEmailAttribute(t *testing.T) {
+	m := newMockIDP(t, SAMLTenantConfig{NameIDFormat: "email", EmailDomains: []string{"acme.com"}})
+
+	session := &saml.Session{NameID: "hal@acme.com", NameIDFormat: string(saml.EmailAddressNameIDFormat)}
+	samlResponse, requestID := m.login(t, session, nil)
+	assertion, err := m.parse(samlResponse, requestID)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if assertion.Identity.Email != "hal@acme.com" || !assertion.Identity.EmailVerified {
+		t.Fatalf("identity = %+v, want the email NameID as verified email", assertion.Identity)
+	}
+}
+
+func TestSAMLRejectsWrongRequestID(t *testing.T) {
+	m := newMockIDP(t, SAMLTenantConfig{})
+
+	samlResponse, _ := m.login(t, testSAMLSession("hal@acme.com"), nil)
+	if _, err := m.parse(samlResponse, "id-of-another-request"); err == nil {
+		t.Fatal("response to another AuthnRequest was accepted")
+	}
+}
+
+func TestSAMLRejectsUntrustedSignature(t *testing.T) {
+	m := newMockIDP(t, SAMLTenantConfig{})
+	otherKey, otherCertificate := newTestKeyPair(t, "idp.example.com")
+
+	samlResponse, requestID := m.login(t, testSAMLSession("hal@acme.com"), func(request *saml.IdpAuthnRequest) {
+		impostor := *request.IDP
+		impostor.Key, impostor.Certificate = otherKey, otherCertificate
+		request.IDP = &impostor
+	})
+	if _, err := m.parse(samlResponse, requestID); err == nil {
+		t.Fatal("assertion signed with a key missing from the IdP metadata was accepted")
+	}
+}
+
+func TestSAMLRejectsOtherAudience(t *testing.T) {
+	m := newMockIDP(t, SAMLTenantConfig{})
+
+	samlResponse, requestID := m.login(t, testSAMLSession("hal@acme.com"), func(request *saml.IdpAuthnRequest) {
+		request.Assertion.Conditions.AudienceRestrictions[0].Audience.Value = "https://other-sp.example.com/metadata"
+	})
+	if _, err := m.parse(samlResponse, requestID); err == nil {
+		t.Fatal("assertion for another service provider was accepted")
+	}
+}
+
+func TestSAMLRejectsMissingAudience(t *testing.T) {
+	m := newMockIDP(t, SAMLTenantConfig{})
+
+	samlResponse, requestID := m.login(t, testSAMLSession("hal@acme.com"), func(request *saml.IdpAuthnRequest) {
+		request.Assertion.Conditions.AudienceRestrictions = nil
+	})
+	if _, err := m.parse(samlResponse, requestID); !errors.Is(err, ErrMissingAudience) {
+		t.Fatalf("err = %v, want ErrMissingAudience", err)
+	}
+}
+
+func TestSAMLRejectsAssertionWithoutSubjectOrConditions(t *testing.T) {
+	m := newMockIDP(t, SAMLTenantConfig{})
+
+	for name, tamper := range map[string]func(*saml.IdpAuthnRequest){
+		"no subject":    func(request *saml.IdpAuthnRequest) { request.Assertion.Subject = nil },
+		"no conditions": func(request *saml.IdpAuthnRequest) { request.Assertion.Conditions = nil },
+	} {
+		samlResponse, requestID := m.login(t, testSAMLSession("hal@acme.com"), tamper)
+		if _, err := m.parse(samlResponse, requestID); !errors.Is(err, ErrMalformedAssertion) {
+			t.Errorf("%s: err = %v, want ErrMalformedAssertion", name, err)
+		}
+	}
+}
+
+// Replays are rejected by the caller recording the assertion ID, so a
+// response posted twice must yield the same ID both times.
+func TestSAMLReplayedResponseHasSameAssertionID(t *testing.T) {
+	m := newMockIDP(t, SAMLTenantConfig{})
+
+	samlResponse, requestID := m.login(t, testSAMLSession("hal@acme.com"), nil)
+	first, err := m.parse(samlResponse, requestID)
+	if err != nil {
+		t.Fatal(err)
+	}
+	replayed, err := m.parse(samlResponse, requestID)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if first.ID != replayed.ID {
+		t.Fatalf("assertion IDs differ: %q and %q", first.ID, replayed.ID)
+	}
+}
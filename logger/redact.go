@@ -0,0 +1,59 @@
+package logger
+
+import (
+	"log/slog"
+	"strings"
+)
+
+// RedactedValue replaces every value that is hidden from the logs.
+const RedactedValue = "[REDACTED]"
+
+// sensitiveKeys are matched against attribute keys after lowercasing them and
+// removing "_" and "-", so "refresh_token" and "refreshToken" both match
+// "token".
+var sensitiveKeys = []string{
+	"password",
+	"passwd",
+	"secret",
+	"token",
+	"otp",
+	"authorization",
+	"cookie",
+	"nonce",
+	"recoverycode",
+	"privatekey",
+	"apikey",
+}
+
+// Redact is a slog ReplaceAttr func. It hides attributes with a sensitive key
+// and string values that look like a bearer token, whatever their key.
+func Redact(groups []string, a slog.Attr) slog.Attr {
+	if IsSensitiveKey(a.Key) {
+		return slog.String(a.Key, RedactedValue)
+	}
+
+	if a.Value.Kind() == slog.KindString && looksLikeToken(a.Value.String()) {
+		return slog.String(a.Key, RedactedValue)
+	}
+
+	return a
+}
+
+func IsSensitiveKey(key string) bool {
+	normalized := strings.NewReplacer("_", "", "-", "").Replace(strings.ToLower(key))
+	for _, sensitive := range sensitiveKeys {
+		if strings.Contains(normalized, sensitive) {
+			return true
+		}
+	}
+	return false
+}
+
+// looksLikeToken catches JWTs and personal access tokens passed under an
+// innocent key or pasted into a message attribute.
+func looksLikeToken(value string) bool {
+	if strings.HasPrefix(value, "pat_") {
+		return true
+	}
+	return strings.HasPrefix(value, "eyJ") && strings.Count(value, ".") == 2
+}
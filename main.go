@@ -1,27 +1,66 @@
 package main
 
 import (
-	"log"
+	"log/slog"
 	"os"
+	"os/signal"
+	"syscall"
+	"time"
 
 	"github.com/gin-gonic/gin"
 	"github.com/joho/godotenv"
 	"github.com/pramek008/go-jwt-project/database"
+	"github.com/pramek008/go-jwt-project/federation"
+	"github.com/pramek008/go-jwt-project/logger"
 	"github.com/pramek008/go-jwt-project/middleware"
 	"github.com/pramek008/go-jwt-project/routes"
+	"github.com/pramek008/go-jwt-project/utils"
 )
 
 func main() {
 	// Load environment variables
 	if err := godotenv.Load(); err != nil {
-		log.Fatal("Error loading .env file")
+		logger.Fatal("Error loading .env file", "error", err)
+	}
+
+	// Structured logging, LOG_LEVEL and LOG_FORMAT come from the environment
+	logger.Init()
+
+	// Load JWT signing keys, reloaded on SIGHUP so keys can be rotated
+	// without a restart
+	if err := utils.LoadKeyRing(); err != nil {
+		logger.Fatal("Failed to load JWT signing keys", "error", err)
+	}
+	go reloadKeyRingOnSignal()
+
+	if err := utils.LoadOTPPepper(); err != nil {
+		logger.Fatal("Failed to load OTP pepper", "error", err)
+	}
+
+	if err := utils.LoadPublicURL(); err != nil {
+		logger.Fatal("Failed to load public URL", "error", err)
+	}
+
+	if err := utils.LoadOIDCIssuer(); err != nil {
+		logger.Fatal("Failed to load OpenID Connect issuer", "error", err)
+	}
+
+	// External identity providers for federated login, from
+	// OIDC_PROVIDERS_FILE and SAML_TENANTS_FILE
+	if err := federation.Init(); err != nil {
+		logger.Fatal("Failed to load identity providers", "error", err)
 	}
 
 	// Connect to database
 	database.ConnectDb()
 
-	// Set up Gin router
-	r := gin.Default()
+	// Accounts past their deletion grace period are purged in the background
+	go purgeDeletedAccountsPeriodically()
+
+	// Set up Gin router. gin.Default is not used since its logger prints
+	// query strings and its recovery dumps request headers.
+	r := gin.New()
+	r.Use(middleware.RequestLogger(), middleware.Recovery())
 
 	r.Use(middleware.SetBaseURL())
 
@@ -37,3 +76,32 @@ func main() {
 	}
 	r.Run("0.0.0.0:" + port)
 }
+
+func reloadKeyRingOnSignal() {
+	signals := make(chan os.Signal, 1)
+	signal.Notify(signals, syscall.SIGHUP)
+
+	for range signals {
+		if err := utils.LoadKeyRing(); err != nil {
+			slog.Error("Failed to reload JWT signing keys, keeping the previous ones", "error", err)
+			continue
+		}
+		slog.Info("JWT signing keys reloaded")
+	}
+}
+
+func purgeDeletedAccountsPeriodically() {
+	ticker := time.NewTicker(utils.GetEnvDuration("ACCOUNT_PURGE_INTERVAL", time.Hour))
+	defer ticker.Stop()
+
+	for ; true; <-ticker.C {
+		purged, err := utils.PurgeDeletedAccounts()
+		if err != nil {
+			slog.Error("Failed to purge deleted accounts", "error", err)
+			continue
+		}
+		if purged > 0 {
+			slog.Info("Purged deleted accounts", "count", purged)
+		}
+	}
+}
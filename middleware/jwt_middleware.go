@@ -7,43 +7,219 @@ import (
 
 	"github.com/gin-gonic/gin"
 	"github.com/pramek008/go-jwt-project/database"
+	"github.com/pramek008/go-jwt-project/logger"
 	"github.com/pramek008/go-jwt-project/models"
 	"github.com/pramek008/go-jwt-project/utils"
 )
 
+// JWTMiddleware only accepts access tokens of an interactive session. Use it
+// for account management routes that API keys and tokens issued to OAuth
+// clients must not reach.
 func JWTMiddleware() gin.HandlerFunc {
 	return func(c *gin.Context) {
-		authHeader := c.GetHeader("Authorization")
-		if authHeader == "" {
-			utils.SendErrorResponse(c, http.StatusUnauthorized, "Authorization header is required")
-			c.Abort()
+		tokenString, ok := bearerToken(c)
+		if !ok {
 			return
 		}
 
-		tokenString := strings.TrimPrefix(authHeader, "Bearer ")
-		if tokenString == authHeader {
-			utils.SendErrorResponse(c, http.StatusUnauthorized, "Authorization header format must be Bearer {token}")
-			c.Abort()
+		if !authenticateSession(c, tokenString, false) {
 			return
 		}
 
-		userID, err := utils.ExtractUserIDFromToken(tokenString)
-		if err != nil {
-			utils.SendErrorResponse(c, http.StatusUnauthorized, "Invalid or expired token")
+		c.Next()
+	}
+}
+
+// TokenAuthMiddleware accepts session access tokens, personal access tokens
+// ("Bearer pat_...") and access tokens issued to OAuth clients. Pair it with
+// RequireScope.
+func TokenAuthMiddleware() gin.HandlerFunc {
+	return func(c *gin.Context) {
+		tokenString, ok := bearerToken(c)
+		if !ok {
+			return
+		}
+
+		if strings.HasPrefix(tokenString, utils.PersonalAccessTokenPrefix) {
+			ok = authenticatePersonalAccessToken(c, tokenString)
+		} else {
+			ok = authenticateSession(c, tokenString, true)
+		}
+		if !ok {
+			return
+		}
+
+		c.Next()
+	}
+}
+
+// RequireScope rejects requests whose token was not granted the scope.
+func RequireScope(scope string) gin.HandlerFunc {
+	return func(c *gin.Context) {
+		scopes, _ := c.Get("scopes")
+		granted, _ := scopes.([]string)
+
+		if !utils.HasScope(granted, scope) {
+			utils.SendErrorResponse(c, http.StatusForbidden, "Token is missing the "+scope+" scope")
 			c.Abort()
 			return
 		}
 
-		// Periksa apakah token ada di database
-		var storedToken models.Token
-		db := database.DB.Db
-		if err := db.Where("token = ? AND user_id = ?", tokenString, userID).First(&storedToken).Error; err != nil {
-			utils.SendErrorResponse(c, http.StatusUnauthorized, "Unauthorized, Invalid Token not found")
+		c.Next()
+	}
+}
+
+func bearerToken(c *gin.Context) (string, bool) {
+	authHeader := c.GetHeader("Authorization")
+	if authHeader == "" {
+		utils.SendErrorResponse(c, http.StatusUnauthorized, "Authorization header is required")
+		c.Abort()
+		return "", false
+	}
+
+	tokenString := strings.TrimPrefix(authHeader, "Bearer ")
+	if tokenString == authHeader {
+		utils.SendErrorResponse(c, http.StatusUnauthorized, "Authorization header format must be Bearer {token}")
+		c.Abort()
+		return "", false
+	}
+
+	return tokenString, true
+}
+
+// authenticateSession checks a signed access token. Delegated tokens, the
+// ones issued to OAuth clients, are only accepted when allowDelegated is set.
+func authenticateSession(c *gin.Context, tokenString string, allowDelegated bool) bool {
+	claims, err := utils.ExtractClaimsFromToken(tokenString)
+	if err != nil {
+		utils.SendErrorResponse(c, http.StatusUnauthorized, "Invalid or expired token")
+		c.Abort()
+		return false
+	}
+
+	if utils.IsClientToken(claims) {
+		if !allowDelegated {
+			return rejectDelegatedToken(c)
+		}
+		return authenticateClientToken(c, claims)
+	}
+	userID := claims.UserID
+
+	// Periksa apakah token ada di database
+	var storedToken models.Token
+	db := database.DB.Db
+	if err := db.Where("jti = ? AND user_id = ?", claims.ID, userID).First(&storedToken).Error; err != nil {
+		utils.SendErrorResponse(c, http.StatusUnauthorized, "Unauthorized, Invalid Token not found")
+		c.Abort()
+		return false
+	}
+
+	if storedToken.ClientID != "" && !allowDelegated {
+		return rejectDelegatedToken(c)
+	}
+
+	// Token lama tanpa sesi tidak lagi diterima
+	if storedToken.SessionID == nil {
+		utils.SendErrorResponse(c, http.StatusUnauthorized, "Unauthorized, session not found")
+		c.Abort()
+		return false
+	}
+
+	session, err := utils.FindActiveSession(userID, *storedToken.SessionID)
+	if err != nil {
+		utils.SendErrorResponse(c, http.StatusUnauthorized, "Unauthorized, session has been revoked")
+		c.Abort()
+		return false
+	}
+	utils.TouchSession(session, c.ClientIP())
+
+	if !utils.IsUserActive(userID) {
+		utils.SendErrorResponse(c, http.StatusForbidden, "Account is suspended")
+		c.Abort()
+		return false
+	}
+
+	c.Set("user_id", userID)
+	c.Set("session_id", session.ID)
+	logger.With(c, "user_id", userID.String())
+
+	// Clients only get the scopes the user consented to and none of the
+	// user's roles
+	if storedToken.ClientID != "" {
+		c.Set("oauth_client_id", storedToken.ClientID)
+		c.Set("roles", []string{})
+		c.Set("scopes", utils.ParseScopes(storedToken.Scope))
+		return true
+	}
+
+	c.Set("roles", claims.Roles)
+	c.Set("scopes", []string{utils.ScopeAll})
+	return true
+}
+
+func rejectDelegatedToken(c *gin.Context) bool {
+	utils.SendErrorResponse(c, http.StatusForbidden, "Tokens issued to OAuth clients cannot access this route")
+	c.Abort()
+	return false
+}
+
+// authenticateClientToken accepts a client_credentials token. There is no
+// user behind it, so user_id is not set.
+func authenticateClientToken(c *gin.Context, claims *utils.Claims) bool {
+	clientToken, err := utils.FindActiveClientToken(claims)
+	if err != nil {
+		utils.SendErrorResponse(c, http.StatusUnauthorized, "Invalid, expired or revoked client token")
+		c.Abort()
+		return false
+	}
+
+	c.Set("oauth_client_id", clientToken.ClientID)
+	logger.With(c, "client_id", clientToken.ClientID)
+	c.Set("roles", []string{})
+	c.Set("scopes", utils.ParseScopes(clientToken.Scope))
+	return true
+}
+
+func authenticatePersonalAccessToken(c *gin.Context, tokenString string) bool {
+	token, err := utils.AuthenticatePersonalAccessToken(tokenString)
+	if err != nil {
+		utils.SendErrorResponse(c, http.StatusUnauthorized, "Invalid, expired or revoked personal access token")
+		c.Abort()
+		return false
+	}
+
+	if !utils.IsUserActive(token.UserID) {
+		utils.SendErrorResponse(c, http.StatusForbidden, "Account is suspended")
+		c.Abort()
+		return false
+	}
+
+	// Personal access tokens carry no claims, so roles come from the database
+	roles, err := utils.UserRoleNames(token.UserID)
+	if err != nil {
+		utils.SendErrorResponse(c, http.StatusInternalServerError, "Failed to load user roles")
+		c.Abort()
+		return false
+	}
+
+	c.Set("user_id", token.UserID)
+	c.Set("personal_access_token_id", token.ID)
+	logger.With(c, "user_id", token.UserID.String())
+	c.Set("roles", roles)
+	c.Set("scopes", utils.ParseScopes(token.Scopes))
+	return true
+}
+
+// RequirePermission rejects requests whose user has no role granting the
+// permission. It must run after JWTMiddleware or TokenAuthMiddleware.
+func RequirePermission(permission string) gin.HandlerFunc {
+	return func(c *gin.Context) {
+		if !utils.ContextHasPermission(c, permission) {
+			utils.SendErrorResponse(c, http.StatusForbidden, "Missing permission "+permission)
 			c.Abort()
 			return
 		}
 
-		c.Set("user_id", userID)
 		c.Next()
 	}
 }
@@ -0,0 +1,25 @@
+package models
+
+import (
+	"time"
+
+	"github.com/google/uuid"
+)
+
+// ActionToken backs a single-use link sent by email, such as a magic login
+// link. The link itself is a signed token whose jti is the ID of this row.
+// NonceHash, when set, binds the link to the browser that requested it.
+type ActionToken struct {
+	ID        uuid.UUID `gorm:"type:uuid;primary_key"`
+	UserID    uuid.UUID `gorm:"type:uuid;not null;index"`
+	Purpose   string    `gorm:"size:32;not null;index"`
+	NonceHash string    `gorm:"size:64"`
+	ExpiresAt time.Time `gorm:"not null"`
+	UsedAt    *time.Time
+	CreatedAt time.Time `gorm:"default:CURRENT_TIMESTAMP"`
+	User      User      `gorm:"foreignKey:UserID"`
+}
+
+func (ActionToken) TableName() string {
+	return "action_tokens"
+}
@@ -0,0 +1,39 @@
+package models
+
+import (
+	"time"
+
+	"github.com/google/uuid"
+)
+
+// EmailChangeRequest is a pending change of a user's email address. The
+// address on the user only changes once the OTP sent to NewEmail is
+// confirmed. A user has at most one pending request.
+type EmailChangeRequest struct {
+	ID        uuid.UUID `gorm:"type:uuid;default:uuid_generate_v4();primary_key"`
+	UserID    uuid.UUID `gorm:"type:uuid;not null;uniqueIndex"`
+	OldEmail  string    `gorm:"size:100;not null"`
+	NewEmail  string    `gorm:"size:100;not null;index"`
+	ExpiresAt time.Time `gorm:"not null"`
+	CreatedAt time.Time `gorm:"default:CURRENT_TIMESTAMP"`
+	User      User      `gorm:"foreignKey:UserID"`
+}
+
+func (EmailChangeRequest) TableName() string {
+	return "email_change_requests"
+}
+
+// EmailChangeRevert is the address an email change cancel link restores.
+// It is keyed by the link's action token, so a later change request cannot
+// overwrite it and the link keeps working after the change is confirmed.
+type EmailChangeRevert struct {
+	ActionTokenID uuid.UUID `gorm:"type:uuid;primary_key"`
+	UserID        uuid.UUID `gorm:"type:uuid;not null;index"`
+	Email         string    `gorm:"size:100;not null"`
+	ExpiresAt     time.Time `gorm:"not null"`
+	CreatedAt     time.Time `gorm:"default:CURRENT_TIMESTAMP"`
+}
+
+func (EmailChangeRevert) TableName() string {
+	return "email_change_reverts"
+}
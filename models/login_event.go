@@ -0,0 +1,40 @@
+package models
+
+import (
+	"time"
+
+	"github.com/google/uuid"
+)
+
+// Login event types
+const (
+	LoginEventSuccess      = "login_success"
+	LoginEventFailure      = "login_failure"
+	LoginEventMFAChallenge = "mfa_challenge"
+	LoginEventTokenRefresh = "token_refresh"
+)
+
+// LoginEvent is one entry of a user's login history. Fingerprint identifies
+// the device by its parsed browser, OS and device type together with the /24
+// or /48 network of its IP address, and is used to spot logins from devices
+// the user has not used before.
+type LoginEvent struct {
+	ID          uuid.UUID  `gorm:"type:uuid;default:uuid_generate_v4();primary_key" json:"id"`
+	UserID      uuid.UUID  `gorm:"type:uuid;not null;index:idx_login_events_user_created" json:"-"`
+	SessionID   *uuid.UUID `gorm:"type:uuid" json:"sessionId,omitempty"`
+	Event       string     `gorm:"size:32;not null" json:"event"`
+	Method      string     `gorm:"size:32" json:"method,omitempty"`
+	Reason      string     `gorm:"size:64" json:"reason,omitempty"`
+	IPAddress   string     `gorm:"size:64" json:"ipAddress"`
+	UserAgent   string     `gorm:"size:512" json:"userAgent"`
+	Browser     string     `gorm:"size:64" json:"browser"`
+	OS          string     `gorm:"size:64" json:"os"`
+	Device      string     `gorm:"size:32" json:"device"`
+	Fingerprint string     `gorm:"size:64;index" json:"-"`
+	CreatedAt   time.Time  `gorm:"default:CURRENT_TIMESTAMP;index:idx_login_events_user_created" json:"createdAt"`
+	User        User       `gorm:"foreignKey:UserID" json:"-"`
+}
+
+func (LoginEvent) TableName() string {
+	return "login_events"
+}
@@ -0,0 +1,18 @@
+package models
+
+import "time"
+
+// LoginThrottle counts recent failed logins for one key, either an account
+// ("user:<id>") or a client address ("ip:<addr>"). BlockedUntil is set once
+// the failures exceed the free attempts, growing with every further failure.
+type LoginThrottle struct {
+	Key           string    `gorm:"size:128;primary_key"`
+	Failures      int       `gorm:"not null;default:0"`
+	LastFailureAt time.Time `gorm:"not null"`
+	BlockedUntil  *time.Time
+	UpdatedAt     time.Time `gorm:"default:CURRENT_TIMESTAMP"`
+}
+
+func (LoginThrottle) TableName() string {
+	return "login_throttles"
+}
@@ -0,0 +1,55 @@
+package models
+
+import (
+	"time"
+
+	"github.com/google/uuid"
+)
+
+// TOTPFactor is a user's RFC 6238 authenticator. It only protects logins once
+// ConfirmedAt is set, i.e. after the user proved the app is set up correctly.
+type TOTPFactor struct {
+	ID           uuid.UUID `gorm:"type:uuid;default:uuid_generate_v4();primary_key"`
+	UserID       uuid.UUID `gorm:"type:uuid;not null;uniqueIndex"`
+	Secret       string    `gorm:"size:64;not null"`
+	LastUsedStep int64     `gorm:"not null;default:0"`
+	ConfirmedAt  *time.Time
+	CreatedAt    time.Time `gorm:"default:CURRENT_TIMESTAMP"`
+	User         User      `gorm:"foreignKey:UserID"`
+}
+
+// RecoveryCode is a one-time code that replaces a TOTP code when the
+// authenticator is lost. Only the SHA-256 hash is stored.
+type RecoveryCode struct {
+	ID        uuid.UUID `gorm:"type:uuid;default:uuid_generate_v4();primary_key"`
+	UserID    uuid.UUID `gorm:"type:uuid;not null;index"`
+	CodeHash  string    `gorm:"size:64;not null;uniqueIndex"`
+	UsedAt    *time.Time
+	CreatedAt time.Time `gorm:"default:CURRENT_TIMESTAMP"`
+	User      User      `gorm:"foreignKey:UserID"`
+}
+
+// MFAChallenge backs the short-lived "mfa_pending" token handed out after a
+// correct password, and limits how many codes can be tried against it.
+type MFAChallenge struct {
+	ID         uuid.UUID `gorm:"type:uuid;primary_key"`
+	UserID     uuid.UUID `gorm:"type:uuid;not null;index"`
+	DeviceName string    `gorm:"size:255"`
+	Attempts   int       `gorm:"not null;default:0"`
+	ExpiresAt  time.Time `gorm:"not null"`
+	UsedAt     *time.Time
+	CreatedAt  time.Time `gorm:"default:CURRENT_TIMESTAMP"`
+	User       User      `gorm:"foreignKey:UserID"`
+}
+
+func (TOTPFactor) TableName() string {
+	return "totp_factors"
+}
+
+func (RecoveryCode) TableName() string {
+	return "recovery_codes"
+}
+
+func (MFAChallenge) TableName() string {
+	return "mfa_challenges"
+}
@@ -0,0 +1,135 @@
+package models
+
+import (
+	"strings"
+	"time"
+
+	"github.com/google/uuid"
+)
+
+// OAuth client types. Public clients, such as mobile and single-page apps,
+// cannot keep a secret and authenticate with PKCE alone.
+const (
+	OAuthClientConfidential = "confidential"
+	OAuthClientPublic       = "public"
+)
+
+// OAuth grant types
+const (
+	GrantTypeAuthorizationCode = "authorization_code"
+	GrantTypeRefreshToken      = "refresh_token"
+	GrantTypeClientCredentials = "client_credentials"
+)
+
+// OAuthClient is a registered client of the OAuth endpoints: one of our
+// backend services calling token introspection, or a partner application
+// acting on behalf of users. Only services with Introspection set may
+// introspect tokens. Only the SHA-256 hash of the client secret is stored.
+// RedirectURIs, PostLogoutRedirectURIs, Scopes and GrantTypes are space
+// separated.
+type OAuthClient struct {
+	ID                     uuid.UUID  `gorm:"type:uuid;default:uuid_generate_v4();primary_key" json:"id"`
+	ClientID               string     `gorm:"size:64;not null;uniqueIndex" json:"clientId"`
+	SecretHash             string     `gorm:"size:64" json:"-"`
+	Name                   string     `gorm:"size:255;not null" json:"name"`
+	ClientType             string     `gorm:"size:16;not null;default:confidential" json:"clientType"`
+	RedirectURIs           string     `gorm:"type:text" json:"redirectUris"`
+	PostLogoutRedirectURIs string     `gorm:"type:text" json:"postLogoutRedirectUris"`
+	Scopes                 string     `gorm:"size:512" json:"scopes"`
+	GrantTypes             string     `gorm:"size:255" json:"grantTypes"`
+	Introspection          bool       `gorm:"not null;default:false" json:"introspection"`
+	CreatedAt              time.Time  `gorm:"default:CURRENT_TIMESTAMP" json:"createdAt"`
+	RevokedAt              *time.Time `json:"revokedAt,omitempty"`
+}
+
+func (OAuthClient) TableName() string {
+	return "oauth_clients"
+}
+
+func (c OAuthClient) IsPublic() bool {
+	return c.ClientType == OAuthClientPublic
+}
+
+// HasRedirectURI compares exactly, as OAuth 2.1 requires.
+func (c OAuthClient) HasRedirectURI(uri string) bool {
+	return containsField(c.RedirectURIs, uri)
+}
+
+func (c OAuthClient) HasPostLogoutRedirectURI(uri string) bool {
+	return containsField(c.PostLogoutRedirectURIs, uri)
+}
+
+func (c OAuthClient) HasGrantType(grantType string) bool {
+	return containsField(c.GrantTypes, grantType)
+}
+
+func (c OAuthClient) HasScope(scope string) bool {
+	return containsField(c.Scopes, scope)
+}
+
+func containsField(list, value string) bool {
+	for _, field := range strings.Fields(list) {
+		if field == value {
+			return true
+		}
+	}
+	return false
+}
+
+// OAuthGrant remembers the scopes a user consented to for a client, so the
+// consent screen is skipped next time the client asks for no more than that.
+type OAuthGrant struct {
+	ID        uuid.UUID `gorm:"type:uuid;default:uuid_generate_v4();primary_key" json:"id"`
+	UserID    uuid.UUID `gorm:"type:uuid;not null;uniqueIndex:idx_oauth_grants_user_client" json:"-"`
+	ClientID  string    `gorm:"size:64;not null;uniqueIndex:idx_oauth_grants_user_client" json:"clientId"`
+	Scope     string    `gorm:"size:512;not null" json:"scope"`
+	CreatedAt time.Time `gorm:"default:CURRENT_TIMESTAMP" json:"createdAt"`
+	UpdatedAt time.Time `gorm:"default:CURRENT_TIMESTAMP" json:"updatedAt"`
+	User      User      `gorm:"foreignKey:UserID" json:"-"`
+}
+
+func (OAuthGrant) TableName() string {
+	return "oauth_grants"
+}
+
+// OAuthAuthorizationCode is a single-use code from the authorization
+// endpoint. SessionID is set once it has been exchanged, so a replayed code
+// can revoke the tokens issued for it. Nonce, AuthTime and LoginSessionID
+// (the session the user approved from) end up in the OpenID Connect id_token.
+type OAuthAuthorizationCode struct {
+	ID             uuid.UUID `gorm:"type:uuid;default:uuid_generate_v4();primary_key"`
+	CodeHash       string    `gorm:"size:64;not null;uniqueIndex"`
+	ClientID       string    `gorm:"size:64;not null"`
+	UserID         uuid.UUID `gorm:"type:uuid;not null;index"`
+	RedirectURI    string    `gorm:"type:text;not null"`
+	Scope          string    `gorm:"size:512;not null"`
+	CodeChallenge  string    `gorm:"size:128;not null"`
+	Nonce          string    `gorm:"size:255"`
+	AuthTime       time.Time
+	LoginSessionID *uuid.UUID `gorm:"type:uuid"`
+	SessionID      *uuid.UUID `gorm:"type:uuid"`
+	ExpiresAt      time.Time  `gorm:"not null"`
+	UsedAt         *time.Time
+	CreatedAt      time.Time `gorm:"default:CURRENT_TIMESTAMP"`
+	User           User      `gorm:"foreignKey:UserID"`
+}
+
+func (OAuthAuthorizationCode) TableName() string {
+	return "oauth_authorization_codes"
+}
+
+// OAuthClientToken tracks access tokens issued with the client_credentials
+// grant. They belong to a client rather than a user, so they are kept apart
+// from Token.
+type OAuthClientToken struct {
+	ID        uuid.UUID `gorm:"type:uuid;primary_key"`
+	ClientID  string    `gorm:"size:64;not null;index"`
+	Scope     string    `gorm:"size:512"`
+	ExpiresAt time.Time `gorm:"not null"`
+	RevokedAt *time.Time
+	CreatedAt time.Time `gorm:"default:CURRENT_TIMESTAMP"`
+}
+
+func (OAuthClientToken) TableName() string {
+	return "oauth_client_tokens"
+}
@@ -6,10 +6,20 @@ import (
 	"github.com/google/uuid"
 )
 
+// OTP purposes. A code is only accepted by the flow it was issued for.
+const (
+	OTPPurposeRegistration  = "registration"
+	OTPPurposePasswordReset = "password_reset"
+	OTPPurposeEmailChange   = "email_change"
+	OTPPurposeStepUp        = "step_up"
+)
+
 type OTP struct {
 	ID        uuid.UUID `gorm:"type:uuid;default:uuid_generate_v4();primary_key" json:"id"`
-	Email     string    `gorm:"type:varchar(255);index;not null"`
-	Code      string    `gorm:"type:varchar(6);not null"`
+	Email     string    `gorm:"type:varchar(255);index:idx_otps_email_purpose;not null"`
+	Purpose   string    `gorm:"type:varchar(32);index:idx_otps_email_purpose;not null"`
+	CodeHash  string    `gorm:"type:varchar(64);not null"`
+	Attempts  int       `gorm:"not null;default:0"`
 	ExpiresAt time.Time `gorm:"not null"`
 	CreatedAt time.Time `gorm:"default:CURRENT_TIMESTAMP"`
 }
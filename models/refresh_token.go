@@ -0,0 +1,28 @@
+package models
+
+import (
+	"time"
+
+	"github.com/google/uuid"
+)
+
+// RefreshToken is an opaque, long-lived credential used to obtain new access
+// tokens. Only the SHA-256 hash of the token is stored. Every refresh token
+// belongs to a family that starts when a session is created; rotating a token revokes it and
+// issues its replacement in the same family.
+type RefreshToken struct {
+	ID         uuid.UUID  `gorm:"type:uuid;default:uuid_generate_v4();primary_key"`
+	TokenHash  string     `gorm:"size:64;not null;uniqueIndex"`
+	FamilyID   uuid.UUID  `gorm:"type:uuid;not null;index"`
+	UserID     uuid.UUID  `gorm:"type:uuid;not null;index"`
+	SessionID  *uuid.UUID `gorm:"type:uuid;index"`
+	ReplacedBy *uuid.UUID `gorm:"type:uuid"`
+	ExpiresAt  time.Time  `gorm:"not null"`
+	RevokedAt  *time.Time
+	CreatedAt  time.Time `gorm:"default:CURRENT_TIMESTAMP"`
+	User       User      `gorm:"foreignKey:UserID"`
+}
+
+func (RefreshToken) TableName() string {
+	return "refresh_tokens"
+}
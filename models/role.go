@@ -0,0 +1,59 @@
+package models
+
+import (
+	"time"
+
+	"github.com/google/uuid"
+)
+
+// Built-in roles. Every user gets RoleUser; admins manage the others through
+// the admin API.
+const (
+	RoleAdmin     = "admin"
+	RoleModerator = "moderator"
+	RoleUser      = "user"
+)
+
+// Permissions checked by the API. Owners can always edit and delete their own
+// posts; the "any" permissions extend that to everybody's posts.
+const (
+	PermissionPostsUpdateAny = "posts:update:any"
+	PermissionPostsDeleteAny = "posts:delete:any"
+	PermissionRolesManage    = "roles:manage"
+	PermissionUsersRead      = "users:read"
+	PermissionUsersManage    = "users:manage"
+	PermissionOAuthClients   = "oauth_clients:manage"
+)
+
+// DefaultPermissions is seeded on startup, with the built-in roles that hold
+// each of them.
+var DefaultPermissions = map[string][]string{
+	PermissionPostsUpdateAny: {RoleAdmin, RoleModerator},
+	PermissionPostsDeleteAny: {RoleAdmin, RoleModerator},
+	PermissionRolesManage:    {RoleAdmin},
+	PermissionUsersRead:      {RoleAdmin, RoleModerator},
+	PermissionUsersManage:    {RoleAdmin},
+	PermissionOAuthClients:   {RoleAdmin},
+}
+
+type Role struct {
+	ID          uuid.UUID    `gorm:"type:uuid;default:uuid_generate_v4();primary_key" json:"id"`
+	Name        string       `gorm:"size:64;not null;unique" json:"name"`
+	Description string       `gorm:"size:255" json:"description"`
+	Permissions []Permission `gorm:"many2many:role_permissions" json:"permissions"`
+	CreatedAt   time.Time    `gorm:"default:CURRENT_TIMESTAMP" json:"createdAt"`
+	UpdatedAt   time.Time    `gorm:"default:CURRENT_TIMESTAMP" json:"updatedAt"`
+}
+
+type Permission struct {
+	ID   uuid.UUID `gorm:"type:uuid;default:uuid_generate_v4();primary_key" json:"id"`
+	Name string    `gorm:"size:64;not null;unique" json:"name"`
+}
+
+func (Role) TableName() string {
+	return "roles"
+}
+
+func (Permission) TableName() string {
+	return "permissions"
+}
@@ -0,0 +1,38 @@
+package models
+
+import (
+	"time"
+
+	"github.com/google/uuid"
+)
+
+// SAMLRequest is an AuthnRequest sent to a tenant's identity provider that
+// has not been answered yet. The relay state is only stored hashed and lives
+// in a cookie of the browser that started the login.
+type SAMLRequest struct {
+	ID             uuid.UUID `gorm:"type:uuid;default:uuid_generate_v4();primary_key"`
+	RelayStateHash string    `gorm:"size:64;not null;uniqueIndex"`
+	Tenant         string    `gorm:"size:32;not null"`
+	RequestID      string    `gorm:"size:64;not null"`
+	DeviceName     string    `gorm:"size:255"`
+	ExpiresAt      time.Time `gorm:"not null"`
+	CreatedAt      time.Time `gorm:"default:CURRENT_TIMESTAMP"`
+}
+
+func (SAMLRequest) TableName() string {
+	return "saml_requests"
+}
+
+// SAMLAssertion records a consumed assertion until it expires, so it cannot
+// be posted to the ACS again.
+type SAMLAssertion struct {
+	ID          uuid.UUID `gorm:"type:uuid;default:uuid_generate_v4();primary_key"`
+	Tenant      string    `gorm:"size:32;not null;uniqueIndex:idx_saml_assertions_tenant_assertion"`
+	AssertionID string    `gorm:"size:255;not null;uniqueIndex:idx_saml_assertions_tenant_assertion"`
+	ExpiresAt   time.Time `gorm:"not null;index"`
+	CreatedAt   time.Time `gorm:"default:CURRENT_TIMESTAMP"`
+}
+
+func (SAMLAssertion) TableName() string {
+	return "saml_assertions"
+}
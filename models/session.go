@@ -0,0 +1,30 @@
+package models
+
+import (
+	"time"
+
+	"github.com/google/uuid"
+)
+
+// Session is a single login on a single device. Access and refresh tokens
+// issued for that login point back to it, so revoking the session logs the
+// device out without touching the user's other devices. Sessions created for
+// a third-party application through OAuth carry its ClientID and the Scope
+// the user consented to.
+type Session struct {
+	ID         uuid.UUID  `gorm:"type:uuid;default:uuid_generate_v4();primary_key" json:"id"`
+	UserID     uuid.UUID  `gorm:"type:uuid;not null;index" json:"-"`
+	DeviceName string     `gorm:"size:255" json:"deviceName"`
+	UserAgent  string     `gorm:"size:512" json:"userAgent"`
+	IPAddress  string     `gorm:"size:45" json:"ipAddress"`
+	LastSeenAt time.Time  `gorm:"default:CURRENT_TIMESTAMP" json:"lastSeenAt"`
+	CreatedAt  time.Time  `gorm:"default:CURRENT_TIMESTAMP" json:"createdAt"`
+	RevokedAt  *time.Time `json:"revokedAt,omitempty"`
+	ClientID   string     `gorm:"size:64;index" json:"clientId,omitempty"`
+	Scope      string     `gorm:"size:512" json:"scope,omitempty"`
+	User       User       `gorm:"foreignKey:UserID" json:"-"`
+}
+
+func (Session) TableName() string {
+	return "sessions"
+}
@@ -9,12 +9,15 @@ import (
 
 type Token struct {
 	gorm.Model
-	ID        uuid.UUID `gorm:"type:uuid;default:uuid_generate_v4();primary_key"`
-	Token     string    `gorm:"size:255;not null;unique"`
-	UserID    uuid.UUID `gorm:"type:uuid;not null"`
-	CreatedAt time.Time `gorm:"default:CURRENT_TIMESTAMP"`
-	ExpiredAt time.Time `gorm:"default:CURRENT_TIMESTAMP"`
-	User      User      `gorm:"foreignKey:UserID"`
+	ID        uuid.UUID  `gorm:"type:uuid;default:uuid_generate_v4();primary_key"`
+	JTI       uuid.UUID  `gorm:"type:uuid;not null;uniqueIndex"`
+	UserID    uuid.UUID  `gorm:"type:uuid;not null"`
+	SessionID *uuid.UUID `gorm:"type:uuid;index"`
+	ClientID  string     `gorm:"size:64"`
+	Scope     string     `gorm:"size:512"`
+	CreatedAt time.Time  `gorm:"default:CURRENT_TIMESTAMP"`
+	ExpiredAt time.Time  `gorm:"default:CURRENT_TIMESTAMP"`
+	User      User       `gorm:"foreignKey:UserID"`
 }
 
 func (Token) TableName() string {
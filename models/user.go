@@ -1,6 +1,7 @@
 package models
 
 import (
+	"log/slog"
 	"time"
 
 	"github.com/google/uuid"
@@ -12,9 +13,29 @@ type User struct {
 	Nickname  string         `gorm:"size:255;not null;unique" json:"nickname"`
 	Email     string         `gorm:"size:100;not null;unique" json:"email"`
 	Password  string         `gorm:"type:varchar(255);not null" json:"-"`
+	Roles     []Role         `gorm:"many2many:user_roles" json:"roles,omitempty"`
 	CreatedAt time.Time      `gorm:"default:CURRENT_TIMESTAMP" json:"createdAt"`
 	UpdatedAt time.Time      `gorm:"default:CURRENT_TIMESTAMP" json:"updatedAt"`
 	DeletedAt gorm.DeletedAt `gorm:"index" json:"deletedAt"`
+
+	// Suspension set by an admin. SuspendedUntil is empty for an indefinite
+	// suspension.
+	SuspendedAt      *time.Time `json:"suspendedAt,omitempty"`
+	SuspendedUntil   *time.Time `json:"suspendedUntil,omitempty"`
+	SuspensionReason string     `gorm:"size:255" json:"suspensionReason,omitempty"`
+
+	// Set when the user deletes their account. Logging in before this time
+	// restores the account, after it the account is purged.
+	DeletionScheduledAt *time.Time `gorm:"index" json:"deletionScheduledAt,omitempty"`
+}
+
+// LogValue keeps the password hash and contact details out of the logs when a
+// whole user is logged.
+func (u User) LogValue() slog.Value {
+	return slog.GroupValue(
+		slog.String("id", u.ID.String()),
+		slog.String("nickname", u.Nickname),
+	)
 }
 
 type TempUser struct {
@@ -34,6 +55,11 @@ type UserResponse struct {
 	UpdatedAt time.Time `json:"updatedAt"`
 }
 
+// IsSuspended reports whether the user is suspended at the given time.
+func (u User) IsSuspended(now time.Time) bool {
+	return u.SuspendedAt != nil && (u.SuspendedUntil == nil || now.Before(*u.SuspendedUntil))
+}
+
 func (User) TableName() string {
 	return "users"
 }
@@ -0,0 +1,65 @@
+package models
+
+import (
+	"time"
+
+	"github.com/google/uuid"
+)
+
+// UserIdentity links a user to their account at an external OpenID Connect
+// provider. Subject is the provider's stable user ID; Email is only what the
+// provider last reported and is not used to find the user.
+type UserIdentity struct {
+	ID          uuid.UUID  `gorm:"type:uuid;default:uuid_generate_v4();primary_key" json:"id"`
+	UserID      uuid.UUID  `gorm:"type:uuid;not null;index" json:"-"`
+	Provider    string     `gorm:"size:64;not null;uniqueIndex:idx_user_identities_provider_subject" json:"provider"`
+	Subject     string     `gorm:"size:255;not null;uniqueIndex:idx_user_identities_provider_subject" json:"-"`
+	Email       string     `gorm:"size:100" json:"email"`
+	CreatedAt   time.Time  `gorm:"default:CURRENT_TIMESTAMP" json:"createdAt"`
+	LastLoginAt *time.Time `json:"lastLoginAt"`
+	User        User       `gorm:"foreignKey:UserID" json:"-"`
+}
+
+func (UserIdentity) TableName() string {
+	return "user_identities"
+}
+
+// OIDCLoginState keeps what a federated login needs between the redirect to
+// the provider and the callback. The state itself is only stored hashed and
+// lives in a cookie of the browser that started the login.
+type OIDCLoginState struct {
+	ID           uuid.UUID `gorm:"type:uuid;default:uuid_generate_v4();primary_key"`
+	StateHash    string    `gorm:"size:64;not null;uniqueIndex"`
+	Provider     string    `gorm:"size:64;not null"`
+	Nonce        string    `gorm:"size:64;not null"`
+	CodeVerifier string    `gorm:"size:128;not null"`
+	RedirectURL  string    `gorm:"type:text;not null"`
+	DeviceName   string    `gorm:"size:255"`
+	ExpiresAt    time.Time `gorm:"not null"`
+	CreatedAt    time.Time `gorm:"default:CURRENT_TIMESTAMP"`
+}
+
+func (OIDCLoginState) TableName() string {
+	return "oidc_login_states"
+}
+
+// IdentityLinkRequest is a federated identity waiting to be linked to the
+// existing account with the same email. It is only linked once the user
+// proves they own the account, with the link token whose jti is
+// LinkTokenID. A user has at most one pending request.
+type IdentityLinkRequest struct {
+	ID          uuid.UUID `gorm:"type:uuid;default:uuid_generate_v4();primary_key"`
+	UserID      uuid.UUID `gorm:"type:uuid;not null;uniqueIndex"`
+	LinkTokenID uuid.UUID `gorm:"type:uuid"`
+	Provider    string    `gorm:"size:64;not null"`
+	Subject     string    `gorm:"size:255;not null"`
+	Email       string    `gorm:"size:100;not null"`
+	DeviceName  string    `gorm:"size:255"`
+	ExpiresAt   time.Time `gorm:"not null"`
+	CreatedAt   time.Time `gorm:"default:CURRENT_TIMESTAMP"`
+	User        User      `gorm:"foreignKey:UserID"`
+}
+
+func (IdentityLinkRequest) TableName() string {
+	return "identity_link_requests"
+}
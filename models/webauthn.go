@@ -0,0 +1,46 @@
+package models
+
+import (
+	"time"
+
+	"github.com/google/uuid"
+)
+
+// WebAuthnCredential is a passkey or security key registered by a user.
+type WebAuthnCredential struct {
+	ID              uuid.UUID  `gorm:"type:uuid;default:uuid_generate_v4();primary_key" json:"id"`
+	UserID          uuid.UUID  `gorm:"type:uuid;not null;index" json:"-"`
+	Name            string     `gorm:"size:255" json:"name"`
+	CredentialID    []byte     `gorm:"type:bytea;not null;uniqueIndex" json:"-"`
+	PublicKey       []byte     `gorm:"type:bytea;not null" json:"-"`
+	AttestationType string     `gorm:"size:32" json:"attestationType"`
+	Transports      string     `gorm:"size:255" json:"transports"`
+	AAGUID          []byte     `gorm:"type:bytea" json:"-"`
+	SignCount       int64      `gorm:"not null;default:0" json:"-"`
+	CloneWarning    bool       `gorm:"not null;default:false" json:"cloneWarning"`
+	BackupEligible  bool       `gorm:"not null;default:false" json:"backupEligible"`
+	BackupState     bool       `gorm:"not null;default:false" json:"backupState"`
+	LastUsedAt      *time.Time `json:"lastUsedAt"`
+	CreatedAt       time.Time  `gorm:"default:CURRENT_TIMESTAMP" json:"createdAt"`
+	User            User       `gorm:"foreignKey:UserID" json:"-"`
+}
+
+// WebAuthnSession holds the challenge of a registration or login ceremony
+// between its begin and finish requests. UserID is empty for passkey logins
+// where the user is only known once the authenticator answers.
+type WebAuthnSession struct {
+	ID        uuid.UUID  `gorm:"type:uuid;primary_key"`
+	UserID    *uuid.UUID `gorm:"type:uuid;index"`
+	Ceremony  string     `gorm:"size:16;not null"`
+	Data      string     `gorm:"type:text;not null"`
+	ExpiresAt time.Time  `gorm:"not null"`
+	CreatedAt time.Time  `gorm:"default:CURRENT_TIMESTAMP"`
+}
+
+func (WebAuthnCredential) TableName() string {
+	return "webauthn_credentials"
+}
+
+func (WebAuthnSession) TableName() string {
+	return "webauthn_sessions"
+}
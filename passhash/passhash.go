@@ -0,0 +1,212 @@
+// Package passhash hashes passwords with argon2id and encodes them in the PHC
+// string format:
+//
+//	$argon2id$v=19$m=65536,t=3,p=2$<salt>$<hash>
+//
+// Hashes created with bcrypt before the switch are still verified, and
+// NeedsRehash reports them so they can be upgraded on the next login.
+package passhash
+
+import (
+	"crypto/rand"
+	"crypto/subtle"
+	"encoding/base64"
+	"errors"
+	"fmt"
+	"os"
+	"strconv"
+	"strings"
+
+	"golang.org/x/crypto/argon2"
+	"golang.org/x/crypto/bcrypt"
+)
+
+var (
+	ErrMismatch      = errors.New("password does not match")
+	ErrInvalidFormat = errors.New("unsupported password hash format")
+	ErrInvalidParams = errors.New("argon2id parameters out of range")
+)
+
+// Bounds for parameters from the environment and from stored hashes.
+// argon2.IDKey panics on zero iterations or parallelism, and a stored hash
+// asking for terabytes of memory would take the process down on login.
+const (
+	maxMemory     = 4 * 1024 * 1024 // 4 GiB
+	maxIterations = 100
+	minSaltLength = 8
+	maxSaltLength = 64
+	minKeyLength  = 16
+	maxKeyLength  = 64
+)
+
+// Params are the argon2id cost parameters. Memory is in KiB.
+type Params struct {
+	Memory      uint32
+	Iterations  uint32
+	Parallelism uint8
+	SaltLength  uint32
+	KeyLength   uint32
+}
+
+// DefaultParams follows the OWASP recommendation for argon2id.
+var DefaultParams = Params{
+	Memory:      64 * 1024,
+	Iterations:  3,
+	Parallelism: 2,
+	SaltLength:  16,
+	KeyLength:   32,
+}
+
+// CurrentParams returns DefaultParams overridden by ARGON2_MEMORY,
+// ARGON2_ITERATIONS, ARGON2_PARALLELISM, ARGON2_SALT_LENGTH and
+// ARGON2_KEY_LENGTH. Values out of range fall back to the default, and so
+// does the whole set if memory is too small for the parallelism.
+func CurrentParams() Params {
+	params := DefaultParams
+	params.Memory = envUint32("ARGON2_MEMORY", params.Memory, 8, maxMemory)
+	params.Iterations = envUint32("ARGON2_ITERATIONS", params.Iterations, 1, maxIterations)
+	params.Parallelism = uint8(envUint32("ARGON2_PARALLELISM", uint32(params.Parallelism), 1, 255))
+	params.SaltLength = envUint32("ARGON2_SALT_LENGTH", params.SaltLength, minSaltLength, maxSaltLength)
+	params.KeyLength = envUint32("ARGON2_KEY_LENGTH", params.KeyLength, minKeyLength, maxKeyLength)
+
+	if params.Validate() != nil {
+		return DefaultParams
+	}
+	return params
+}
+
+// Validate checks that the parameters are safe to hash with.
+func (p Params) Validate() error {
+	if p.Parallelism < 1 ||
+		p.Iterations < 1 || p.Iterations > maxIterations ||
+		p.Memory < 8*uint32(p.Parallelism) || p.Memory > maxMemory ||
+		p.SaltLength < minSaltLength || p.SaltLength > maxSaltLength ||
+		p.KeyLength < minKeyLength || p.KeyLength > maxKeyLength {
+		return ErrInvalidParams
+	}
+	return nil
+}
+
+// Hash hashes the password with the current parameters.
+func Hash(password string) (string, error) {
+	return HashWithParams(password, CurrentParams())
+}
+
+func HashWithParams(password string, params Params) (string, error) {
+	if err := params.Validate(); err != nil {
+		return "", err
+	}
+
+	salt := make([]byte, params.SaltLength)
+	if _, err := rand.Read(salt); err != nil {
+		return "", err
+	}
+
+	key := argon2.IDKey([]byte(password), salt, params.Iterations, params.Memory, params.Parallelism, params.KeyLength)
+
+	return fmt.Sprintf("$argon2id$v=%d$m=%d,t=%d,p=%d$%s$%s",
+		argon2.Version, params.Memory, params.Iterations, params.Parallelism,
+		base64.RawStdEncoding.EncodeToString(salt),
+		base64.RawStdEncoding.EncodeToString(key),
+	), nil
+}
+
+// Verify checks the password against an argon2id or bcrypt hash. It returns
+// ErrMismatch for a wrong password and ErrInvalidFormat for a hash it cannot
+// read.
+func Verify(encodedHash, password string) error {
+	if isBcrypt(encodedHash) {
+		if err := bcrypt.CompareHashAndPassword([]byte(encodedHash), []byte(password)); err != nil {
+			if errors.Is(err, bcrypt.ErrMismatchedHashAndPassword) {
+				return ErrMismatch
+			}
+			return ErrInvalidFormat
+		}
+		return nil
+	}
+
+	params, salt, key, err := decodeArgon2id(encodedHash)
+	if err != nil {
+		return err
+	}
+
+	candidate := argon2.IDKey([]byte(password), salt, params.Iterations, params.Memory, params.Parallelism, uint32(len(key)))
+	if subtle.ConstantTimeCompare(key, candidate) != 1 {
+		return ErrMismatch
+	}
+	return nil
+}
+
+// NeedsRehash reports whether the hash was made with bcrypt or with argon2id
+// parameters that differ from the current ones.
+func NeedsRehash(encodedHash string) bool {
+	if isBcrypt(encodedHash) {
+		return true
+	}
+
+	params, salt, key, err := decodeArgon2id(encodedHash)
+	if err != nil {
+		return true
+	}
+
+	current := CurrentParams()
+	return params.Memory != current.Memory ||
+		params.Iterations != current.Iterations ||
+		params.Parallelism != current.Parallelism ||
+		uint32(len(salt)) != current.SaltLength ||
+		uint32(len(key)) != current.KeyLength
+}
+
+func isBcrypt(encodedHash string) bool {
+	return strings.HasPrefix(encodedHash, "$2a$") ||
+		strings.HasPrefix(encodedHash, "$2b$") ||
+		strings.HasPrefix(encodedHash, "$2y$")
+}
+
+func decodeArgon2id(encodedHash string) (Params, []byte, []byte, error) {
+	var params Params
+
+	// "", "argon2id", "v=19", "m=...,t=...,p=...", salt, hash
+	parts := strings.Split(encodedHash, "$")
+	if len(parts) != 6 || parts[0] != "" || parts[1] != "argon2id" {
+		return params, nil, nil, ErrInvalidFormat
+	}
+
+	var version int
+	if _, err := fmt.Sscanf(parts[2], "v=%d", &version); err != nil || version != argon2.Version {
+		return params, nil, nil, ErrInvalidFormat
+	}
+
+	// Parallelism is scanned wider than its uint8 so p=256 is rejected
+	// instead of wrapping to 0
+	var parallelism uint32
+	if _, err := fmt.Sscanf(parts[3], "m=%d,t=%d,p=%d", &params.Memory, &params.Iterations, &parallelism); err != nil || parallelism > 255 {
+		return params, nil, nil, ErrInvalidFormat
+	}
+	params.Parallelism = uint8(parallelism)
+
+	salt, err := base64.RawStdEncoding.DecodeString(parts[4])
+	if err != nil || len(salt) == 0 {
+		return params, nil, nil, ErrInvalidFormat
+	}
+
+	key, err := base64.RawStdEncoding.DecodeString(parts[5])
+	if err != nil || len(key) == 0 {
+		return params, nil, nil, ErrInvalidFormat
+	}
+
+	params.SaltLength = uint32(len(salt))
+	params.KeyLength = uint32(len(key))
+	if params.Validate() != nil {
+		return params, nil, nil, ErrInvalidFormat
+	}
+	return params, salt, key, nil
+}
+
+func envUint32(key string, fallback, min, max uint32) uint32 {
+	value, err := strconv.ParseUint(os.Getenv(key), 10, 32)
+	if err != nil || value < uint64(min) || value > uint64(max) {
+		return fallback
+	}
+	return uint32(value)
+}
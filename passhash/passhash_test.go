@@ -0,0 +1,130 @@
+package passhash
+
+import (
+	"errors"
+	"fmt"
+	"strings"
+	"testing"
+
+	"golang.org/x/crypto/bcrypt"
+)
+
+// testParams keep the tests fast; they are valid but far below DefaultParams.
+var testParams = Params{Memory: 64, Iterations: 1, Parallelism: 1, SaltLength: 16, KeyLength: 32}
+
+func TestHashRoundTrip(t *testing.T) {
+	hash, err := HashWithParams("correct horse", testParams)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if !strings.HasPrefix(hash, "$argon2id$v=19$m=64,t=1,p=1$") {
+		t.Fatalf("hash = %q, want PHC argon2id with the given params", hash)
+	}
+	if err := Verify(hash, "correct horse"); err != nil {
+		t.Fatalf("Verify with the right password: %v", err)
+	}
+	if err := Verify(hash, "wrong horse"); !errors.Is(err, ErrMismatch) {
+		t.Fatalf("Verify with a wrong password = %v, want ErrMismatch", err)
+	}
+}
+
+func TestHashUsesRandomSalt(t *testing.T) {
+	first, _ := HashWithParams("password", testParams)
+	second, _ := HashWithParams("password", testParams)
+	if first == second {
+		t.Fatal("two hashes of the same password are equal")
+	}
+}
+
+func TestVerifyBcrypt(t *testing.T) {
+	hash, err := bcrypt.GenerateFromPassword([]byte("legacy password"), bcrypt.MinCost)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if err := Verify(string(hash), "legacy password"); err != nil {
+		t.Fatalf("Verify bcrypt with the right password: %v", err)
+	}
+	if err := Verify(string(hash), "other"); !errors.Is(err, ErrMismatch) {
+		t.Fatalf("Verify bcrypt with a wrong password = %v, want ErrMismatch", err)
+	}
+	if !NeedsRehash(string(hash)) {
+		t.Fatal("bcrypt hash does not need a rehash")
+	}
+}
+
+func TestNeedsRehash(t *testing.T) {
+	current, err := Hash("password")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if NeedsRehash(current) {
+		t.Error("hash with the current params needs a rehash")
+	}
+
+	weaker, _ := HashWithParams("password", testParams)
+	if !NeedsRehash(weaker) {
+		t.Error("hash with other params does not need a rehash")
+	}
+
+	if !NeedsRehash("not a hash") {
+		t.Error("unreadable hash does not need a rehash")
+	}
+}
+
+func TestVerifyRejectsInvalidHashes(t *testing.T) {
+	salt, key := "c2FsdHNhbHRzYWx0c2FsdA", "a2V5a2V5a2V5a2V5a2V5a2V5a2V5a2V5a2V5a2U"
+	for name, params := range map[string]string{
+		"zero memory":         "m=0,t=1,p=1",
+		"zero iterations":     "m=64,t=0,p=1",
+		"zero parallelism":    "m=64,t=1,p=0",
+		"wrapped parallelism": "m=4096,t=1,p=256",
+		"huge memory":         "m=4294967295,t=1,p=1",
+	} {
+		hash := fmt.Sprintf("$argon2id$v=19$%s$%s$%s", params, salt, key)
+		if err := Verify(hash, "password"); !errors.Is(err, ErrInvalidFormat) {
+			t.Errorf("%s: Verify = %v, want ErrInvalidFormat", name, err)
+		}
+	}
+
+	for _, hash := range []string{"", "$argon2i$v=19$m=64,t=1,p=1$c2FsdA$a2V5", "$argon2id$v=16$m=64,t=1,p=1$c2FsdA$a2V5"} {
+		if err := Verify(hash, "password"); !errors.Is(err, ErrInvalidFormat) {
+			t.Errorf("Verify(%q) = %v, want ErrInvalidFormat", hash, err)
+		}
+	}
+}
+
+func TestCurrentParamsFromEnv(t *testing.T) {
+	t.Setenv("ARGON2_ITERATIONS", "4")
+	t.Setenv("ARGON2_PARALLELISM", "256")
+	t.Setenv("ARGON2_MEMORY", "0")
+
+	params := CurrentParams()
+	if params.Iterations != 4 {
+		t.Errorf("iterations = %d, want 4 from the environment", params.Iterations)
+	}
+	if params.Parallelism != DefaultParams.Parallelism {
+		t.Errorf("parallelism = %d, want the default instead of a wrapped 256", params.Parallelism)
+	}
+	if params.Memory != DefaultParams.Memory {
+		t.Errorf("memory = %d, want the default instead of 0", params.Memory)
+	}
+}
+
+func TestCurrentParamsFallsBackWhenMemoryTooSmall(t *testing.T) {
+	t.Setenv("ARGON2_MEMORY", "16")
+	t.Setenv("ARGON2_PARALLELISM", "8")
+
+	if params := CurrentParams(); params != DefaultParams {
+		t.Fatalf("params = %+v, want the defaults", params)
+	}
+}
+
+func TestHashWithParamsRejectsInvalidParams(t *testing.T) {
+	params := testParams
+	params.Iterations = 0
+	if _, err := HashWithParams("password", params); !errors.Is(err, ErrInvalidParams) {
+		t.Fatalf("err = %v, want ErrInvalidParams", err)
+	}
+}
@@ -0,0 +1,74 @@
+package passpolicy
+
+import (
+	"bufio"
+	"crypto/sha1"
+	_ "embed"
+	"encoding/hex"
+	"os"
+	"path/filepath"
+	"sort"
+	"strings"
+	"sync"
+)
+
+// breached_sha1.txt holds the sorted, uppercase SHA-1 hashes of the most
+// common passwords from public breach corpora. It is always checked.
+//
+//go:embed breached_sha1.txt
+var bundledBreachedHashes string
+
+var (
+	bundledOnce   sync.Once
+	bundledHashes []string
+)
+
+// IsBreached reports whether the password is in the bundled list or, when
+// BREACHED_PASSWORDS_DIR is set, in a local copy of the Have I Been Pwned
+// range files. That directory uses the k-anonymity layout: one file per
+// 5-character hash prefix named "<PREFIX>.txt", each line holding the rest
+// of the hash and a count as "SUFFIX:COUNT". Only the file for the
+// password's prefix is read.
+func IsBreached(password string) bool {
+	sum := sha1.Sum([]byte(password))
+	hash := strings.ToUpper(hex.EncodeToString(sum[:]))
+
+	if inBundledList(hash) {
+		return true
+	}
+
+	if dir := os.Getenv("BREACHED_PASSWORDS_DIR"); dir != "" {
+		return inRangeFile(dir, hash)
+	}
+	return false
+}
+
+func inBundledList(hash string) bool {
+	bundledOnce.Do(func() {
+		bundledHashes = strings.Fields(bundledBreachedHashes)
+		sort.Strings(bundledHashes)
+	})
+
+	i := sort.SearchStrings(bundledHashes, hash)
+	return i < len(bundledHashes) && bundledHashes[i] == hash
+}
+
+func inRangeFile(dir, hash string) bool {
+	prefix, suffix := hash[:5], hash[5:]
+
+	file, err := os.Open(filepath.Join(dir, prefix+".txt"))
+	if err != nil {
+		return false
+	}
+	defer file.Close()
+
+	scanner := bufio.NewScanner(file)
+	for scanner.Scan() {
+		lineSuffix, count, _ := strings.Cut(strings.TrimSpace(scanner.Text()), ":")
+		// Padding entries in the range API have a count of zero
+		if strings.EqualFold(lineSuffix, suffix) && count != "0" {
+			return true
+		}
+	}
+	return false
+}
@@ -0,0 +1,166 @@
+// Package passpolicy checks new passwords against a configurable policy:
+// length, character classes, no nickname or email inside the password and no
+// password known from a breach. Violations are returned as a list so clients
+// can show every problem at once.
+package passpolicy
+
+import (
+	"os"
+	"strconv"
+	"strings"
+	"unicode"
+	"unicode/utf8"
+)
+
+// Violation codes
+const (
+	CodeTooShort          = "too_short"
+	CodeTooLong           = "too_long"
+	CodeMissingUppercase  = "missing_uppercase"
+	CodeMissingLowercase  = "missing_lowercase"
+	CodeMissingDigit      = "missing_digit"
+	CodeMissingSymbol     = "missing_symbol"
+	CodeTooFewCharClasses = "too_few_character_classes"
+	CodeContainsNickname  = "contains_nickname"
+	CodeContainsEmail     = "contains_email"
+	CodeBreached          = "breached"
+)
+
+// Violation is one rule the password breaks.
+type Violation struct {
+	Code    string `json:"code"`
+	Message string `json:"message"`
+}
+
+// Policy holds the rules. MinCharClasses counts how many of uppercase,
+// lowercase, digits and symbols must appear, on top of the Require flags.
+type Policy struct {
+	MinLength      int
+	MaxLength      int
+	RequireUpper   bool
+	RequireLower   bool
+	RequireDigit   bool
+	RequireSymbol  bool
+	MinCharClasses int
+	CheckBreached  bool
+}
+
+// DefaultPolicy is used when no PASSWORD_* variable is set.
+var DefaultPolicy = Policy{
+	MinLength:      8,
+	MaxLength:      128,
+	MinCharClasses: 3,
+	CheckBreached:  true,
+}
+
+// CurrentPolicy returns DefaultPolicy overridden by PASSWORD_MIN_LENGTH,
+// PASSWORD_MAX_LENGTH, PASSWORD_REQUIRE_UPPER, PASSWORD_REQUIRE_LOWER,
+// PASSWORD_REQUIRE_DIGIT, PASSWORD_REQUIRE_SYMBOL, PASSWORD_MIN_CHAR_CLASSES
+// and PASSWORD_CHECK_BREACHED.
+func CurrentPolicy() Policy {
+	policy := DefaultPolicy
+	policy.MinLength = envInt("PASSWORD_MIN_LENGTH", policy.MinLength)
+	policy.MaxLength = envInt("PASSWORD_MAX_LENGTH", policy.MaxLength)
+	policy.RequireUpper = envBool("PASSWORD_REQUIRE_UPPER", policy.RequireUpper)
+	policy.RequireLower = envBool("PASSWORD_REQUIRE_LOWER", policy.RequireLower)
+	policy.RequireDigit = envBool("PASSWORD_REQUIRE_DIGIT", policy.RequireDigit)
+	policy.RequireSymbol = envBool("PASSWORD_REQUIRE_SYMBOL", policy.RequireSymbol)
+	policy.MinCharClasses = envInt("PASSWORD_MIN_CHAR_CLASSES", policy.MinCharClasses)
+	policy.CheckBreached = envBool("PASSWORD_CHECK_BREACHED", policy.CheckBreached)
+	return policy
+}
+
+// Validate checks the password with the current policy.
+func Validate(password, nickname, email string) []Violation {
+	return CurrentPolicy().Validate(password, nickname, email)
+}
+
+// Validate returns every rule the password breaks, or nil if it is
+// acceptable. The nickname and email are those of the account the password
+// is for.
+func (p Policy) Validate(password, nickname, email string) []Violation {
+	var violations []Violation
+	add := func(code, message string) {
+		violations = append(violations, Violation{Code: code, Message: message})
+	}
+
+	length := utf8.RuneCountInString(password)
+	if length < p.MinLength {
+		add(CodeTooShort, "Password must be at least "+strconv.Itoa(p.MinLength)+" characters long")
+	}
+	if p.MaxLength > 0 && length > p.MaxLength {
+		add(CodeTooLong, "Password must be at most "+strconv.Itoa(p.MaxLength)+" characters long")
+	}
+
+	var hasUpper, hasLower, hasDigit, hasSymbol bool
+	for _, r := range password {
+		switch {
+		case unicode.IsUpper(r):
+			hasUpper = true
+		case unicode.IsLower(r):
+			hasLower = true
+		case unicode.IsDigit(r):
+			hasDigit = true
+		default:
+			hasSymbol = true
+		}
+	}
+
+	if p.RequireUpper && !hasUpper {
+		add(CodeMissingUppercase, "Password must contain an uppercase letter")
+	}
+	if p.RequireLower && !hasLower {
+		add(CodeMissingLowercase, "Password must contain a lowercase letter")
+	}
+	if p.RequireDigit && !hasDigit {
+		add(CodeMissingDigit, "Password must contain a digit")
+	}
+	if p.RequireSymbol && !hasSymbol {
+		add(CodeMissingSymbol, "Password must contain a symbol")
+	}
+
+	classes := 0
+	for _, present := range []bool{hasUpper, hasLower, hasDigit, hasSymbol} {
+		if present {
+			classes++
+		}
+	}
+	if classes < p.MinCharClasses {
+		add(CodeTooFewCharClasses, "Password must use at least "+strconv.Itoa(p.MinCharClasses)+" of uppercase letters, lowercase letters, digits and symbols")
+	}
+
+	lowered := strings.ToLower(password)
+	if containsIdentifier(lowered, nickname) {
+		add(CodeContainsNickname, "Password must not contain your nickname")
+	}
+	if localPart, _, _ := strings.Cut(email, "@"); containsIdentifier(lowered, localPart) || containsIdentifier(lowered, email) {
+		add(CodeContainsEmail, "Password must not contain your email address")
+	}
+
+	if p.CheckBreached && IsBreached(password) {
+		add(CodeBreached, "Password has appeared in a data breach, choose a different one")
+	}
+
+	return violations
+}
+
+// containsIdentifier ignores very short identifiers, which would reject
+// passwords by coincidence.
+func containsIdentifier(loweredPassword, identifier string) bool {
+	identifier = strings.ToLower(strings.TrimSpace(identifier))
+	return utf8.RuneCountInString(identifier) >= 3 && strings.Contains(loweredPassword, identifier)
+}
+
+func envInt(key string, fallback int) int {
+	if value, err := strconv.Atoi(os.Getenv(key)); err == nil {
+		return value
+	}
+	return fallback
+}
+
+func envBool(key string, fallback bool) bool {
+	if value, err := strconv.ParseBool(os.Getenv(key)); err == nil {
+		return value
+	}
+	return fallback
+}
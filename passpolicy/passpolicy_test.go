@@ -0,0 +1,110 @@
+package passpolicy
+
+import (
+	"os"
+	"path/filepath"
+	"sort"
+	"strings"
+	"testing"
+)
+
+func codes(violations []Violation) string {
+	list := make([]string, 0, len(violations))
+	for _, violation := range violations {
+		list = append(list, violation.Code)
+	}
+	sort.Strings(list)
+	return strings.Join(list, ",")
+}
+
+func TestPolicyValidate(t *testing.T) {
+	strict := Policy{MinLength: 8, MaxLength: 16, RequireUpper: true, RequireLower: true, RequireDigit: true, RequireSymbol: true}
+	classes := Policy{MinLength: 8, MinCharClasses: 3}
+
+	for _, test := range []struct {
+		name     string
+		policy   Policy
+		password string
+		nickname string
+		email    string
+		want     string
+	}{
+		{name: "acceptable", policy: strict, password: "Gr33n-Lantern", want: ""},
+		{name: "too short", policy: strict, password: "Gr3-en", want: CodeTooShort},
+		{name: "too long", policy: strict, password: "Gr33n-Lantern-Gr33n-Lantern", want: CodeTooLong},
+		{name: "missing uppercase", policy: strict, password: "gr33n-lantern", want: CodeMissingUppercase},
+		{name: "missing lowercase", policy: strict, password: "GR33N-LANTERN", want: CodeMissingLowercase},
+		{name: "missing digit", policy: strict, password: "Green-Lantern", want: CodeMissingDigit},
+		{name: "missing symbol", policy: strict, password: "Gr33nLantern", want: CodeMissingSymbol},
+		{name: "enough classes", policy: classes, password: "greenlantern42!", want: ""},
+		{name: "too few classes", policy: classes, password: "greenlantern42", want: CodeTooFewCharClasses},
+		{name: "no length limit", policy: classes, password: strings.Repeat("Ab1", 100), want: ""},
+		{name: "contains nickname", policy: classes, password: "Hal-Jordan-99", nickname: "jordan", want: CodeContainsNickname},
+		{name: "short nickname ignored", policy: classes, password: "Hal-Jordan-99", nickname: "al", want: ""},
+		{name: "contains email local part", policy: classes, password: "Hal.Jordan-99", email: "hal.jordan@example.com", want: CodeContainsEmail},
+		{name: "several violations", policy: strict, password: "hal", nickname: "hal", want: strings.Join([]string{CodeContainsNickname, CodeMissingDigit, CodeMissingSymbol, CodeMissingUppercase, CodeTooShort}, ",")},
+	} {
+		t.Run(test.name, func(t *testing.T) {
+			if got := codes(test.policy.Validate(test.password, test.nickname, test.email)); got != test.want {
+				t.Fatalf("violations = %q, want %q", got, test.want)
+			}
+		})
+	}
+}
+
+func TestPolicyValidateBreached(t *testing.T) {
+	policy := Policy{CheckBreached: true}
+
+	if got := codes(policy.Validate("Password1", "", "")); got != CodeBreached {
+		t.Fatalf("violations = %q, want %q", got, CodeBreached)
+	}
+
+	policy.CheckBreached = false
+	if got := codes(policy.Validate("Password1", "", "")); got != "" {
+		t.Fatalf("violations = %q with CheckBreached off, want none", got)
+	}
+}
+
+func TestIsBreachedBundledList(t *testing.T) {
+	t.Setenv("BREACHED_PASSWORDS_DIR", "")
+
+	for _, password := range []string{"password", "123456", "qwerty"} {
+		if !IsBreached(password) {
+			t.Errorf("IsBreached(%q) = false, want it in the bundled list", password)
+		}
+	}
+	if IsBreached("Tr0ub4dor&3x!Q") {
+		t.Error("IsBreached reported a password that is not in the list")
+	}
+}
+
+func TestIsBreachedRangeFiles(t *testing.T) {
+	// SHA-1 of "Tr0ub4dor&3x!Q" is 7B1DC A4F4FD12EDD414D2EEFA7732DB779200F9E
+	dir := t.TempDir()
+	writeRangeFile(t, dir, "7B1DC", "0000000000000000000000000000000000A:3\r\na4f4fd12edd414d2eefa7732db779200f9e:12\r\n")
+
+	t.Setenv("BREACHED_PASSWORDS_DIR", dir)
+	if !IsBreached("Tr0ub4dor&3x!Q") {
+		t.Fatal("password listed in its range file was not reported")
+	}
+	if IsBreached("not in any range file") {
+		t.Fatal("password without a range file was reported")
+	}
+}
+
+func TestIsBreachedRangeFilesSkipPadding(t *testing.T) {
+	dir := t.TempDir()
+	writeRangeFile(t, dir, "7B1DC", "A4F4FD12EDD414D2EEFA7732DB779200F9E:0\n")
+
+	t.Setenv("BREACHED_PASSWORDS_DIR", dir)
+	if IsBreached("Tr0ub4dor&3x!Q") {
+		t.Fatal("padding entry with a count of zero was reported")
+	}
+}
+
+func writeRangeFile(t *testing.T, dir, prefix, contents string) {
+	t.Helper()
+	if err := os.WriteFile(filepath.Join(dir, prefix+".txt"), []byte(contents), 0o600); err != nil {
+		t.Fatal(err)
+	}
+}
@@ -0,0 +1,44 @@
+package routes
+
+import (
+	"github.com/gin-gonic/gin"
+	"github.com/pramek008/go-jwt-project/controllers"
+	"github.com/pramek008/go-jwt-project/middleware"
+	"github.com/pramek008/go-jwt-project/models"
+)
+
+func AdminRoute(r *gin.Engine) {
+	admin := r.Group("/api/admin")
+	admin.Use(middleware.JWTMiddleware())
+
+	roles := admin.Group("")
+	roles.Use(middleware.RequirePermission(models.PermissionRolesManage))
+	{
+		roles.GET("/roles", controllers.ListRoles)
+		roles.POST("/roles", controllers.CreateRole)
+		roles.PUT("/roles/:id/permissions", controllers.UpdateRolePermissions)
+		roles.DELETE("/roles/:id", controllers.DeleteRole)
+		roles.GET("/permissions", controllers.ListPermissions)
+		roles.POST("/users/:id/roles", controllers.AssignUserRole)
+		roles.DELETE("/users/:id/roles/:role", controllers.RemoveUserRole)
+	}
+
+	users := admin.Group("/users")
+	{
+		users.GET("", middleware.RequirePermission(models.PermissionUsersRead), controllers.AdminListUsers)
+		users.GET("/:id", middleware.RequirePermission(models.PermissionUsersRead), controllers.AdminGetUser)
+		users.GET("/:id/sessions", middleware.RequirePermission(models.PermissionUsersRead), controllers.AdminListUserSessions)
+		users.GET("/:id/posts", middleware.RequirePermission(models.PermissionUsersRead), controllers.AdminListUserPosts)
+		users.POST("/:id/suspend", middleware.RequirePermission(models.PermissionUsersManage), controllers.AdminSuspendUser)
+		users.POST("/:id/unsuspend", middleware.RequirePermission(models.PermissionUsersManage), controllers.AdminUnsuspendUser)
+		users.POST("/:id/logout", middleware.RequirePermission(models.PermissionUsersManage), controllers.AdminForceLogout)
+	}
+
+	oauthClients := admin.Group("/oauth-clients")
+	oauthClients.Use(middleware.RequirePermission(models.PermissionOAuthClients))
+	{
+		oauthClients.GET("", controllers.AdminListOAuthClients)
+		oauthClients.POST("", controllers.AdminCreateOAuthClient)
+		oauthClients.DELETE("/:id", controllers.AdminRevokeOAuthClient)
+	}
+}
@@ -16,12 +16,51 @@ func AuthRoute(r *gin.Engine) {
 		auth.POST("/forgot-password", controllers.ForgotPassword)
 		auth.POST("/reset-password", controllers.ResetPassword)
 		auth.POST("/login", controllers.Login)
+		auth.POST("/login/mfa", controllers.LoginMFA)
+		auth.POST("/magic-link", controllers.RequestMagicLink)
+		auth.GET("/magic-link/verify", controllers.VerifyMagicLink)
+		auth.GET("/unlock", controllers.UnlockAccount)
+		auth.GET("/change-email/cancel", controllers.CancelEmailChange)
+		auth.POST("/webauthn/login/begin", controllers.BeginWebAuthnLogin)
+		auth.POST("/webauthn/login/finish", controllers.FinishWebAuthnLogin)
+		auth.POST("/refresh", controllers.RefreshToken)
+		auth.GET("/oidc/providers", controllers.ListIdentityProviders)
+		auth.GET("/oidc/:provider/login", controllers.BeginFederatedLogin)
+		auth.GET("/oidc/:provider/callback", controllers.FederatedLoginCallback)
+		auth.POST("/oidc/link/confirm", controllers.ConfirmIdentityLink)
+		auth.GET("/saml/:tenant/metadata", controllers.GetSAMLMetadata)
+		auth.GET("/saml/:tenant/login", controllers.BeginSAMLLogin)
+		auth.POST("/saml/:tenant/acs", controllers.SAMLAssertionConsumer)
 	}
 	protected := r.Group("/api/auth")
 	protected.Use(middleware.JWTMiddleware())
 	{
 		protected.POST("/logout", controllers.Logout)
 		protected.GET("/me", controllers.GetMe)
+		protected.DELETE("/me", controllers.DeleteAccount)
+		protected.GET("/me/export", controllers.ExportAccount)
+		protected.GET("/me/logins", controllers.ListLoginEvents)
+		protected.POST("/change-password", controllers.ChangePassword)
+		protected.POST("/change-email", controllers.RequestEmailChange)
+		protected.POST("/change-email/confirm", controllers.ConfirmEmailChange)
+		protected.GET("/sessions", controllers.ListSessions)
+		protected.DELETE("/sessions", controllers.RevokeOtherSessions)
+		protected.DELETE("/sessions/:id", controllers.RevokeSession)
+		protected.POST("/mfa/totp/enroll", controllers.EnrollTOTP)
+		protected.POST("/mfa/totp/confirm", controllers.ConfirmTOTP)
+		protected.POST("/mfa/totp/disable", controllers.DisableTOTP)
+		protected.POST("/mfa/recovery-codes", controllers.RegenerateRecoveryCodes)
+		protected.POST("/webauthn/register/begin", controllers.BeginWebAuthnRegistration)
+		protected.POST("/webauthn/register/finish", controllers.FinishWebAuthnRegistration)
+		protected.GET("/webauthn/credentials", controllers.ListWebAuthnCredentials)
+		protected.DELETE("/webauthn/credentials/:id", controllers.DeleteWebAuthnCredential)
+		protected.POST("/tokens", controllers.CreatePersonalAccessToken)
+		protected.GET("/tokens", controllers.ListPersonalAccessTokens)
+		protected.DELETE("/tokens/:id", controllers.RevokePersonalAccessToken)
+		protected.GET("/oauth-grants", controllers.ListOAuthGrants)
+		protected.DELETE("/oauth-grants/:id", controllers.RevokeOAuthGrant)
+		protected.GET("/identities", controllers.ListIdentities)
+		protected.DELETE("/identities/:id", controllers.UnlinkIdentity)
 	}
 
 }
@@ -0,0 +1,23 @@
+package routes
+
+import (
+	"github.com/gin-gonic/gin"
+	"github.com/pramek008/go-jwt-project/controllers"
+	"github.com/pramek008/go-jwt-project/middleware"
+	"github.com/pramek008/go-jwt-project/utils"
+)
+
+func OAuthRoute(r *gin.Engine) {
+	oauth := r.Group("/api/oauth")
+	{
+		oauth.GET("/authorize", middleware.JWTMiddleware(), controllers.Authorize)
+		oauth.POST("/authorize", middleware.JWTMiddleware(), controllers.ApproveAuthorization)
+		oauth.POST("/token", middleware.OAuthClientAuth(true), controllers.Token)
+		oauth.POST("/introspect", middleware.OAuthClientAuth(false), controllers.IntrospectToken)
+		oauth.POST("/revoke", middleware.OAuthClientAuth(false), controllers.RevokeToken)
+		oauth.GET("/userinfo", middleware.TokenAuthMiddleware(), middleware.RequireScope(utils.ScopeOpenID), controllers.UserInfo)
+		oauth.POST("/userinfo", middleware.TokenAuthMiddleware(), middleware.RequireScope(utils.ScopeOpenID), controllers.UserInfo)
+		oauth.GET("/logout", controllers.EndSession)
+		oauth.POST("/logout", controllers.EndSession)
+	}
+}
@@ -4,16 +4,17 @@ import (
 	"github.com/gin-gonic/gin"
 	"github.com/pramek008/go-jwt-project/controllers"
 	"github.com/pramek008/go-jwt-project/middleware"
+	"github.com/pramek008/go-jwt-project/utils"
 )
 
 func PostRoute(r *gin.Engine) {
 	protected := r.Group("/api")
-	protected.Use(middleware.JWTMiddleware())
+	protected.Use(middleware.TokenAuthMiddleware())
 	{
-		protected.POST("/posts", controllers.CreatePost)
-		protected.GET("/posts/:id", controllers.GetPost)
-		protected.PUT("/posts/:id", controllers.UpdatePost)
-		protected.DELETE("/posts/:id", controllers.DeletePost)
-		protected.GET("/posts", controllers.ListPosts)
+		protected.POST("/posts", middleware.RequireScope(utils.ScopePostsWrite), controllers.CreatePost)
+		protected.GET("/posts/:id", middleware.RequireScope(utils.ScopePostsRead), controllers.GetPost)
+		protected.PUT("/posts/:id", middleware.RequireScope(utils.ScopePostsWrite), controllers.UpdatePost)
+		protected.DELETE("/posts/:id", middleware.RequireScope(utils.ScopePostsWrite), controllers.DeletePost)
+		protected.GET("/posts", middleware.RequireScope(utils.ScopePostsRead), controllers.ListPosts)
 	}
 }
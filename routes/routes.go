@@ -15,6 +15,9 @@ func SetupRoutes(r *gin.Engine) {
 			utils.SendResponse[map[string]interface{}](ctx, 200, true, "Hello from the API!", nil)
 		})
 	}
+	WellKnownRoute(r)
 	AuthRoute(r)
 	PostRoute(r)
+	AdminRoute(r)
+	OAuthRoute(r)
 }
@@ -0,0 +1,14 @@
+package routes
+
+import (
+	"github.com/gin-gonic/gin"
+	"github.com/pramek008/go-jwt-project/controllers"
+)
+
+func WellKnownRoute(r *gin.Engine) {
+	wellKnown := r.Group("/.well-known")
+	{
+		wellKnown.GET("/jwks.json", controllers.GetJWKS)
+		wellKnown.GET("/openid-configuration", controllers.GetOpenIDConfiguration)
+	}
+}
@@ -0,0 +1,197 @@
+// utils/account_deletion_utils.go
+package utils
+
+import (
+	"errors"
+	"log/slog"
+	"os"
+	"strings"
+	"time"
+
+	"github.com/google/uuid"
+	"github.com/pramek008/go-jwt-project/database"
+	"github.com/pramek008/go-jwt-project/models"
+	"gorm.io/gorm"
+)
+
+const (
+	defaultDeletionGracePeriod = 30 * 24 * time.Hour
+
+	// Post policies for purged accounts, set with ACCOUNT_DELETION_POST_POLICY
+	PostPolicyReassign = "reassign"
+	PostPolicyDelete   = "delete"
+
+	deletedUserNickname = "deleted-user"
+	deletedUserEmail    = "deleted-user@invalid"
+)
+
+// AccountDeletionGracePeriod is how long a deleted account can still be
+// restored by logging in, set with ACCOUNT_DELETION_GRACE_PERIOD.
+func AccountDeletionGracePeriod() time.Duration {
+	return GetEnvDuration("ACCOUNT_DELETION_GRACE_PERIOD", defaultDeletionGracePeriod)
+}
+
+// accountDeletionPostPolicy decides what happens to the posts of a purged
+// account. "reassign" (default) keeps them under a placeholder user, so
+// threads stay readable, "delete" removes them.
+func accountDeletionPostPolicy() string {
+	if os.Getenv("ACCOUNT_DELETION_POST_POLICY") == PostPolicyDelete {
+		return PostPolicyDelete
+	}
+	return PostPolicyReassign
+}
+
+// ScheduleAccountDeletion marks the account for deletion and logs it out
+// everywhere, including its personal access tokens.
+func ScheduleAccountDeletion(userID uuid.UUID) (time.Time, error) {
+	purgeAt := time.Now().Add(AccountDeletionGracePeriod())
+
+	if err := database.DB.Db.Model(&models.User{}).Where("id = ?", userID).Update("deletion_scheduled_at", purgeAt).Error; err != nil {
+		return time.Time{}, err
+	}
+
+	if _, err := RevokeAllSessions(userID); err != nil {
+		return time.Time{}, err
+	}
+
+	err := database.DB.Db.Model(&models.PersonalAccessToken{}).
+		Where("user_id = ? AND revoked_at IS NULL", userID).
+		Update("revoked_at", time.Now()).Error
+	return purgeAt, err
+}
+
+// RestoreAccount cancels a scheduled deletion. It reports whether there was
+// one to cancel.
+func RestoreAccount(userID uuid.UUID) (bool, error) {
+	result := database.DB.Db.Model(&models.User{}).
+		Where("id = ? AND deletion_scheduled_at IS NOT NULL", userID).
+		Update("deletion_scheduled_at", nil)
+	return result.RowsAffected > 0, result.Error
+}
+
+// PurgeDeletedAccounts hard-deletes every account whose grace period is over
+// and returns how many were purged. Uploaded files are removed once the
+// database changes are committed.
+func PurgeDeletedAccounts() (int, error) {
+	var users []models.User
+	if err := database.DB.Db.Where("deletion_scheduled_at <= ?", time.Now()).Find(&users).Error; err != nil {
+		return 0, err
+	}
+
+	purged := 0
+	for _, user := range users {
+		var files []string
+		err := database.DB.Db.Transaction(func(tx *gorm.DB) error {
+			var err error
+			files, err = purgeAccount(tx, user)
+			return err
+		})
+		if err != nil {
+			slog.Error("Failed to purge deleted account", "user_id", user.ID, "error", err)
+			continue
+		}
+
+		for _, file := range files {
+			if err := os.Remove(file); err != nil && !errors.Is(err, os.ErrNotExist) {
+				slog.Warn("Failed to remove upload of purged account", "user_id", user.ID, "error", err)
+			}
+		}
+		purged++
+	}
+
+	return purged, nil
+}
+
+// purgeAccount removes everything that belongs to the user and returns the
+// uploaded files to delete.
+func purgeAccount(tx *gorm.DB, user models.User) ([]string, error) {
+	var posts []models.Post
+	if err := tx.Unscoped().Where("user_id = ?", user.ID).Find(&posts).Error; err != nil {
+		return nil, err
+	}
+
+	var files []string
+	for _, post := range posts {
+		if path, ok := UploadPathFromURL(post.FileURL); ok {
+			files = append(files, path)
+		}
+	}
+
+	if accountDeletionPostPolicy() == PostPolicyDelete {
+		if err := tx.Unscoped().Where("user_id = ?", user.ID).Delete(&models.Post{}).Error; err != nil {
+			return nil, err
+		}
+	} else {
+		placeholder, err := deletedUserPlaceholder(tx)
+		if err != nil {
+			return nil, err
+		}
+		if err := tx.Unscoped().Model(&models.Post{}).Where("user_id = ?", user.ID).
+			Updates(map[string]interface{}{"user_id": placeholder.ID, "file_url": ""}).Error; err != nil {
+			return nil, err
+		}
+	}
+
+	// Children first, most of them reference users with a foreign key
+	userOwned := []interface{}{
+		&models.Token{},
+		&models.RefreshToken{},
+		&models.Session{},
+		&models.TOTPFactor{},
+		&models.RecoveryCode{},
+		&models.MFAChallenge{},
+		&models.WebAuthnCredential{},
+		&models.WebAuthnSession{},
+		&models.ActionToken{},
+		&models.PersonalAccessToken{},
+		&models.EmailChangeRequest{},
+		&models.EmailChangeRevert{},
+		&models.LoginEvent{},
+		&models.OAuthGrant{},
+		&models.OAuthAuthorizationCode{},
+		&models.UserIdentity{},
+		&models.IdentityLinkRequest{},
+	}
+	for _, model := range userOwned {
+		if err := tx.Unscoped().Where("user_id = ?", user.ID).Delete(model).Error; err != nil {
+			return nil, err
+		}
+	}
+
+	if err := tx.Where("key = ?", LoginThrottleKeyUser(user.ID)).Delete(&models.LoginThrottle{}).Error; err != nil {
+		return nil, err
+	}
+	if err := tx.Where("email = ?", user.Email).Delete(&models.OTP{}).Error; err != nil {
+		return nil, err
+	}
+	if err := tx.Model(&user).Association("Roles").Clear(); err != nil {
+		return nil, err
+	}
+
+	return files, tx.Unscoped().Delete(&user).Error
+}
+
+// IsReservedNickname reports whether the nickname is the one of the
+// placeholder for purged accounts. Nobody may take it, or creating the
+// placeholder would fail and roll back every purge.
+func IsReservedNickname(nickname string) bool {
+	return strings.EqualFold(strings.TrimSpace(nickname), deletedUserNickname)
+}
+
+// deletedUserPlaceholder returns the user that inherits posts of purged
+// accounts, creating it on first use. It can never log in: its password is
+// not a valid hash and it is suspended.
+func deletedUserPlaceholder(tx *gorm.DB) (models.User, error) {
+	now := time.Now()
+	placeholder := models.User{
+		ID:               uuid.New(),
+		Nickname:         deletedUserNickname,
+		Email:            deletedUserEmail,
+		Password:         "!",
+		SuspendedAt:      &now,
+		SuspensionReason: "Placeholder for deleted accounts",
+	}
+
+	err := tx.Where("email = ?", deletedUserEmail).FirstOrCreate(&placeholder).Error
+	return placeholder, err
+}
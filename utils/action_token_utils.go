@@ -0,0 +1,90 @@
+// utils/action_token_utils.go
+package utils
+
+import (
+	"crypto/rand"
+	"crypto/subtle"
+	"encoding/base64"
+	"errors"
+	"time"
+
+	"github.com/google/uuid"
+	"github.com/pramek008/go-jwt-project/database"
+	"github.com/pramek008/go-jwt-project/models"
+)
+
+var ErrActionTokenInvalid = errors.New("invalid, expired or already used link")
+
+// GenerateNonce returns a random value suitable for binding a link to a
+// browser cookie.
+func GenerateNonce() (string, error) {
+	buffer := make([]byte, 32)
+	if _, err := rand.Read(buffer); err != nil {
+		return "", err
+	}
+	return base64.RawURLEncoding.EncodeToString(buffer), nil
+}
+
+// CreateActionToken replaces any unused token of the same purpose for the user
+// and returns the signed token to put in the emailed link. An empty nonce
+// creates a link that is not bound to a browser.
+func CreateActionToken(userID uuid.UUID, purpose, nonce string, ttl time.Duration) (string, error) {
+	token, _, err := createActionToken(userID, purpose, nonce, ttl)
+	return token, err
+}
+
+// createActionToken also returns the ID of the stored token, for records
+// that must only be used together with this link.
+func createActionToken(userID uuid.UUID, purpose, nonce string, ttl time.Duration) (string, uuid.UUID, error) {
+	db := database.DB.Db
+
+	if err := db.Where("user_id = ? AND purpose = ? AND used_at IS NULL", userID, purpose).Delete(&models.ActionToken{}).Error; err != nil {
+		return "", uuid.Nil, err
+	}
+
+	actionToken := models.ActionToken{
+		ID:        uuid.New(),
+		UserID:    userID,
+		Purpose:   purpose,
+		ExpiresAt: time.Now().Add(ttl),
+	}
+	if nonce != "" {
+		actionToken.NonceHash = hashToken(nonce)
+	}
+
+	if err := db.Create(&actionToken).Error; err != nil {
+		return "", uuid.Nil, err
+	}
+
+	token, err := GeneratePurposeToken(userID, purpose, actionToken.ID, actionToken.ExpiresAt)
+	return token, actionToken.ID, err
+}
+
+// ConsumeActionToken verifies the signed token and the browser nonce, then
+// marks the token as used. Only the first successful call wins.
+func ConsumeActionToken(tokenString, purpose, nonce string) (*models.ActionToken, error) {
+	claims, err := ExtractPurposeClaims(tokenString, purpose)
+	if err != nil {
+		return nil, ErrActionTokenInvalid
+	}
+
+	var actionToken models.ActionToken
+	err = database.DB.Db.Where("id = ? AND user_id = ? AND purpose = ? AND used_at IS NULL AND expires_at > ?",
+		claims.ID, claims.UserID, purpose, time.Now()).First(&actionToken).Error
+	if err != nil {
+		return nil, ErrActionTokenInvalid
+	}
+
+	if actionToken.NonceHash != "" && subtle.ConstantTimeCompare([]byte(actionToken.NonceHash), []byte(hashToken(nonce))) != 1 {
+		return nil, ErrActionTokenInvalid
+	}
+
+	result := database.DB.Db.Model(&models.ActionToken{}).
+		Where("id = ? AND used_at IS NULL", actionToken.ID).
+		Update("used_at", time.Now())
+	if result.Error != nil || result.RowsAffected == 0 {
+		return nil, ErrActionTokenInvalid
+	}
+
+	return &actionToken, nil
+}
@@ -0,0 +1,57 @@
+// utils/email_change_utils.go
+package utils
+
+import (
+	"time"
+
+	"github.com/google/uuid"
+	"github.com/pramek008/go-jwt-project/database"
+	"github.com/pramek008/go-jwt-project/models"
+	"gorm.io/gorm"
+)
+
+// CreateEmailCancelToken creates the cancel link sent to the current address
+// of an email change, remembering the address it restores. Unlike
+// CreateActionToken it only replaces earlier links for the same address:
+// the link sent to the owner's address must outlive a change confirmed by
+// whoever took over the account, and any request they make after it.
+func CreateEmailCancelToken(userID uuid.UUID, email string, ttl time.Duration) (string, error) {
+	actionToken := models.ActionToken{
+		ID:        uuid.New(),
+		UserID:    userID,
+		Purpose:   PurposeEmailCancel,
+		ExpiresAt: time.Now().Add(ttl),
+	}
+
+	err := database.DB.Db.Transaction(func(tx *gorm.DB) error {
+		var replaced []uuid.UUID
+		if err := tx.Model(&models.EmailChangeRevert{}).
+			Where("user_id = ? AND LOWER(email) = LOWER(?)", userID, email).
+			Pluck("action_token_id", &replaced).Error; err != nil {
+			return err
+		}
+		if len(replaced) > 0 {
+			if err := tx.Where("action_token_id IN ?", replaced).Delete(&models.EmailChangeRevert{}).Error; err != nil {
+				return err
+			}
+			if err := tx.Where("id IN ? AND used_at IS NULL", replaced).Delete(&models.ActionToken{}).Error; err != nil {
+				return err
+			}
+		}
+
+		if err := tx.Create(&actionToken).Error; err != nil {
+			return err
+		}
+		return tx.Create(&models.EmailChangeRevert{
+			ActionTokenID: actionToken.ID,
+			UserID:        userID,
+			Email:         email,
+			ExpiresAt:     actionToken.ExpiresAt,
+		}).Error
+	})
+	if err != nil {
+		return "", err
+	}
+
+	return GeneratePurposeToken(userID, PurposeEmailCancel, actionToken.ID, actionToken.ExpiresAt)
+}
@@ -0,0 +1,59 @@
+// utils/env_utils.go
+package utils
+
+import (
+	"errors"
+	"net/url"
+	"os"
+	"strconv"
+	"strings"
+	"time"
+)
+
+// GetEnvInt returns the integer value of the variable, or fallback when it is
+// unset or not a number.
+func GetEnvInt(key string, fallback int) int {
+	if value, err := strconv.Atoi(os.Getenv(key)); err == nil {
+		return value
+	}
+	return fallback
+}
+
+// GetEnvDuration parses values such as "15m" or "720h", falling back when the
+// variable is unset or invalid.
+func GetEnvDuration(key string, fallback time.Duration) time.Duration {
+	if value, err := time.ParseDuration(os.Getenv(key)); err == nil {
+		return value
+	}
+	return fallback
+}
+
+var publicURL string
+
+// LoadPublicURL reads PUBLIC_URL, the address users reach the API at, such
+// as https://api.example.com. Links sent by email are built from it and never
+// from request headers, which the client controls.
+func LoadPublicURL() error {
+	value := strings.TrimRight(os.Getenv("PUBLIC_URL"), "/")
+	if value == "" {
+		return errors.New("PUBLIC_URL is not set")
+	}
+
+	if !isAbsoluteURL(value) {
+		return errors.New("PUBLIC_URL must be an absolute http or https URL")
+	}
+
+	publicURL = value
+	return nil
+}
+
+// PublicURL returns the URL loaded by LoadPublicURL, without a trailing
+// slash.
+func PublicURL() string {
+	return publicURL
+}
+
+func isAbsoluteURL(value string) bool {
+	parsed, err := url.Parse(value)
+	return err == nil && (parsed.Scheme == "http" || parsed.Scheme == "https") && parsed.Host != ""
+}
@@ -1,29 +1,17 @@
 package utils
 
-import (
-	"log"
-
-	"golang.org/x/crypto/bcrypt"
-)
+import "github.com/pramek008/go-jwt-project/passhash"
 
 func HashPassword(password string) (string, error) {
-	hashedBytes, err := bcrypt.GenerateFromPassword([]byte(password), bcrypt.DefaultCost)
-	if err != nil {
-		log.Printf("Error hashing password: %v", err)
-		return "", err
-	}
-	hashedPassword := string(hashedBytes)
-	log.Printf("Original password: %s, Hashed password: %s", password, hashedPassword)
-	return hashedPassword, nil
+	return passhash.Hash(password)
 }
 
 func VerifyPassword(hashedPassword, password string) error {
-	log.Printf("Verifying - Stored hash: %s, Provided password: %s", hashedPassword, password)
-	err := bcrypt.CompareHashAndPassword([]byte(hashedPassword), []byte(password))
-	if err != nil {
-		log.Printf("Password verification failed: %v", err)
-	} else {
-		log.Printf("Password verified successfully")
-	}
-	return err
+	return passhash.Verify(hashedPassword, password)
+}
+
+// PasswordNeedsRehash reports hashes that should be replaced after the next
+// successful login, either bcrypt or argon2id with outdated parameters.
+func PasswordNeedsRehash(hashedPassword string) bool {
+	return passhash.NeedsRehash(hashedPassword)
 }
@@ -0,0 +1,317 @@
+// utils/jwt_keyring.go
+package utils
+
+import (
+	"crypto"
+	"crypto/ecdsa"
+	"crypto/ed25519"
+	"crypto/elliptic"
+	"crypto/rsa"
+	"crypto/x509"
+	"encoding/base64"
+	"encoding/pem"
+	"errors"
+	"fmt"
+	"math/big"
+	"os"
+	"path/filepath"
+	"sort"
+	"strings"
+	"sync"
+
+	"github.com/golang-jwt/jwt/v5"
+)
+
+// legacyKeyID identifies the shared JWT_SECRET. Tokens signed before the key
+// ring existed carry no kid header and are checked against this key.
+const legacyKeyID = "legacy-hs256"
+
+var (
+	ErrUnknownSigningKey = errors.New("unknown signing key")
+	ErrRetiredSigningKey = errors.New("signing key has been retired")
+)
+
+// SigningKey is one entry of the key ring. Verify-only keys (loaded from a
+// public key PEM) have no private part and are never used for signing.
+type SigningKey struct {
+	ID      string
+	Method  jwt.SigningMethod
+	Private interface{}
+	Public  interface{}
+	Retired bool
+}
+
+type keyRing struct {
+	mu     sync.RWMutex
+	active *SigningKey
+	keys   map[string]*SigningKey
+}
+
+var jwtKeys = &keyRing{}
+
+// LoadKeyRing (re)loads the signing keys. When JWT_KEYS_DIR is set every
+// *.pem file in it becomes a key whose kid is the file name without the
+// extension; JWT_ACTIVE_KID picks the signing key (the last private key in
+// file name order otherwise) and JWT_RETIRED_KIDS lists keys that must no
+// longer be accepted. JWT_SECRET, when set, stays available as a
+// verify-only HS256 key so tokens issued before the switch keep working,
+// and is the signing key when no key directory is configured.
+func LoadKeyRing() error {
+	keys := map[string]*SigningKey{}
+	var active *SigningKey
+
+	if secret := os.Getenv("JWT_SECRET"); secret != "" {
+		keys[legacyKeyID] = &SigningKey{
+			ID:      legacyKeyID,
+			Method:  jwt.SigningMethodHS256,
+			Private: []byte(secret),
+			Public:  []byte(secret),
+		}
+	}
+
+	keysDir := os.Getenv("JWT_KEYS_DIR")
+	if keysDir != "" {
+		files, err := filepath.Glob(filepath.Join(keysDir, "*.pem"))
+		if err != nil {
+			return err
+		}
+		sort.Strings(files)
+
+		for _, file := range files {
+			kid := strings.TrimSuffix(filepath.Base(file), ".pem")
+			key, err := loadPEMKey(kid, file)
+			if err != nil {
+				return fmt.Errorf("failed to load signing key %s: %w", file, err)
+			}
+			keys[kid] = key
+		}
+	}
+
+	for _, kid := range strings.Split(os.Getenv("JWT_RETIRED_KIDS"), ",") {
+		if key, ok := keys[strings.TrimSpace(kid)]; ok {
+			key.Retired = true
+		}
+	}
+
+	if activeKid := os.Getenv("JWT_ACTIVE_KID"); activeKid != "" {
+		active = keys[activeKid]
+		if active == nil || active.Private == nil || active.Retired {
+			return fmt.Errorf("active signing key %q is missing, verify-only or retired", activeKid)
+		}
+	} else if keysDir != "" {
+		kids := make([]string, 0, len(keys))
+		for kid := range keys {
+			kids = append(kids, kid)
+		}
+		sort.Strings(kids)
+		for _, kid := range kids {
+			key := keys[kid]
+			if kid != legacyKeyID && key.Private != nil && !key.Retired {
+				active = key
+			}
+		}
+	}
+
+	if legacy, ok := keys[legacyKeyID]; ok && active == nil && !legacy.Retired {
+		active = legacy
+	}
+
+	if active == nil {
+		return errors.New("no signing key configured, set JWT_SECRET or JWT_KEYS_DIR")
+	}
+
+	jwtKeys.mu.Lock()
+	jwtKeys.keys = keys
+	jwtKeys.active = active
+	jwtKeys.mu.Unlock()
+
+	return nil
+}
+
+func activeSigningKey() (*SigningKey, error) {
+	jwtKeys.mu.RLock()
+	defer jwtKeys.mu.RUnlock()
+
+	if jwtKeys.active == nil {
+		return nil, errors.New("key ring has not been loaded")
+	}
+	return jwtKeys.active, nil
+}
+
+// signToken signs the claims with the active key and sets the kid header.
+func signToken(claims jwt.Claims) (string, error) {
+	key, err := activeSigningKey()
+	if err != nil {
+		return "", err
+	}
+
+	token := jwt.NewWithClaims(key.Method, claims)
+	if key.ID != legacyKeyID {
+		token.Header["kid"] = key.ID
+	}
+	return token.SignedString(key.Private)
+}
+
+// verificationKey is the jwt.Keyfunc used when parsing tokens. It accepts any
+// non-retired key in the ring, as long as the token's algorithm matches the
+// one the key was loaded for.
+func verificationKey(token *jwt.Token) (interface{}, error) {
+	kid, _ := token.Header["kid"].(string)
+	if kid == "" {
+		kid = legacyKeyID
+	}
+
+	jwtKeys.mu.RLock()
+	key, ok := jwtKeys.keys[kid]
+	jwtKeys.mu.RUnlock()
+
+	if !ok {
+		return nil, ErrUnknownSigningKey
+	}
+	if key.Retired {
+		return nil, ErrRetiredSigningKey
+	}
+	if token.Method.Alg() != key.Method.Alg() {
+		return nil, fmt.Errorf("unexpected signing method %s for key %s", token.Method.Alg(), kid)
+	}
+
+	return key.Public, nil
+}
+
+func loadPEMKey(kid, path string) (*SigningKey, error) {
+	data, err := os.ReadFile(path)
+	if err != nil {
+		return nil, err
+	}
+
+	block, _ := pem.Decode(data)
+	if block == nil {
+		return nil, errors.New("no PEM data found")
+	}
+
+	var private, public interface{}
+	switch block.Type {
+	case "PRIVATE KEY":
+		private, err = x509.ParsePKCS8PrivateKey(block.Bytes)
+	case "RSA PRIVATE KEY":
+		private, err = x509.ParsePKCS1PrivateKey(block.Bytes)
+	case "EC PRIVATE KEY":
+		private, err = x509.ParseECPrivateKey(block.Bytes)
+	case "PUBLIC KEY":
+		public, err = x509.ParsePKIXPublicKey(block.Bytes)
+	default:
+		return nil, fmt.Errorf("unsupported PEM block %q", block.Type)
+	}
+	if err != nil {
+		return nil, err
+	}
+
+	if private != nil {
+		signer, ok := private.(crypto.Signer)
+		if !ok {
+			return nil, errors.New("private key cannot sign")
+		}
+		public = signer.Public()
+	}
+
+	method, err := signingMethodFor(public)
+	if err != nil {
+		return nil, err
+	}
+
+	return &SigningKey{
+		ID:      kid,
+		Method:  method,
+		Private: private,
+		Public:  public,
+	}, nil
+}
+
+// signingMethodFor maps a public key to the algorithm used with it. RSA keys
+// default to RS256 and can be switched to another RSA algorithm with
+// JWT_RSA_ALG (for example PS256).
+func signingMethodFor(public interface{}) (jwt.SigningMethod, error) {
+	switch key := public.(type) {
+	case *rsa.PublicKey:
+		alg := os.Getenv("JWT_RSA_ALG")
+		if alg == "" {
+			alg = "RS256"
+		}
+		method := jwt.GetSigningMethod(alg)
+		switch method.(type) {
+		case *jwt.SigningMethodRSA, *jwt.SigningMethodRSAPSS:
+			return method, nil
+		}
+		return nil, fmt.Errorf("unsupported RSA algorithm %q", alg)
+	case *ecdsa.PublicKey:
+		switch key.Curve {
+		case elliptic.P256():
+			return jwt.SigningMethodES256, nil
+		case elliptic.P384():
+			return jwt.SigningMethodES384, nil
+		case elliptic.P521():
+			return jwt.SigningMethodES512, nil
+		}
+		return nil, errors.New("unsupported elliptic curve")
+	case ed25519.PublicKey:
+		return jwt.SigningMethodEdDSA, nil
+	}
+	return nil, fmt.Errorf("unsupported key type %T", public)
+}
+
+// JSONWebKey is a public key in RFC 7517 form.
+type JSONWebKey struct {
+	Kty string `json:"kty"`
+	Kid string `json:"kid"`
+	Use string `json:"use"`
+	Alg string `json:"alg"`
+	N   string `json:"n,omitempty"`
+	E   string `json:"e,omitempty"`
+	Crv string `json:"crv,omitempty"`
+	X   string `json:"x,omitempty"`
+	Y   string `json:"y,omitempty"`
+}
+
+// PublicJWKS returns the non-retired asymmetric keys of the ring. Shared
+// HMAC secrets are never published.
+func PublicJWKS() []JSONWebKey {
+	jwtKeys.mu.RLock()
+	defer jwtKeys.mu.RUnlock()
+
+	kids := make([]string, 0, len(jwtKeys.keys))
+	for kid := range jwtKeys.keys {
+		kids = append(kids, kid)
+	}
+	sort.Strings(kids)
+
+	jwks := []JSONWebKey{}
+	for _, kid := range kids {
+		key := jwtKeys.keys[kid]
+		if key.Retired {
+			continue
+		}
+
+		jwk := JSONWebKey{Kid: key.ID, Use: "sig", Alg: key.Method.Alg()}
+		switch public := key.Public.(type) {
+		case *rsa.PublicKey:
+			jwk.Kty = "RSA"
+			jwk.N = base64.RawURLEncoding.EncodeToString(public.N.Bytes())
+			jwk.E = base64.RawURLEncoding.EncodeToString(big.NewInt(int64(public.E)).Bytes())
+		case *ecdsa.PublicKey:
+			size := (public.Curve.Params().BitSize + 7) / 8
+			jwk.Kty = "EC"
+			jwk.Crv = public.Curve.Params().Name
+			jwk.X = base64.RawURLEncoding.EncodeToString(public.X.FillBytes(make([]byte, size)))
+			jwk.Y = base64.RawURLEncoding.EncodeToString(public.Y.FillBytes(make([]byte, size)))
+		case ed25519.PublicKey:
+			jwk.Kty = "OKP"
+			jwk.Crv = "Ed25519"
+			jwk.X = base64.RawURLEncoding.EncodeToString(public)
+		default:
+			continue
+		}
+		jwks = append(jwks, jwk)
+	}
+
+	return jwks
+}
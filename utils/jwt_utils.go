@@ -2,39 +2,94 @@
 package utils
 
 import (
+	"errors"
 	"os"
+	"strings"
 	"time"
 
-	"github.com/dgrijalva/jwt-go"
+	"github.com/golang-jwt/jwt/v5"
 	"github.com/google/uuid"
 	"github.com/pramek008/go-jwt-project/database"
 	"github.com/pramek008/go-jwt-project/models"
 )
 
-var jwtKey = []byte(os.Getenv("JWT_SECRET"))
+// Access tokens are short-lived; clients renew them with a refresh token.
+const accessTokenExpiryDuration = 15 * time.Minute
+
+// defaultClockSkew is how far exp, nbf and iat may be off when the issuing
+// and verifying machines' clocks disagree. Override with JWT_CLOCK_SKEW.
+const defaultClockSkew = 30 * time.Second
+
+// Purpose tokens are signed like access tokens but only unlock one step of a
+// flow. They are never accepted as access tokens.
+const (
+	PurposeMFAPending   = "mfa_pending"
+	PurposeMagicLink    = "magic_link"
+	PurposeUnlock       = "account_unlock"
+	PurposeEmailCancel  = "email_change_cancel"
+	PurposeIdentityLink = "identity_link"
+)
 
+// Claims of every token we sign. ClientID and Scope are only set on tokens
+// issued to an OAuth client.
 type Claims struct {
-	UserID uuid.UUID `json:"user_id"`
-	jwt.StandardClaims
+	UserID   uuid.UUID `json:"user_id"`
+	Roles    []string  `json:"roles,omitempty"`
+	Purpose  string    `json:"purpose,omitempty"`
+	ClientID string    `json:"client_id,omitempty"`
+	Scope    string    `json:"scope,omitempty"`
+	jwt.RegisteredClaims
+}
+
+// GenerateToken issues an access token for the session. The user's roles are
+// embedded, so role changes take effect once the token is refreshed.
+func GenerateToken(userID, sessionID uuid.UUID) (string, error) {
+	return generateAccessToken(userID, sessionID, "", "")
 }
 
-func GenerateToken(userID uuid.UUID) (string, error) {
-	expirationTime := time.Now().Add(24 * time.Hour)
+// GenerateDelegatedToken issues an access token for a session an OAuth client
+// obtained on the user's behalf. It is limited to the consented scope and
+// carries no roles.
+func GenerateDelegatedToken(userID, sessionID uuid.UUID, clientID, scope string) (string, error) {
+	return generateAccessToken(userID, sessionID, clientID, scope)
+}
+
+func generateAccessToken(userID, sessionID uuid.UUID, clientID, scope string) (string, error) {
+	now := time.Now()
+	expirationTime := now.Add(accessTokenExpiryDuration)
+	jti := uuid.New()
+
+	var roles []string
+	if clientID == "" {
+		var err error
+		if roles, err = UserRoleNames(userID); err != nil {
+			return "", err
+		}
+	}
+
 	claims := &Claims{
-		UserID: userID,
-		StandardClaims: jwt.StandardClaims{
-			ExpiresAt: expirationTime.Unix(),
+		UserID:   userID,
+		Roles:    roles,
+		ClientID: clientID,
+		Scope:    scope,
+		RegisteredClaims: jwt.RegisteredClaims{
+			ID:        jti.String(),
+			Issuer:    jwtIssuer(),
+			Subject:   userID.String(),
+			Audience:  jwtAudience(),
+			IssuedAt:  jwt.NewNumericDate(now),
+			NotBefore: jwt.NewNumericDate(now),
+			ExpiresAt: jwt.NewNumericDate(expirationTime),
 		},
 	}
 
-	token := jwt.NewWithClaims(jwt.SigningMethodHS256, claims)
-	tokenString, err := token.SignedString(jwtKey)
+	tokenString, err := signToken(claims)
 	if err != nil {
 		return "", err
 	}
 
-	// Simpan token ke database dan hapus token lama
-	err = saveTokenToDB(userID, tokenString, expirationTime)
+	// Simpan token ke database untuk sesi ini
+	err = saveTokenToDB(userID, sessionID, jti, expirationTime, clientID, scope)
 	if err != nil {
 		return "", err
 	}
@@ -42,52 +97,142 @@ func GenerateToken(userID uuid.UUID) (string, error) {
 	return tokenString, nil
 }
 
-func saveTokenToDB(userID uuid.UUID, tokenString string, expirationTime time.Time) error {
+func saveTokenToDB(userID, sessionID, jti uuid.UUID, expirationTime time.Time, clientID, scope string) error {
 	db := database.DB.Db
 
-	// Hapus token lama
-	var oldTokens []models.Token
-	db.Where("user_id = ?", userID).Find(&oldTokens)
-	for _, oldToken := range oldTokens {
-		db.Delete(&oldToken)
-	}
+	// Hapus token sesi ini yang sudah kedaluwarsa, sesi lain tidak disentuh
+	db.Where("session_id = ? AND expired_at < ?", sessionID, time.Now()).Delete(&models.Token{})
 
 	// Simpan token baru
 	newToken := models.Token{
-		Token:     tokenString,
+		JTI:       jti,
 		UserID:    userID,
+		SessionID: &sessionID,
 		ExpiredAt: expirationTime,
+		ClientID:  clientID,
+		Scope:     scope,
 	}
 	return db.Create(&newToken).Error
 }
 
+// GeneratePurposeToken signs a short-lived token for a single step of a flow.
+// The jti lets the caller track the step in the database.
+func GeneratePurposeToken(userID uuid.UUID, purpose string, jti uuid.UUID, expiresAt time.Time) (string, error) {
+	now := time.Now()
+	claims := &Claims{
+		UserID:  userID,
+		Purpose: purpose,
+		RegisteredClaims: jwt.RegisteredClaims{
+			ID:        jti.String(),
+			Issuer:    jwtIssuer(),
+			Subject:   userID.String(),
+			Audience:  jwtAudience(),
+			IssuedAt:  jwt.NewNumericDate(now),
+			NotBefore: jwt.NewNumericDate(now),
+			ExpiresAt: jwt.NewNumericDate(expiresAt),
+		},
+	}
+
+	return signToken(claims)
+}
+
+// ValidateToken checks the signature and the registered claims: exp, nbf and
+// iat within the allowed clock skew, plus iss and aud when JWT_ISSUER and
+// JWT_AUDIENCE are configured.
 func ValidateToken(tokenString string) (*jwt.Token, error) {
-	claims := &Claims{}
-	token, err := jwt.ParseWithClaims(tokenString, claims, func(token *jwt.Token) (interface{}, error) {
-		return jwtKey, nil
-	})
+	options := []jwt.ParserOption{
+		jwt.WithLeeway(jwtClockSkew()),
+		jwt.WithIssuedAt(),
+		jwt.WithExpirationRequired(),
+	}
+	if issuer := jwtIssuer(); issuer != "" {
+		options = append(options, jwt.WithIssuer(issuer))
+	}
+	// A token is accepted when any of its audiences is one of ours
+	audiences := jwtAudience()
 
+	claims := &Claims{}
+	token, err := jwt.ParseWithClaims(tokenString, claims, verificationKey, options...)
 	if err != nil {
 		return nil, err
 	}
 
+	if len(audiences) > 0 && !hasAudience(claims.Audience, audiences) {
+		return nil, jwt.ErrTokenInvalidAudience
+	}
+
 	return token, nil
 }
 
-func ExtractUserIDFromToken(tokenString string) (uuid.UUID, error) {
+// ExtractClaimsFromToken validates an access token and returns its claims.
+// Tokens without a jti are rejected since they cannot be tracked or revoked.
+func ExtractClaimsFromToken(tokenString string) (*Claims, error) {
+	return extractClaims(tokenString, "")
+}
+
+// ExtractPurposeClaims validates a token issued by GeneratePurposeToken for
+// the given purpose.
+func ExtractPurposeClaims(tokenString, purpose string) (*Claims, error) {
+	return extractClaims(tokenString, purpose)
+}
+
+func extractClaims(tokenString, purpose string) (*Claims, error) {
 	token, err := ValidateToken(tokenString)
 	if err != nil {
-		return uuid.Nil, err
+		return nil, err
 	}
 
-	if !token.Valid {
-		return uuid.Nil, err
+	claims, ok := token.Claims.(*Claims)
+	if !ok || !token.Valid {
+		return nil, errors.New("invalid token claims")
 	}
 
-	claims, ok := token.Claims.(*Claims)
-	if !ok {
+	if _, err := uuid.Parse(claims.ID); err != nil {
+		return nil, errors.New("token has no valid jti")
+	}
+
+	if claims.Purpose != purpose {
+		return nil, errors.New("token was issued for a different purpose")
+	}
+
+	return claims, nil
+}
+
+func ExtractUserIDFromToken(tokenString string) (uuid.UUID, error) {
+	claims, err := ExtractClaimsFromToken(tokenString)
+	if err != nil {
 		return uuid.Nil, err
 	}
 
 	return claims.UserID, nil
 }
+
+func jwtIssuer() string {
+	return os.Getenv("JWT_ISSUER")
+}
+
+// jwtAudience reads the comma separated JWT_AUDIENCE list.
+func jwtAudience() jwt.ClaimStrings {
+	var audience jwt.ClaimStrings
+	for _, aud := range strings.Split(os.Getenv("JWT_AUDIENCE"), ",") {
+		if aud = strings.TrimSpace(aud); aud != "" {
+			audience = append(audience, aud)
+		}
+	}
+	return audience
+}
+
+func jwtClockSkew() time.Duration {
+	return GetEnvDuration("JWT_CLOCK_SKEW", defaultClockSkew)
+}
+
+func hasAudience(tokenAudience, accepted jwt.ClaimStrings) bool {
+	for _, aud := range tokenAudience {
+		for _, want := range accepted {
+			if aud == want {
+				return true
+			}
+		}
+	}
+	return false
+}
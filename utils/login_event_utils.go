@@ -0,0 +1,103 @@
+// utils/login_event_utils.go
+package utils
+
+import (
+	"net"
+	"strings"
+
+	"github.com/google/uuid"
+	"github.com/mileusna/useragent"
+	"github.com/pramek008/go-jwt-project/database"
+	"github.com/pramek008/go-jwt-project/models"
+)
+
+// Login methods recorded with login events
+const (
+	LoginMethodPassword     = "password"
+	LoginMethodRegistration = "registration"
+	LoginMethodMagicLink    = "magic_link"
+	LoginMethodPasskey      = "passkey"
+	LoginMethodMFA          = "mfa"
+	LoginMethodRefreshToken = "refresh_token"
+	LoginMethodOAuth        = "oauth"
+	LoginMethodOIDC         = "oidc"
+	LoginMethodSAML         = "saml"
+)
+
+// NewLoginEvent fills in the device details parsed from the user agent.
+func NewLoginEvent(userID uuid.UUID, sessionID *uuid.UUID, event, method, ipAddress, userAgent string) models.LoginEvent {
+	ua := useragent.Parse(userAgent)
+
+	device := "unknown"
+	switch {
+	case ua.Bot:
+		device = "bot"
+	case ua.Tablet:
+		device = "tablet"
+	case ua.Mobile:
+		device = "mobile"
+	case ua.Desktop:
+		device = "desktop"
+	}
+
+	loginEvent := models.LoginEvent{
+		UserID:    userID,
+		SessionID: sessionID,
+		Event:     event,
+		Method:    method,
+		IPAddress: ipAddress,
+		UserAgent: truncate(userAgent, 512),
+		Browser:   truncate(strings.TrimSpace(ua.Name+" "+ua.Version), 64),
+		OS:        truncate(strings.TrimSpace(ua.OS+" "+ua.OSVersion), 64),
+		Device:    device,
+	}
+
+	// Versions are left out so a browser update is not a new device. The
+	// user agent is easy to copy, so the network it logs in from counts too.
+	loginEvent.Fingerprint = hashToken(strings.Join([]string{ua.Name, ua.OS, ua.Device, device, ipNetwork(ipAddress)}, "|"))
+	return loginEvent
+}
+
+// ipNetwork returns the /24 of an IPv4 or the /48 of an IPv6 address, so a
+// new address from the same provider network is still the same device.
+func ipNetwork(ipAddress string) string {
+	ip := net.ParseIP(ipAddress)
+	if ip == nil {
+		return ipAddress
+	}
+	if ipv4 := ip.To4(); ipv4 != nil {
+		return ipv4.Mask(net.CIDRMask(24, 32)).String() + "/24"
+	}
+	return ip.Mask(net.CIDRMask(48, 128)).String() + "/48"
+}
+
+// RecordLoginEvent saves the event. For a successful login it reports
+// whether the device was never seen in an earlier successful login. The
+// very first login of an account is not reported as a new device.
+func RecordLoginEvent(loginEvent *models.LoginEvent) (bool, error) {
+	newDevice := false
+
+	if loginEvent.Event == models.LoginEventSuccess {
+		var previousLogins, sameDevice int64
+		if err := database.DB.Db.Model(&models.LoginEvent{}).
+			Where("user_id = ? AND event = ?", loginEvent.UserID, models.LoginEventSuccess).
+			Count(&previousLogins).Error; err != nil {
+			return false, err
+		}
+		if err := database.DB.Db.Model(&models.LoginEvent{}).
+			Where("user_id = ? AND event = ? AND fingerprint = ?", loginEvent.UserID, models.LoginEventSuccess, loginEvent.Fingerprint).
+			Count(&sameDevice).Error; err != nil {
+			return false, err
+		}
+		newDevice = previousLogins > 0 && sameDevice == 0
+	}
+
+	return newDevice, database.DB.Db.Create(loginEvent).Error
+}
+
+func truncate(value string, max int) string {
+	if len(value) <= max {
+		return value
+	}
+	return strings.ToValidUTF8(value[:max], "")
+}
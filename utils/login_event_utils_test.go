@@ -0,0 +1,46 @@
+package utils
+
+import (
+	"testing"
+
+	"github.com/google/uuid"
+	"github.com/pramek008/go-jwt-project/models"
+)
+
+const testUserAgent = "Mozilla/5.0 (X11; Linux x86_64) AppleWebKit/537.36 (KHTML, like Gecko) Chrome/120.0.0.0 Safari/537.36"
+
+func TestNewLoginEventFingerprint(t *testing.T) {
+	fingerprint := func(ipAddress, userAgent string) string {
+		return NewLoginEvent(uuid.New(), nil, models.LoginEventSuccess, LoginMethodPassword, ipAddress, userAgent).Fingerprint
+	}
+
+	home := fingerprint("203.0.113.10", testUserAgent)
+	if fingerprint("203.0.113.99", testUserAgent) != home {
+		t.Error("another address in the same /24 is a new device")
+	}
+	if fingerprint("198.51.100.10", testUserAgent) == home {
+		t.Error("the same user agent from another network is not a new device")
+	}
+
+	updated := "Mozilla/5.0 (X11; Linux x86_64) AppleWebKit/537.36 (KHTML, like Gecko) Chrome/121.0.0.0 Safari/537.36"
+	if fingerprint("203.0.113.10", updated) != home {
+		t.Error("a browser update is a new device")
+	}
+
+	if fingerprint("2001:db8:1:2::1", testUserAgent) != fingerprint("2001:db8:1:ffff::2", testUserAgent) {
+		t.Error("another address in the same IPv6 /48 is a new device")
+	}
+}
+
+func TestIPNetwork(t *testing.T) {
+	for ipAddress, want := range map[string]string{
+		"203.0.113.10":    "203.0.113.0/24",
+		"::ffff:10.1.2.3": "10.1.2.0/24",
+		"2001:db8:1:2::1": "2001:db8:1::/48",
+		"not an ip":       "not an ip",
+	} {
+		if got := ipNetwork(ipAddress); got != want {
+			t.Errorf("ipNetwork(%q) = %q, want %q", ipAddress, got, want)
+		}
+	}
+}
@@ -0,0 +1,155 @@
+// utils/login_throttle_utils.go
+package utils
+
+import (
+	"math"
+	"time"
+
+	"github.com/google/uuid"
+	"github.com/pramek008/go-jwt-project/database"
+	"github.com/pramek008/go-jwt-project/models"
+	"gorm.io/gorm"
+	"gorm.io/gorm/clause"
+)
+
+const (
+	loginBackoffBase    = time.Second
+	loginBackoffMax     = 15 * time.Minute
+	loginFailureWindow  = time.Hour
+	defaultLockAttempts = 10
+	defaultLockDuration = 30 * time.Minute
+)
+
+// loginThrottlePolicy decides how a key is slowed down. The first
+// FreeAttempts failures cost nothing, after that every failure doubles the
+// wait. Reaching LockAttempts blocks the key for LockDuration.
+type loginThrottlePolicy struct {
+	FreeAttempts int
+	LockAttempts int
+	LockDuration time.Duration
+}
+
+// accountThrottlePolicy protects a single account against distributed
+// guessing. LOGIN_LOCK_ATTEMPTS and LOGIN_LOCK_DURATION tune the lock.
+func accountThrottlePolicy() loginThrottlePolicy {
+	return loginThrottlePolicy{
+		FreeAttempts: 3,
+		LockAttempts: GetEnvInt("LOGIN_LOCK_ATTEMPTS", defaultLockAttempts),
+		LockDuration: GetEnvDuration("LOGIN_LOCK_DURATION", defaultLockDuration),
+	}
+}
+
+// ipThrottlePolicy slows down a single client trying many accounts. Clients
+// behind a shared NAT are only delayed, never locked.
+func ipThrottlePolicy() loginThrottlePolicy {
+	return loginThrottlePolicy{FreeAttempts: 10}
+}
+
+func LoginThrottleKeyUser(userID uuid.UUID) string {
+	return "user:" + userID.String()
+}
+
+func LoginThrottleKeyIP(ip string) string {
+	return "ip:" + ip
+}
+
+// LoginRetryAfter returns how long the key must wait before the next attempt,
+// or zero if it may try now.
+func LoginRetryAfter(key string) time.Duration {
+	var throttle models.LoginThrottle
+	if err := database.DB.Db.Where("key = ?", key).First(&throttle).Error; err != nil {
+		return 0
+	}
+
+	if throttle.BlockedUntil == nil {
+		return 0
+	}
+	if wait := time.Until(*throttle.BlockedUntil); wait > 0 {
+		return wait
+	}
+	return 0
+}
+
+// IsAccountLocked reports whether the account reached the lock threshold and
+// is still within its lock period.
+func IsAccountLocked(userID uuid.UUID) bool {
+	var throttle models.LoginThrottle
+	if err := database.DB.Db.Where("key = ?", LoginThrottleKeyUser(userID)).First(&throttle).Error; err != nil {
+		return false
+	}
+
+	policy := accountThrottlePolicy()
+	return throttle.Failures >= policy.LockAttempts && throttle.BlockedUntil != nil && time.Now().Before(*throttle.BlockedUntil)
+}
+
+// RecordAccountLoginFailure counts a wrong password for the account and
+// reports whether this failure locked it.
+func RecordAccountLoginFailure(userID uuid.UUID) (bool, error) {
+	return recordLoginFailure(LoginThrottleKeyUser(userID), accountThrottlePolicy())
+}
+
+func RecordIPLoginFailure(ip string) error {
+	_, err := recordLoginFailure(LoginThrottleKeyIP(ip), ipThrottlePolicy())
+	return err
+}
+
+// ResetLoginFailures clears the counter, after a successful login or when the
+// user follows the unlock link.
+func ResetLoginFailures(key string) error {
+	return database.DB.Db.Where("key = ?", key).Delete(&models.LoginThrottle{}).Error
+}
+
+func recordLoginFailure(key string, policy loginThrottlePolicy) (bool, error) {
+	locked := false
+
+	err := database.DB.Db.Transaction(func(tx *gorm.DB) error {
+		now := time.Now()
+
+		if err := tx.Clauses(clause.OnConflict{DoNothing: true}).
+			Create(&models.LoginThrottle{Key: key, LastFailureAt: now}).Error; err != nil {
+			return err
+		}
+
+		var throttle models.LoginThrottle
+		if err := tx.Clauses(clause.Locking{Strength: "UPDATE"}).Where("key = ?", key).First(&throttle).Error; err != nil {
+			return err
+		}
+
+		wasLocked := policy.LockAttempts > 0 && throttle.Failures >= policy.LockAttempts &&
+			throttle.BlockedUntil != nil && now.Before(*throttle.BlockedUntil)
+
+		// Old failures are forgiven once the key has been quiet for a while
+		if now.Sub(throttle.LastFailureAt) > loginFailureWindow {
+			throttle.Failures = 0
+		}
+
+		throttle.Failures++
+		throttle.LastFailureAt = now
+		throttle.BlockedUntil = nil
+
+		if policy.LockAttempts > 0 && throttle.Failures >= policy.LockAttempts {
+			blockedUntil := now.Add(policy.LockDuration)
+			throttle.BlockedUntil = &blockedUntil
+			// Report every new lock, including a re-lock after the previous
+			// one expired, but not failures while the account stays locked
+			locked = !wasLocked
+		} else if throttle.Failures > policy.FreeAttempts {
+			blockedUntil := now.Add(loginBackoff(throttle.Failures - policy.FreeAttempts))
+			throttle.BlockedUntil = &blockedUntil
+		}
+
+		return tx.Save(&throttle).Error
+	})
+
+	return locked, err
+}
+
+// loginBackoff doubles the wait for every failure past the free attempts:
+// 1s, 2s, 4s, ... up to loginBackoffMax.
+func loginBackoff(excessFailures int) time.Duration {
+	backoff := float64(loginBackoffBase) * math.Pow(2, float64(excessFailures-1))
+	if backoff > float64(loginBackoffMax) {
+		return loginBackoffMax
+	}
+	return time.Duration(backoff)
+}
@@ -0,0 +1,108 @@
+// utils/oauth_client_utils.go
+package utils
+
+import (
+	"crypto/rand"
+	"crypto/subtle"
+	"encoding/base64"
+	"encoding/hex"
+	"errors"
+	"strings"
+	"time"
+
+	"github.com/google/uuid"
+	"github.com/pramek008/go-jwt-project/database"
+	"github.com/pramek008/go-jwt-project/models"
+	"gorm.io/gorm"
+)
+
+var ErrInvalidOAuthClient = errors.New("invalid client credentials")
+
+// CreateOAuthClient registers a client and returns its secret, which is only
+// available at this point. Public clients get no secret and an empty string
+// is returned.
+func CreateOAuthClient(name, clientType string, redirectURIs, postLogoutRedirectURIs, scopes, grantTypes []string, introspection bool) (string, *models.OAuthClient, error) {
+	idBuffer := make([]byte, 16)
+	if _, err := rand.Read(idBuffer); err != nil {
+		return "", nil, err
+	}
+
+	client := models.OAuthClient{
+		ClientID:               hex.EncodeToString(idBuffer),
+		Name:                   name,
+		ClientType:             clientType,
+		RedirectURIs:           strings.Join(redirectURIs, " "),
+		PostLogoutRedirectURIs: strings.Join(postLogoutRedirectURIs, " "),
+		Scopes:                 strings.Join(scopes, " "),
+		GrantTypes:             strings.Join(grantTypes, " "),
+		Introspection:          introspection,
+	}
+
+	var secret string
+	if !client.IsPublic() {
+		secretBuffer := make([]byte, 32)
+		if _, err := rand.Read(secretBuffer); err != nil {
+			return "", nil, err
+		}
+		secret = base64.RawURLEncoding.EncodeToString(secretBuffer)
+		client.SecretHash = hashToken(secret)
+	}
+
+	if err := database.DB.Db.Create(&client).Error; err != nil {
+		return "", nil, err
+	}
+
+	return secret, &client, nil
+}
+
+// AuthenticateOAuthClient checks client credentials. Unknown clients,
+// revoked clients and wrong secrets all return ErrInvalidOAuthClient.
+func AuthenticateOAuthClient(clientID, secret string) (*models.OAuthClient, error) {
+	var client models.OAuthClient
+	if err := database.DB.Db.Where("client_id = ? AND revoked_at IS NULL", clientID).First(&client).Error; err != nil {
+		return nil, ErrInvalidOAuthClient
+	}
+
+	if client.IsPublic() || subtle.ConstantTimeCompare([]byte(client.SecretHash), []byte(hashToken(secret))) != 1 {
+		return nil, ErrInvalidOAuthClient
+	}
+
+	return &client, nil
+}
+
+// RevokeOAuthClient disables a client and logs out everything it holds: the
+// sessions users approved for it, its client_credentials tokens and any
+// authorization code not exchanged yet.
+func RevokeOAuthClient(id uuid.UUID) error {
+	return database.DB.Db.Transaction(func(tx *gorm.DB) error {
+		var client models.OAuthClient
+		if err := tx.Where("id = ? AND revoked_at IS NULL", id).First(&client).Error; err != nil {
+			return ErrInvalidOAuthClient
+		}
+
+		now := time.Now()
+		if err := tx.Model(&client).Update("revoked_at", now).Error; err != nil {
+			return err
+		}
+
+		var sessionIDs []uuid.UUID
+		if err := tx.Model(&models.Session{}).
+			Where("client_id = ? AND revoked_at IS NULL", client.ClientID).
+			Pluck("id", &sessionIDs).Error; err != nil {
+			return err
+		}
+		for _, sessionID := range sessionIDs {
+			if err := revokeSession(tx, sessionID); err != nil {
+				return err
+			}
+		}
+
+		if err := tx.Model(&models.OAuthClientToken{}).
+			Where("client_id = ? AND revoked_at IS NULL", client.ClientID).
+			Update("revoked_at", now).Error; err != nil {
+			return err
+		}
+
+		return tx.Where("client_id = ? AND used_at IS NULL", client.ClientID).Delete(&models.OAuthAuthorizationCode{}).Error
+	})
+}
@@ -0,0 +1,341 @@
+// utils/oauth_server_utils.go
+package utils
+
+import (
+	"crypto/rand"
+	"crypto/sha256"
+	"crypto/subtle"
+	"encoding/base64"
+	"errors"
+	"regexp"
+	"strings"
+	"time"
+
+	"github.com/golang-jwt/jwt/v5"
+	"github.com/google/uuid"
+	"github.com/pramek008/go-jwt-project/database"
+	"github.com/pramek008/go-jwt-project/models"
+	"gorm.io/gorm"
+	"gorm.io/gorm/clause"
+)
+
+const (
+	authorizationCodeExpiryDuration = time.Minute
+	clientTokenExpiryDuration       = time.Hour
+
+	// CodeChallengeMethodS256 is the only PKCE method accepted, OAuth 2.1
+	// drops "plain".
+	CodeChallengeMethodS256 = "S256"
+)
+
+var (
+	ErrInvalidGrant = errors.New("invalid, expired or already used authorization grant")
+	ErrInvalidScope = errors.New("requested scope is not allowed for this client")
+
+	errCodeReplayed = errors.New("authorization code was already used")
+)
+
+// ClientCredentialsScopes are the scopes a client may hold for itself. Tokens
+// from the client_credentials grant have no user, so they are limited to
+// routes that do not act as one.
+var ClientCredentialsScopes = []string{ScopePostsRead}
+
+// PKCE verifiers are 43 to 128 unreserved characters (RFC 7636 section 4.1).
+var codeVerifierPattern = regexp.MustCompile(`^[A-Za-z0-9._~-]{43,128}$`)
+
+// FindOAuthClient returns an active client by its public client_id.
+func FindOAuthClient(clientID string) (*models.OAuthClient, error) {
+	var client models.OAuthClient
+	if err := database.DB.Db.Where("client_id = ? AND revoked_at IS NULL", clientID).First(&client).Error; err != nil {
+		return nil, ErrInvalidOAuthClient
+	}
+	return &client, nil
+}
+
+// ValidateClientScope checks every requested scope against the client and
+// returns them normalised, without duplicates.
+func ValidateClientScope(client *models.OAuthClient, scope string) (string, error) {
+	requested := uniqueScopes(ParseScopes(scope))
+	if len(requested) == 0 {
+		return "", ErrInvalidScope
+	}
+
+	for _, s := range requested {
+		if !client.HasScope(s) {
+			return "", ErrInvalidScope
+		}
+	}
+	return strings.Join(requested, " "), nil
+}
+
+// HasRememberedGrant reports whether the user already consented to every
+// requested scope for this client.
+func HasRememberedGrant(userID uuid.UUID, clientID, scope string) bool {
+	var grant models.OAuthGrant
+	if err := database.DB.Db.Where("user_id = ? AND client_id = ?", userID, clientID).First(&grant).Error; err != nil {
+		return false
+	}
+
+	granted := ParseScopes(grant.Scope)
+	for _, s := range ParseScopes(scope) {
+		if !containsScope(granted, s) {
+			return false
+		}
+	}
+	return true
+}
+
+// RememberGrant stores the consent, adding to scopes granted earlier.
+func RememberGrant(userID uuid.UUID, clientID, scope string) error {
+	return database.DB.Db.Transaction(func(tx *gorm.DB) error {
+		var grant models.OAuthGrant
+		err := tx.Clauses(clause.Locking{Strength: "UPDATE"}).
+			Where("user_id = ? AND client_id = ?", userID, clientID).
+			First(&grant).Error
+		if errors.Is(err, gorm.ErrRecordNotFound) {
+			return tx.Create(&models.OAuthGrant{UserID: userID, ClientID: clientID, Scope: scope}).Error
+		} else if err != nil {
+			return err
+		}
+
+		merged := uniqueScopes(append(ParseScopes(grant.Scope), ParseScopes(scope)...))
+		return tx.Model(&grant).Update("scope", strings.Join(merged, " ")).Error
+	})
+}
+
+// RevokeGrant forgets the consent and logs out every session the client
+// holds for the user.
+func RevokeGrant(userID uuid.UUID, clientID string) error {
+	return database.DB.Db.Transaction(func(tx *gorm.DB) error {
+		if err := tx.Where("user_id = ? AND client_id = ?", userID, clientID).Delete(&models.OAuthGrant{}).Error; err != nil {
+			return err
+		}
+
+		var sessionIDs []uuid.UUID
+		if err := tx.Model(&models.Session{}).
+			Where("user_id = ? AND client_id = ? AND revoked_at IS NULL", userID, clientID).
+			Pluck("id", &sessionIDs).Error; err != nil {
+			return err
+		}
+		for _, sessionID := range sessionIDs {
+			if err := revokeSession(tx, sessionID); err != nil {
+				return err
+			}
+		}
+		return nil
+	})
+}
+
+// CreateAuthorizationCode stores a code for the token endpoint and returns
+// it. The code is bound to the client, redirect URI and PKCE challenge set on
+// authorizationCode.
+func CreateAuthorizationCode(client *models.OAuthClient, authorizationCode models.OAuthAuthorizationCode) (string, error) {
+	buffer := make([]byte, 32)
+	if _, err := rand.Read(buffer); err != nil {
+		return "", err
+	}
+	code := base64.RawURLEncoding.EncodeToString(buffer)
+
+	authorizationCode.CodeHash = hashToken(code)
+	authorizationCode.ClientID = client.ClientID
+	authorizationCode.ExpiresAt = time.Now().Add(authorizationCodeExpiryDuration)
+	if err := database.DB.Db.Create(&authorizationCode).Error; err != nil {
+		return "", err
+	}
+
+	return code, nil
+}
+
+// ExchangeAuthorizationCode redeems a code at the token endpoint. The tokens
+// get a session of their own named after the client, so the user can see and
+// revoke it like any other device. Redeeming a code twice revokes the
+// tokens issued the first time (RFC 6749 section 4.1.2). When the openid
+// scope was granted an id_token from issuer is included.
+func ExchangeAuthorizationCode(client *models.OAuthClient, code, redirectURI, codeVerifier, issuer string) (*TokenPair, error) {
+	var (
+		authorizationCode models.OAuthAuthorizationCode
+		session           models.Session
+		replayed          bool
+	)
+
+	err := database.DB.Db.Transaction(func(tx *gorm.DB) error {
+		if err := tx.Clauses(clause.Locking{Strength: "UPDATE"}).
+			Where("code_hash = ?", hashToken(code)).
+			First(&authorizationCode).Error; err != nil {
+			return ErrInvalidGrant
+		}
+
+		if err := checkAuthorizationCode(&authorizationCode, client.ClientID, redirectURI, codeVerifier, time.Now()); errors.Is(err, errCodeReplayed) {
+			replayed = true
+			if authorizationCode.SessionID != nil {
+				return revokeSession(tx, *authorizationCode.SessionID)
+			}
+			return nil
+		} else if err != nil {
+			return err
+		}
+
+		if !IsUserActive(authorizationCode.UserID) {
+			return ErrInvalidGrant
+		}
+
+		now := time.Now()
+		session = models.Session{
+			ID:         uuid.New(),
+			UserID:     authorizationCode.UserID,
+			DeviceName: client.Name,
+			LastSeenAt: now,
+			CreatedAt:  now,
+			ClientID:   client.ClientID,
+			Scope:      authorizationCode.Scope,
+		}
+		if err := tx.Create(&session).Error; err != nil {
+			return err
+		}
+
+		return tx.Model(&authorizationCode).Updates(map[string]interface{}{
+			"used_at":    now,
+			"session_id": session.ID,
+		}).Error
+	})
+	if err != nil {
+		return nil, err
+	}
+	if replayed {
+		return nil, ErrInvalidGrant
+	}
+
+	tokens, err := GenerateDelegatedTokenPair(session.UserID, session.ID, client.ClientID, session.Scope, client.HasGrantType(models.GrantTypeRefreshToken))
+	if err != nil {
+		return nil, err
+	}
+
+	if containsScope(ParseScopes(session.Scope), ScopeOpenID) {
+		if tokens.IDToken, err = GenerateIDToken(issuer, &authorizationCode); err != nil {
+			return nil, err
+		}
+	}
+
+	return tokens, nil
+}
+
+// checkAuthorizationCode decides whether the client may redeem the code
+// now. A code that was already used returns errCodeReplayed, so the caller
+// can revoke what the first exchange issued.
+func checkAuthorizationCode(authorizationCode *models.OAuthAuthorizationCode, clientID, redirectURI, codeVerifier string, now time.Time) error {
+	if authorizationCode.UsedAt != nil {
+		return errCodeReplayed
+	}
+
+	if now.After(authorizationCode.ExpiresAt) ||
+		authorizationCode.ClientID != clientID ||
+		authorizationCode.RedirectURI != redirectURI ||
+		!VerifyCodeChallenge(codeVerifier, authorizationCode.CodeChallenge) {
+		return ErrInvalidGrant
+	}
+	return nil
+}
+
+// VerifyCodeChallenge checks a PKCE verifier against an S256 challenge.
+func VerifyCodeChallenge(codeVerifier, codeChallenge string) bool {
+	if !codeVerifierPattern.MatchString(codeVerifier) {
+		return false
+	}
+
+	sum := sha256.Sum256([]byte(codeVerifier))
+	expected := base64.RawURLEncoding.EncodeToString(sum[:])
+	return subtle.ConstantTimeCompare([]byte(expected), []byte(codeChallenge)) == 1
+}
+
+// IsValidCodeChallenge checks the shape of an S256 challenge, the base64url
+// encoding of a SHA-256 hash.
+func IsValidCodeChallenge(codeChallenge string) bool {
+	decoded, err := base64.RawURLEncoding.DecodeString(codeChallenge)
+	return err == nil && len(decoded) == sha256.Size
+}
+
+// IssueClientCredentialsToken signs an access token that belongs to the
+// client itself. Its subject is the client_id and it has no user.
+func IssueClientCredentialsToken(client *models.OAuthClient, scope string) (string, int64, error) {
+	for _, s := range ParseScopes(scope) {
+		if !containsScope(ClientCredentialsScopes, s) {
+			return "", 0, ErrInvalidScope
+		}
+	}
+
+	now := time.Now()
+	expiresAt := now.Add(clientTokenExpiryDuration)
+	jti := uuid.New()
+
+	claims := &Claims{
+		ClientID: client.ClientID,
+		Scope:    scope,
+		RegisteredClaims: jwt.RegisteredClaims{
+			ID:        jti.String(),
+			Issuer:    jwtIssuer(),
+			Subject:   client.ClientID,
+			Audience:  jwtAudience(),
+			IssuedAt:  jwt.NewNumericDate(now),
+			NotBefore: jwt.NewNumericDate(now),
+			ExpiresAt: jwt.NewNumericDate(expiresAt),
+		},
+	}
+
+	tokenString, err := signToken(claims)
+	if err != nil {
+		return "", 0, err
+	}
+
+	clientToken := models.OAuthClientToken{
+		ID:        jti,
+		ClientID:  client.ClientID,
+		Scope:     scope,
+		ExpiresAt: expiresAt,
+	}
+	if err := database.DB.Db.Create(&clientToken).Error; err != nil {
+		return "", 0, err
+	}
+
+	return tokenString, int64(clientTokenExpiryDuration.Seconds()), nil
+}
+
+// FindActiveClientToken returns the record of a client_credentials token that
+// is neither expired nor revoked, and whose client is still active.
+func FindActiveClientToken(claims *Claims) (*models.OAuthClientToken, error) {
+	var clientToken models.OAuthClientToken
+	err := database.DB.Db.Where("id = ? AND client_id = ? AND revoked_at IS NULL AND expires_at > ?", claims.ID, claims.ClientID, time.Now()).
+		First(&clientToken).Error
+	if err != nil {
+		return nil, err
+	}
+
+	if _, err := FindOAuthClient(clientToken.ClientID); err != nil {
+		return nil, err
+	}
+	return &clientToken, nil
+}
+
+// IsClientToken tells client_credentials tokens, which have no user, apart
+// from tokens issued for a user.
+func IsClientToken(claims *Claims) bool {
+	return claims.UserID == uuid.Nil && claims.ClientID != ""
+}
+
+func uniqueScopes(scopes []string) []string {
+	unique := make([]string, 0, len(scopes))
+	for _, scope := range scopes {
+		if !containsScope(unique, scope) {
+			unique = append(unique, scope)
+		}
+	}
+	return unique
+}
+
+func containsScope(scopes []string, scope string) bool {
+	for _, s := range scopes {
+		if s == scope {
+			return true
+		}
+	}
+	return false
+}
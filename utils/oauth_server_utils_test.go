@@ -0,0 +1,129 @@
+package utils
+
+import (
+	"errors"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/google/uuid"
+	"github.com/pramek008/go-jwt-project/models"
+)
+
+// Verifier and challenge from RFC 7636 appendix B
+const (
+	testCodeVerifier  = "dBjftJeZ4CVP-mB92K27uhbUJU1p1r_wW1gFWFOEjXk"
+	testCodeChallenge = "E9Melhoa2OwvFrEMTJguCHaoeK1t8URWbuGJSstw-cM"
+)
+
+func TestVerifyCodeChallenge(t *testing.T) {
+	if !VerifyCodeChallenge(testCodeVerifier, testCodeChallenge) {
+		t.Fatal("RFC 7636 example verifier was rejected")
+	}
+
+	for name, verifier := range map[string]string{
+		"other verifier":  strings.Repeat("a", 43),
+		"too short":       testCodeVerifier[:42],
+		"too long":        strings.Repeat(testCodeVerifier, 3),
+		"invalid char":    testCodeVerifier[:42] + "+",
+		"plain challenge": testCodeChallenge,
+		"empty":           "",
+	} {
+		if VerifyCodeChallenge(verifier, testCodeChallenge) {
+			t.Errorf("%s: verifier %q was accepted", name, verifier)
+		}
+	}
+
+	// "plain" would accept the challenge itself as the verifier
+	if VerifyCodeChallenge(testCodeVerifier, testCodeVerifier) {
+		t.Error("verifier was accepted as its own challenge")
+	}
+}
+
+func TestIsValidCodeChallenge(t *testing.T) {
+	if !IsValidCodeChallenge(testCodeChallenge) {
+		t.Error("S256 challenge was rejected")
+	}
+	for _, challenge := range []string{"", testCodeChallenge[:42], testCodeChallenge + "=", "E9Melhoa2OwvFrEMTJguCHaoeK1t8URWbuGJSstw+cM"} {
+		if IsValidCodeChallenge(challenge) {
+			t.Errorf("IsValidCodeChallenge(%q) = true", challenge)
+		}
+	}
+}
+
+func TestOAuthClientRedirectURIMatchesExactly(t *testing.T) {
+	client := models.OAuthClient{RedirectURIs: "https://app.example.com/callback https://app.example.com/other"}
+
+	if !client.HasRedirectURI("https://app.example.com/callback") {
+		t.Fatal("registered redirect URI was rejected")
+	}
+	for _, uri := range []string{
+		"https://app.example.com/callback/",
+		"https://app.example.com/callback?next=/admin",
+		"https://app.example.com/Callback",
+		"http://app.example.com/callback",
+		"https://app.example.com.evil.test/callback",
+		"https://app.example.com",
+		"https://app.example.com/callback https://app.example.com/other",
+		"",
+	} {
+		if client.HasRedirectURI(uri) {
+			t.Errorf("HasRedirectURI(%q) = true", uri)
+		}
+	}
+}
+
+func TestCheckAuthorizationCode(t *testing.T) {
+	now := time.Now()
+	newCode := func() *models.OAuthAuthorizationCode {
+		return &models.OAuthAuthorizationCode{
+			ClientID:      "client",
+			RedirectURI:   "https://app.example.com/callback",
+			CodeChallenge: testCodeChallenge,
+			ExpiresAt:     now.Add(time.Minute),
+		}
+	}
+
+	if err := checkAuthorizationCode(newCode(), "client", "https://app.example.com/callback", testCodeVerifier, now); err != nil {
+		t.Fatalf("valid code: %v", err)
+	}
+
+	for _, test := range []struct {
+		name         string
+		clientID     string
+		redirectURI  string
+		codeVerifier string
+		at           time.Time
+	}{
+		{"other client", "other", "https://app.example.com/callback", testCodeVerifier, now},
+		{"other redirect URI", "client", "https://app.example.com/other", testCodeVerifier, now},
+		{"wrong verifier", "client", "https://app.example.com/callback", strings.Repeat("a", 43), now},
+		{"expired", "client", "https://app.example.com/callback", testCodeVerifier, now.Add(2 * time.Minute)},
+	} {
+		err := checkAuthorizationCode(newCode(), test.clientID, test.redirectURI, test.codeVerifier, test.at)
+		if !errors.Is(err, ErrInvalidGrant) {
+			t.Errorf("%s: err = %v, want ErrInvalidGrant", test.name, err)
+		}
+	}
+}
+
+func TestCheckAuthorizationCodeReplay(t *testing.T) {
+	now := time.Now()
+	sessionID := uuid.New()
+	code := &models.OAuthAuthorizationCode{
+		ClientID:      "client",
+		RedirectURI:   "https://app.example.com/callback",
+		CodeChallenge: testCodeChallenge,
+		ExpiresAt:     now.Add(time.Minute),
+		UsedAt:        &now,
+		SessionID:     &sessionID,
+	}
+
+	// Even a request that would otherwise be valid is a replay
+	if err := checkAuthorizationCode(code, "client", "https://app.example.com/callback", testCodeVerifier, now); !errors.Is(err, errCodeReplayed) {
+		t.Fatalf("err = %v, want errCodeReplayed", err)
+	}
+	if err := checkAuthorizationCode(code, "other", "https://evil.test/", "", now.Add(time.Hour)); !errors.Is(err, errCodeReplayed) {
+		t.Fatalf("err = %v, want errCodeReplayed for any replay", err)
+	}
+}
@@ -0,0 +1,183 @@
+// utils/oidc_utils.go
+package utils
+
+import (
+	"errors"
+	"time"
+
+	"github.com/golang-jwt/jwt/v5"
+	"github.com/google/uuid"
+	"github.com/pramek008/go-jwt-project/database"
+	"github.com/pramek008/go-jwt-project/models"
+	"gorm.io/gorm"
+)
+
+const (
+	idTokenExpiryDuration = 15 * time.Minute
+
+	// idTokenHintMaxAge bounds how long an id_token can be used for logout
+	// after it was issued, so a leaked one does not stay useful forever.
+	idTokenHintMaxAge = 24 * time.Hour
+)
+
+var ErrInvalidIDTokenHint = errors.New("invalid id_token_hint")
+
+// IDTokenClaims are the claims of an OpenID Connect id_token. It has no jti
+// or user_id, so it is never accepted as an access token. Sid is the session
+// the user approved the client from.
+type IDTokenClaims struct {
+	Nonce    string `json:"nonce,omitempty"`
+	AuthTime int64  `json:"auth_time"`
+	Sid      string `json:"sid,omitempty"`
+	Azp      string `json:"azp,omitempty"`
+	jwt.RegisteredClaims
+}
+
+// LoadOIDCIssuer checks JWT_ISSUER, the issuer identifier used in discovery
+// and id_tokens. The OpenID Connect endpoints are always served, so it is
+// required: relying parties trust tokens by their issuer and it must not come
+// from request headers.
+func LoadOIDCIssuer() error {
+	issuer := jwtIssuer()
+	if issuer == "" {
+		return errors.New("JWT_ISSUER is not set")
+	}
+	if !isAbsoluteURL(issuer) {
+		return errors.New("JWT_ISSUER must be an absolute http or https URL")
+	}
+	return nil
+}
+
+// OIDCIssuer is the issuer identifier checked by LoadOIDCIssuer.
+func OIDCIssuer() string {
+	return jwtIssuer()
+}
+
+// IDTokenSigningAlg is the algorithm id_tokens are signed with. Relying
+// parties verify them against the JWKS, so the shared HS256 secret is of no
+// use to them and JWT_KEYS_DIR should be configured.
+func IDTokenSigningAlg() string {
+	key, err := activeSigningKey()
+	if err != nil {
+		return ""
+	}
+	return key.Method.Alg()
+}
+
+// GenerateIDToken signs the id_token for an exchanged authorization code.
+func GenerateIDToken(issuer string, authorizationCode *models.OAuthAuthorizationCode) (string, error) {
+	now := time.Now()
+	claims := &IDTokenClaims{
+		Nonce:    authorizationCode.Nonce,
+		AuthTime: authorizationCode.AuthTime.Unix(),
+		Azp:      authorizationCode.ClientID,
+		RegisteredClaims: jwt.RegisteredClaims{
+			Issuer:    issuer,
+			Subject:   authorizationCode.UserID.String(),
+			Audience:  jwt.ClaimStrings{authorizationCode.ClientID},
+			IssuedAt:  jwt.NewNumericDate(now),
+			ExpiresAt: jwt.NewNumericDate(now.Add(idTokenExpiryDuration)),
+		},
+	}
+	if authorizationCode.LoginSessionID != nil {
+		claims.Sid = authorizationCode.LoginSessionID.String()
+	}
+
+	return signToken(claims)
+}
+
+// ParseIDTokenHint checks an id_token we issued, as sent back with
+// RP-initiated logout. Expired tokens are accepted up to idTokenHintMaxAge
+// after they were issued, a user who stayed logged in longer than the
+// id_token lifetime must still be able to log out.
+func ParseIDTokenHint(rawToken, issuer string) (*IDTokenClaims, error) {
+	claims := &IDTokenClaims{}
+	token, err := jwt.ParseWithClaims(rawToken, claims, verificationKey, jwt.WithoutClaimsValidation())
+	if err != nil || !token.Valid {
+		return nil, ErrInvalidIDTokenHint
+	}
+
+	// Access tokens carry a jti and a user_id, an id_token has neither
+	if claims.ID != "" || claims.Issuer != issuer || len(claims.Audience) != 1 {
+		return nil, ErrInvalidIDTokenHint
+	}
+	if _, err := uuid.Parse(claims.Subject); err != nil {
+		return nil, ErrInvalidIDTokenHint
+	}
+
+	now := time.Now()
+	if claims.IssuedAt == nil || claims.IssuedAt.After(now.Add(jwtClockSkew())) || now.Sub(claims.IssuedAt.Time) > idTokenHintMaxAge {
+		return nil, ErrInvalidIDTokenHint
+	}
+
+	return claims, nil
+}
+
+// IsLoginSessionToken reports whether tokenString is an access token of the
+// user's own login session, not one issued to an OAuth client. Only such a
+// token proves a logout request comes from the user rather than from a page
+// that got hold of an id_token.
+func IsLoginSessionToken(tokenString string, userID, sessionID uuid.UUID) bool {
+	claims, err := ExtractClaimsFromToken(tokenString)
+	if err != nil || claims.UserID != userID {
+		return false
+	}
+
+	var storedToken models.Token
+	if err := database.DB.Db.Where("jti = ? AND user_id = ? AND session_id = ?", claims.ID, userID, sessionID).First(&storedToken).Error; err != nil {
+		return false
+	}
+	if storedToken.ClientID != "" {
+		return false
+	}
+
+	_, err = FindActiveSession(userID, sessionID)
+	return err == nil
+}
+
+// UserInfoClaims maps the user to the standard claims the granted scopes
+// allow. Accounts only exist once their email has been confirmed with an
+// OTP, so email_verified is always true.
+func UserInfoClaims(user models.User, scopes []string) map[string]interface{} {
+	claims := map[string]interface{}{
+		"sub": user.ID.String(),
+	}
+
+	if HasScope(scopes, ScopeProfile) {
+		claims["preferred_username"] = user.Nickname
+		claims["nickname"] = user.Nickname
+		claims["updated_at"] = user.UpdatedAt.Unix()
+	}
+
+	if HasScope(scopes, ScopeEmail) {
+		claims["email"] = user.Email
+		claims["email_verified"] = true
+	}
+
+	return claims
+}
+
+// EndOIDCSession logs the user out for RP-initiated logout: every session
+// the client holds for the user and, when loginSessionID is given, the
+// session the id_token was issued from. Remembered consent is kept.
+func EndOIDCSession(userID uuid.UUID, loginSessionID *uuid.UUID, clientID string) error {
+	return database.DB.Db.Transaction(func(tx *gorm.DB) error {
+		query := tx.Model(&models.Session{}).Where("user_id = ? AND revoked_at IS NULL", userID)
+		if loginSessionID != nil {
+			query = query.Where("(client_id = ? OR id = ?)", clientID, *loginSessionID)
+		} else {
+			query = query.Where("client_id = ?", clientID)
+		}
+
+		var sessionIDs []uuid.UUID
+		if err := query.Pluck("id", &sessionIDs).Error; err != nil {
+			return err
+		}
+		for _, sessionID := range sessionIDs {
+			if err := revokeSession(tx, sessionID); err != nil {
+				return err
+			}
+		}
+		return nil
+	})
+}
@@ -0,0 +1,69 @@
+package utils
+
+import (
+	"errors"
+	"testing"
+	"time"
+
+	"github.com/golang-jwt/jwt/v5"
+	"github.com/google/uuid"
+)
+
+const testIssuer = "https://auth.example.com"
+
+func loadTestKeyRing(t *testing.T) {
+	t.Helper()
+	t.Setenv("JWT_SECRET", "test-secret-test-secret-test-secret")
+	t.Setenv("JWT_KEYS_DIR", "")
+	if err := LoadKeyRing(); err != nil {
+		t.Fatal(err)
+	}
+}
+
+func signTestIDToken(t *testing.T, issuedAt time.Time) string {
+	t.Helper()
+	token, err := signToken(&IDTokenClaims{
+		RegisteredClaims: jwt.RegisteredClaims{
+			Issuer:    testIssuer,
+			Subject:   uuid.NewString(),
+			Audience:  jwt.ClaimStrings{"client"},
+			IssuedAt:  jwt.NewNumericDate(issuedAt),
+			ExpiresAt: jwt.NewNumericDate(issuedAt.Add(idTokenExpiryDuration)),
+		},
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+	return token
+}
+
+func TestParseIDTokenHintAge(t *testing.T) {
+	loadTestKeyRing(t)
+	now := time.Now()
+
+	for name, issuedAt := range map[string]time.Time{
+		"fresh":   now,
+		"expired": now.Add(-2 * time.Hour),
+	} {
+		if _, err := ParseIDTokenHint(signTestIDToken(t, issuedAt), testIssuer); err != nil {
+			t.Errorf("%s hint was rejected: %v", name, err)
+		}
+	}
+
+	for name, issuedAt := range map[string]time.Time{
+		"too old":   now.Add(-idTokenHintMaxAge - time.Minute),
+		"in future": now.Add(time.Hour),
+	} {
+		if _, err := ParseIDTokenHint(signTestIDToken(t, issuedAt), testIssuer); !errors.Is(err, ErrInvalidIDTokenHint) {
+			t.Errorf("%s hint: err = %v, want ErrInvalidIDTokenHint", name, err)
+		}
+	}
+}
+
+func TestParseIDTokenHintRejectsOtherIssuer(t *testing.T) {
+	loadTestKeyRing(t)
+
+	if _, err := ParseIDTokenHint(signTestIDToken(t, time.Now()), "https://other.example.com"); !errors.Is(err, ErrInvalidIDTokenHint) {
+		t.Fatalf("err = %v, want ErrInvalidIDTokenHint", err)
+	}
+}
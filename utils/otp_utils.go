@@ -2,15 +2,34 @@
 package utils
 
 import (
+	"crypto/hmac"
 	"crypto/rand"
+	"crypto/sha256"
+	"crypto/subtle"
+	"encoding/hex"
+	"errors"
+	"os"
+	"strings"
 	"time"
 
 	"github.com/pramek008/go-jwt-project/database"
 	"github.com/pramek008/go-jwt-project/models"
+	"gorm.io/gorm"
+	"gorm.io/gorm/clause"
 )
 
 const otpExpiryDuration = 15 * time.Minute
 const otpResendCooldown = 5 * time.Minute
+const defaultOTPMaxAttempts = 5
+
+// otpMaxAttempts is the number of wrong guesses a code survives, set with
+// OTP_MAX_ATTEMPTS.
+func otpMaxAttempts() int {
+	if attempts := GetEnvInt("OTP_MAX_ATTEMPTS", defaultOTPMaxAttempts); attempts > 0 {
+		return attempts
+	}
+	return defaultOTPMaxAttempts
+}
 
 func GenerateOTP() string {
 	const otpChars = "1234567890"
@@ -28,23 +47,33 @@ func GenerateOTP() string {
 	return string(buffer)
 }
 
-func SaveOTP(email, otp string) error {
+// SaveOTP stores a hashed code for the email and purpose, replacing any
+// older code issued for the same purpose. Only the newest code is valid, so
+// requesting codes does not multiply the attempts a guesser gets.
+func SaveOTP(email, purpose, otp string) error {
 	otpRecord := models.OTP{
 		Email:     email,
-		Code:      otp,
+		Purpose:   purpose,
+		CodeHash:  hashOTP(email, purpose, otp),
 		ExpiresAt: time.Now().Add(otpExpiryDuration),
 	}
 
-	if err := database.DB.Db.Create(&otpRecord).Error; err != nil {
-		return err
-	}
-
-	return nil
+	return database.DB.Db.Transaction(func(tx *gorm.DB) error {
+		// Without the lock two concurrent requests could each delete the
+		// other's code before inserting their own, leaving both valid
+		if err := tx.Exec("SELECT pg_advisory_xact_lock(hashtext(?))", "otp:"+strings.ToLower(email)+":"+purpose).Error; err != nil {
+			return err
+		}
+		if err := deleteOTPs(tx, email, purpose); err != nil {
+			return err
+		}
+		return tx.Create(&otpRecord).Error
+	})
 }
 
-func CanResendOTP(email string) (bool, time.Duration, error) {
+func CanResendOTP(email, purpose string) (bool, time.Duration, error) {
 	var otpRecord models.OTP
-	err := database.DB.Db.Where("email = ?", email).Order("created_at desc").First(&otpRecord).Error
+	err := database.DB.Db.Where("email = ? AND purpose = ?", email, purpose).Order("created_at desc").First(&otpRecord).Error
 	if err != nil {
 		return true, 0, nil // No previous OTP, can resend
 	}
@@ -58,14 +87,66 @@ func CanResendOTP(email string) (bool, time.Duration, error) {
 	return true, 0, nil // Allowed to resend
 }
 
-func ValidateOTP(email, otp string) bool {
-	var otpRecord models.OTP
-	if err := database.DB.Db.Where("email = ? AND code = ? AND expires_at > ?", email, otp, time.Now()).First(&otpRecord).Error; err != nil {
+// ValidateOTP checks the latest code sent to the email for the purpose. Every
+// wrong guess is counted and the code is deleted once it runs out of
+// attempts, so a 6-digit code cannot be brute-forced within its lifetime.
+// Unknown emails, expired codes and wrong guesses all return false.
+func ValidateOTP(email, purpose, otp string) bool {
+	valid := false
+
+	err := database.DB.Db.Transaction(func(tx *gorm.DB) error {
+		var otpRecord models.OTP
+		if err := tx.Clauses(clause.Locking{Strength: "UPDATE"}).
+			Where("email = ? AND purpose = ? AND expires_at > ?", email, purpose, time.Now()).
+			Order("created_at desc").
+			First(&otpRecord).Error; err != nil {
+			return err
+		}
+
+		if otpRecord.Attempts < otpMaxAttempts() && subtle.ConstantTimeCompare([]byte(otpRecord.CodeHash), []byte(hashOTP(email, purpose, otp))) == 1 {
+			valid = true
+			// Delete the OTP record after successful validation
+			return tx.Delete(&otpRecord).Error
+		}
+
+		otpRecord.Attempts++
+		if otpRecord.Attempts >= otpMaxAttempts() {
+			return deleteOTPs(tx, email, purpose)
+		}
+		return tx.Model(&otpRecord).Update("attempts", otpRecord.Attempts).Error
+	})
+	if err != nil {
+		// A code whose deletion was rolled back must not count as used
 		return false
 	}
 
-	// Delete the OTP record after successful validation
-	database.DB.Db.Delete(&otpRecord)
+	return valid
+}
+
+func deleteOTPs(tx *gorm.DB, email, purpose string) error {
+	return tx.Where("LOWER(email) = LOWER(?) AND purpose = ?", email, purpose).Delete(&models.OTP{}).Error
+}
+
+var otpPepper []byte
+
+// LoadOTPPepper reads OTP_PEPPER, the key of the stored code hashes. It is
+// required: a plain hash of a 6-digit code is reversed by trying all million
+// values.
+func LoadOTPPepper() error {
+	pepper := os.Getenv("OTP_PEPPER")
+	if pepper == "" {
+		return errors.New("OTP_PEPPER is not set")
+	}
+
+	otpPepper = []byte(pepper)
+	return nil
+}
 
-	return true
+// hashOTP keys the hash with the pepper loaded by LoadOTPPepper. The email
+// and purpose are mixed in so a stored hash is only valid for the row it was
+// issued for.
+func hashOTP(email, purpose, otp string) string {
+	mac := hmac.New(sha256.New, otpPepper)
+	mac.Write([]byte(email + "\x00" + purpose + "\x00" + otp))
+	return hex.EncodeToString(mac.Sum(nil))
 }
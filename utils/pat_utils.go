@@ -0,0 +1,63 @@
+// utils/pat_utils.go
+package utils
+
+import (
+	"crypto/rand"
+	"encoding/base64"
+	"errors"
+	"strings"
+	"time"
+
+	"github.com/google/uuid"
+	"github.com/pramek008/go-jwt-project/database"
+	"github.com/pramek008/go-jwt-project/models"
+)
+
+// PersonalAccessTokenPrefix marks a bearer token as a personal access token
+// rather than a JWT.
+const PersonalAccessTokenPrefix = "pat_"
+
+const personalAccessTokenTouchInterval = time.Minute
+
+var ErrInvalidPersonalAccessToken = errors.New("invalid, expired or revoked personal access token")
+
+// CreatePersonalAccessToken stores a new token and returns its secret, which
+// is only available at this point.
+func CreatePersonalAccessToken(userID uuid.UUID, name string, scopes []string, expiresAt time.Time) (string, *models.PersonalAccessToken, error) {
+	buffer := make([]byte, 32)
+	if _, err := rand.Read(buffer); err != nil {
+		return "", nil, err
+	}
+	rawToken := PersonalAccessTokenPrefix + base64.RawURLEncoding.EncodeToString(buffer)
+
+	token := models.PersonalAccessToken{
+		ID:        uuid.New(),
+		UserID:    userID,
+		Name:      name,
+		Prefix:    rawToken[:len(PersonalAccessTokenPrefix)+8],
+		TokenHash: hashToken(rawToken),
+		Scopes:    strings.Join(scopes, " "),
+		ExpiresAt: expiresAt,
+	}
+	if err := database.DB.Db.Create(&token).Error; err != nil {
+		return "", nil, err
+	}
+
+	return rawToken, &token, nil
+}
+
+// AuthenticatePersonalAccessToken looks the token up by its hash and records
+// when it was last used.
+func AuthenticatePersonalAccessToken(rawToken string) (*models.PersonalAccessToken, error) {
+	var token models.PersonalAccessToken
+	err := database.DB.Db.Where("token_hash = ? AND revoked_at IS NULL AND expires_at > ?", hashToken(rawToken), time.Now()).First(&token).Error
+	if err != nil {
+		return nil, ErrInvalidPersonalAccessToken
+	}
+
+	if token.LastUsedAt == nil || time.Since(*token.LastUsedAt) >= personalAccessTokenTouchInterval {
+		database.DB.Db.Model(&token).Update("last_used_at", time.Now())
+	}
+
+	return &token, nil
+}
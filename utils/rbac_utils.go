@@ -0,0 +1,42 @@
+// utils/rbac_utils.go
+package utils
+
+import (
+	"github.com/gin-gonic/gin"
+	"github.com/google/uuid"
+	"github.com/pramek008/go-jwt-project/database"
+)
+
+// UserRoleNames returns the names of the roles assigned to the user. They are
+// embedded in access tokens as the roles claim.
+func UserRoleNames(userID uuid.UUID) ([]string, error) {
+	var roleNames []string
+	err := database.DB.Db.Table("roles").
+		Joins("JOIN user_roles ON user_roles.role_id = roles.id").
+		Where("user_roles.user_id = ?", userID).
+		Order("roles.name").
+		Pluck("roles.name", &roleNames).Error
+	return roleNames, err
+}
+
+// RolesHavePermission reports whether any of the roles grants the permission.
+func RolesHavePermission(roles []string, permission string) bool {
+	if len(roles) == 0 {
+		return false
+	}
+
+	var count int64
+	err := database.DB.Db.Table("roles").
+		Joins("JOIN role_permissions ON role_permissions.role_id = roles.id").
+		Joins("JOIN permissions ON permissions.id = role_permissions.permission_id").
+		Where("roles.name IN ? AND permissions.name = ?", roles, permission).
+		Count(&count).Error
+	return err == nil && count > 0
+}
+
+// ContextHasPermission checks the roles the auth middleware put on the request.
+func ContextHasPermission(c *gin.Context, permission string) bool {
+	roles, _ := c.Get("roles")
+	roleNames, _ := roles.([]string)
+	return RolesHavePermission(roleNames, permission)
+}
@@ -0,0 +1,200 @@
+// utils/refresh_token_utils.go
+package utils
+
+import (
+	"crypto/rand"
+	"crypto/sha256"
+	"encoding/base64"
+	"encoding/hex"
+	"errors"
+	"time"
+
+	"github.com/google/uuid"
+	"github.com/pramek008/go-jwt-project/database"
+	"github.com/pramek008/go-jwt-project/models"
+	"gorm.io/gorm"
+	"gorm.io/gorm/clause"
+)
+
+const refreshTokenExpiryDuration = 30 * 24 * time.Hour
+
+var (
+	ErrInvalidRefreshToken = errors.New("invalid or expired refresh token")
+	ErrRefreshTokenReused  = errors.New("refresh token reuse detected")
+)
+
+// TokenPair is what clients receive after logging in or refreshing.
+type TokenPair struct {
+	AccessToken  string
+	RefreshToken string
+	ExpiresIn    int64 // access token lifetime in seconds
+	Scope        string
+	IDToken      string // only for OAuth clients granted the openid scope
+	UserID       uuid.UUID
+	SessionID    uuid.UUID
+}
+
+// GenerateTokenPair issues an access token and a refresh token for a newly
+// created session. The refresh token starts a new refresh token family.
+func GenerateTokenPair(userID, sessionID uuid.UUID) (*TokenPair, error) {
+	accessToken, err := GenerateToken(userID, sessionID)
+	if err != nil {
+		return nil, err
+	}
+
+	refreshToken, _, err := createRefreshToken(database.DB.Db, userID, sessionID, uuid.New())
+	if err != nil {
+		return nil, err
+	}
+
+	return &TokenPair{
+		AccessToken:  accessToken,
+		RefreshToken: refreshToken,
+		ExpiresIn:    int64(accessTokenExpiryDuration.Seconds()),
+		UserID:       userID,
+		SessionID:    sessionID,
+	}, nil
+}
+
+// GenerateDelegatedTokenPair issues the tokens for a session an OAuth client
+// obtained on the user's behalf. The refresh token is left out for clients
+// that may not use the refresh_token grant.
+func GenerateDelegatedTokenPair(userID, sessionID uuid.UUID, clientID, scope string, withRefreshToken bool) (*TokenPair, error) {
+	accessToken, err := GenerateDelegatedToken(userID, sessionID, clientID, scope)
+	if err != nil {
+		return nil, err
+	}
+
+	pair := &TokenPair{
+		AccessToken: accessToken,
+		ExpiresIn:   int64(accessTokenExpiryDuration.Seconds()),
+		Scope:       scope,
+		UserID:      userID,
+		SessionID:   sessionID,
+	}
+
+	if withRefreshToken {
+		if pair.RefreshToken, _, err = createRefreshToken(database.DB.Db, userID, sessionID, uuid.New()); err != nil {
+			return nil, err
+		}
+	}
+
+	return pair, nil
+}
+
+// RotateRefreshToken exchanges a refresh token for a new token pair. The
+// presented token is revoked and replaced by a new one in the same family.
+// Presenting a token that was already rotated means it has leaked, so the
+// whole family and its session are revoked and ErrRefreshTokenReused is
+// returned. clientID is the OAuth client presenting the token, empty for our
+// own apps; a token only works for the client it was issued to.
+func RotateRefreshToken(rawToken, clientID string) (*TokenPair, error) {
+	var (
+		userID      uuid.UUID
+		sessionID   uuid.UUID
+		scope       string
+		newRawToken string
+		reused      bool
+	)
+
+	err := database.DB.Db.Transaction(func(tx *gorm.DB) error {
+		var stored models.RefreshToken
+		if err := tx.Clauses(clause.Locking{Strength: "UPDATE"}).
+			Where("token_hash = ?", hashToken(rawToken)).
+			First(&stored).Error; err != nil {
+			return ErrInvalidRefreshToken
+		}
+
+		if stored.ReplacedBy != nil {
+			reused = true
+			if err := revokeRefreshTokenFamily(tx, stored.FamilyID); err != nil {
+				return err
+			}
+			if stored.SessionID != nil {
+				return revokeSession(tx, *stored.SessionID)
+			}
+			return nil
+		}
+
+		if stored.RevokedAt != nil || stored.SessionID == nil || time.Now().After(stored.ExpiresAt) {
+			return ErrInvalidRefreshToken
+		}
+
+		var session models.Session
+		if err := tx.First(&session, "id = ?", *stored.SessionID).Error; err != nil || session.ClientID != clientID {
+			return ErrInvalidRefreshToken
+		}
+		scope = session.Scope
+
+		raw, replacement, err := createRefreshToken(tx, stored.UserID, *stored.SessionID, stored.FamilyID)
+		if err != nil {
+			return err
+		}
+
+		now := time.Now()
+		if err := tx.Model(&stored).Updates(map[string]interface{}{
+			"revoked_at":  now,
+			"replaced_by": replacement.ID,
+		}).Error; err != nil {
+			return err
+		}
+
+		userID = stored.UserID
+		sessionID = *stored.SessionID
+		newRawToken = raw
+		return nil
+	})
+	if err != nil {
+		return nil, err
+	}
+	if reused {
+		return nil, ErrRefreshTokenReused
+	}
+
+	accessToken, err := generateAccessToken(userID, sessionID, clientID, scope)
+	if err != nil {
+		return nil, err
+	}
+
+	return &TokenPair{
+		AccessToken:  accessToken,
+		RefreshToken: newRawToken,
+		ExpiresIn:    int64(accessTokenExpiryDuration.Seconds()),
+		Scope:        scope,
+		UserID:       userID,
+		SessionID:    sessionID,
+	}, nil
+}
+
+func createRefreshToken(db *gorm.DB, userID, sessionID, familyID uuid.UUID) (string, *models.RefreshToken, error) {
+	buffer := make([]byte, 32)
+	if _, err := rand.Read(buffer); err != nil {
+		return "", nil, err
+	}
+	rawToken := base64.RawURLEncoding.EncodeToString(buffer)
+
+	refreshToken := models.RefreshToken{
+		ID:        uuid.New(),
+		TokenHash: hashToken(rawToken),
+		FamilyID:  familyID,
+		UserID:    userID,
+		SessionID: &sessionID,
+		ExpiresAt: time.Now().Add(refreshTokenExpiryDuration),
+	}
+	if err := db.Create(&refreshToken).Error; err != nil {
+		return "", nil, err
+	}
+
+	return rawToken, &refreshToken, nil
+}
+
+func revokeRefreshTokenFamily(db *gorm.DB, familyID uuid.UUID) error {
+	return db.Model(&models.RefreshToken{}).
+		Where("family_id = ? AND revoked_at IS NULL", familyID).
+		Update("revoked_at", time.Now()).Error
+}
+
+func hashToken(rawToken string) string {
+	sum := sha256.Sum256([]byte(rawToken))
+	return hex.EncodeToString(sum[:])
+}
@@ -0,0 +1,85 @@
+// utils/saml_utils.go
+package utils
+
+import (
+	"errors"
+	"strings"
+	"time"
+
+	"github.com/pramek008/go-jwt-project/database"
+	"github.com/pramek008/go-jwt-project/models"
+	"gorm.io/gorm/clause"
+)
+
+const (
+	samlRequestExpiry  = 10 * time.Minute
+	samlProviderPrefix = "saml:"
+)
+
+var (
+	ErrSAMLRequestInvalid = errors.New("invalid or expired SAML request")
+	ErrSAMLReplay         = errors.New("SAML assertion was already used")
+)
+
+// SAMLIdentityProvider is the provider stored with identities linked by a
+// SAML tenant. OIDC provider IDs cannot contain a colon, so the two never
+// collide.
+func SAMLIdentityProvider(tenant string) string {
+	return samlProviderPrefix + tenant
+}
+
+// FederatedLoginMethod is the login method of an identity's provider.
+func FederatedLoginMethod(provider string) string {
+	if strings.HasPrefix(provider, samlProviderPrefix) {
+		return LoginMethodSAML
+	}
+	return LoginMethodOIDC
+}
+
+// SaveSAMLRequest stores an AuthnRequest on its way to the identity provider
+// under the given relay state.
+func SaveSAMLRequest(relayState, tenant, requestID, deviceName string) error {
+	return database.DB.Db.Create(&models.SAMLRequest{
+		RelayStateHash: hashToken(relayState),
+		Tenant:         tenant,
+		RequestID:      requestID,
+		DeviceName:     truncate(deviceName, 255),
+		ExpiresAt:      time.Now().Add(samlRequestExpiry),
+	}).Error
+}
+
+// ConsumeSAMLRequest loads and deletes the request, so each AuthnRequest
+// can only be answered once.
+func ConsumeSAMLRequest(relayState, tenant string) (*models.SAMLRequest, error) {
+	var request models.SAMLRequest
+	if err := database.DB.Db.Where("relay_state_hash = ? AND tenant = ?", hashToken(relayState), tenant).First(&request).Error; err != nil {
+		return nil, ErrSAMLRequestInvalid
+	}
+
+	result := database.DB.Db.Delete(&request)
+	if result.Error != nil || result.RowsAffected == 0 || time.Now().After(request.ExpiresAt) {
+		return nil, ErrSAMLRequestInvalid
+	}
+
+	return &request, nil
+}
+
+// RecordSAMLAssertion marks the assertion as used, failing with
+// ErrSAMLReplay if it was used before. Expired records are dropped on the
+// way, an expired assertion is rejected by its conditions anyway.
+func RecordSAMLAssertion(tenant, assertionID string, expiresAt time.Time) error {
+	database.DB.Db.Where("expires_at < ?", time.Now()).Delete(&models.SAMLAssertion{})
+
+	result := database.DB.Db.Clauses(clause.OnConflict{DoNothing: true}).Create(&models.SAMLAssertion{
+		Tenant:      tenant,
+		AssertionID: assertionID,
+		ExpiresAt:   expiresAt,
+	})
+	if result.Error != nil {
+		return result.Error
+	}
+	if result.RowsAffected == 0 {
+		return ErrSAMLReplay
+	}
+	return nil
+}
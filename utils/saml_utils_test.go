@@ -0,0 +1,119 @@
+package utils
+
+import (
+	"context"
+	"database/sql"
+	"database/sql/driver"
+	"errors"
+	"fmt"
+	"io"
+	"strings"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/google/uuid"
+	"github.com/pramek008/go-jwt-project/database"
+	"gorm.io/driver/postgres"
+	"gorm.io/gorm"
+	gormlogger "gorm.io/gorm/logger"
+)
+
+// assertionStore is a database/sql driver that only understands the
+// statements RecordSAMLAssertion runs, with the unique index on
+// (tenant, assertion_id) that makes a replayed assertion conflict.
+type assertionStore struct {
+	mu         sync.Mutex
+	recorded   map[string]bool
+	statements []string
+}
+
+func (s *assertionStore) Connect(context.Context) (driver.Conn, error) { return &assertionConn{s}, nil }
+func (s *assertionStore) Driver() driver.Driver                        { return nil }
+
+type assertionConn struct{ store *assertionStore }
+
+func (c *assertionConn) Prepare(string) (driver.Stmt, error) { return nil, errors.New("not supported") }
+func (c *assertionConn) Close() error                        { return nil }
+func (c *assertionConn) Begin() (driver.Tx, error)           { return c, nil }
+func (c *assertionConn) Commit() error                       { return nil }
+func (c *assertionConn) Rollback() error                     { return nil }
+
+func (c *assertionConn) ExecContext(_ context.Context, query string, _ []driver.NamedValue) (driver.Result, error) {
+	c.store.mu.Lock()
+	defer c.store.mu.Unlock()
+
+	c.store.statements = append(c.store.statements, query)
+	if !strings.HasPrefix(query, `DELETE FROM "saml_assertions"`) {
+		return nil, fmt.Errorf("unexpected statement %q", query)
+	}
+	return driver.RowsAffected(0), nil
+}
+
+// QueryContext handles the insert, which returns the generated id
+func (c *assertionConn) QueryContext(_ context.Context, query string, args []driver.NamedValue) (driver.Rows, error) {
+	c.store.mu.Lock()
+	defer c.store.mu.Unlock()
+
+	c.store.statements = append(c.store.statements, query)
+	if !strings.HasPrefix(query, `INSERT INTO "saml_assertions" ("tenant","assertion_id"`) || !strings.Contains(query, "ON CONFLICT DO NOTHING") {
+		return nil, fmt.Errorf("unexpected statement %q", query)
+	}
+
+	key := fmt.Sprint(args[0].Value, "/", args[1].Value)
+	if c.store.recorded[key] {
+		return &idRows{}, nil
+	}
+	c.store.recorded[key] = true
+	return &idRows{ids: []string{uuid.NewString()}}, nil
+}
+
+type idRows struct{ ids []string }
+
+func (r *idRows) Columns() []string { return []string{"id"} }
+func (r *idRows) Close() error      { return nil }
+func (r *idRows) Next(dest []driver.Value) error {
+	if len(r.ids) == 0 {
+		return io.EOF
+	}
+	dest[0], r.ids = r.ids[0], r.ids[1:]
+	return nil
+}
+
+func useAssertionStore(t *testing.T) *assertionStore {
+	t.Helper()
+
+	store := &assertionStore{recorded: map[string]bool{}}
+	db, err := gorm.Open(postgres.New(postgres.Config{Conn: sql.OpenDB(store)}), &gorm.Config{Logger: gormlogger.Discard})
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	previous := database.DB
+	database.DB = database.Dbinstance{Db: db}
+	t.Cleanup(func() { database.DB = previous })
+	return store
+}
+
+func TestRecordSAMLAssertionRejectsReplay(t *testing.T) {
+	store := useAssertionStore(t)
+	expiresAt := time.Now().Add(5 * time.Minute)
+
+	if err := RecordSAMLAssertion("acme", "id-1", expiresAt); err != nil {
+		t.Fatalf("first use: %v", err)
+	}
+	if err := RecordSAMLAssertion("acme", "id-1", expiresAt); !errors.Is(err, ErrSAMLReplay) {
+		t.Fatalf("replay: err = %v, want ErrSAMLReplay", err)
+	}
+	if err := RecordSAMLAssertion("acme", "id-2", expiresAt); err != nil {
+		t.Fatalf("another assertion: %v", err)
+	}
+	if err := RecordSAMLAssertion("globex", "id-1", expiresAt); err != nil {
+		t.Fatalf("same ID from another tenant: %v", err)
+	}
+
+	// Expired records are dropped before every insert
+	if !strings.HasPrefix(store.statements[0], `DELETE FROM "saml_assertions" WHERE expires_at <`) {
+		t.Fatalf("first statement = %q, want the cleanup of expired records", store.statements[0])
+	}
+}
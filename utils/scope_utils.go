@@ -0,0 +1,61 @@
+// utils/scope_utils.go
+package utils
+
+import "strings"
+
+// Scopes a personal access token can be limited to. Interactive sessions are
+// not limited and carry ScopeAll.
+const (
+	ScopeAll        = "*"
+	ScopePostsRead  = "posts:read"
+	ScopePostsWrite = "posts:write"
+)
+
+// OpenID Connect scopes. They select identity claims rather than API
+// access, so only OAuth clients can request them.
+const (
+	ScopeOpenID  = "openid"
+	ScopeProfile = "profile"
+	ScopeEmail   = "email"
+)
+
+var KnownScopes = []string{ScopePostsRead, ScopePostsWrite}
+
+var OIDCScopes = []string{ScopeOpenID, ScopeProfile, ScopeEmail}
+
+func IsKnownScope(scope string) bool {
+	for _, known := range KnownScopes {
+		if scope == known {
+			return true
+		}
+	}
+	return false
+}
+
+// IsClientScope reports whether an OAuth client may be registered for the
+// scope.
+func IsClientScope(scope string) bool {
+	if IsKnownScope(scope) {
+		return true
+	}
+	for _, oidcScope := range OIDCScopes {
+		if scope == oidcScope {
+			return true
+		}
+	}
+	return false
+}
+
+// ParseScopes splits a space separated scope string.
+func ParseScopes(scopes string) []string {
+	return strings.Fields(scopes)
+}
+
+func HasScope(granted []string, required string) bool {
+	for _, scope := range granted {
+		if scope == ScopeAll || scope == required {
+			return true
+		}
+	}
+	return false
+}
@@ -0,0 +1,140 @@
+// utils/session_utils.go
+package utils
+
+import (
+	"time"
+
+	"github.com/google/uuid"
+	"github.com/pramek008/go-jwt-project/database"
+	"github.com/pramek008/go-jwt-project/models"
+	"gorm.io/gorm"
+)
+
+// Last-seen timestamps are only written once per interval to avoid an UPDATE
+// on every authenticated request.
+const sessionTouchInterval = time.Minute
+
+func CreateSession(userID uuid.UUID, deviceName, userAgent, ipAddress string) (*models.Session, error) {
+	now := time.Now()
+	session := models.Session{
+		ID:         uuid.New(),
+		UserID:     userID,
+		DeviceName: deviceName,
+		UserAgent:  userAgent,
+		IPAddress:  ipAddress,
+		LastSeenAt: now,
+		CreatedAt:  now,
+	}
+
+	if err := database.DB.Db.Create(&session).Error; err != nil {
+		return nil, err
+	}
+
+	return &session, nil
+}
+
+// FindActiveSession returns the session if it belongs to the user and has not
+// been revoked.
+func FindActiveSession(userID, sessionID uuid.UUID) (*models.Session, error) {
+	var session models.Session
+	err := database.DB.Db.Where("id = ? AND user_id = ? AND revoked_at IS NULL", sessionID, userID).First(&session).Error
+	if err != nil {
+		return nil, err
+	}
+	return &session, nil
+}
+
+func TouchSession(session *models.Session, ipAddress string) {
+	if time.Since(session.LastSeenAt) < sessionTouchInterval {
+		return
+	}
+
+	database.DB.Db.Model(session).Updates(map[string]interface{}{
+		"last_seen_at": time.Now(),
+		"ip_address":   ipAddress,
+	})
+}
+
+// RevokeSession logs a single session out by revoking it together with its
+// access and refresh tokens.
+func RevokeSession(sessionID uuid.UUID) error {
+	return database.DB.Db.Transaction(func(tx *gorm.DB) error {
+		return revokeSession(tx, sessionID)
+	})
+}
+
+// RevokeOtherSessions revokes every active session of the user except keep.
+func RevokeOtherSessions(userID, keep uuid.UUID) (int, error) {
+	revoked := 0
+	err := database.DB.Db.Transaction(func(tx *gorm.DB) error {
+		var err error
+		revoked, err = revokeOtherSessions(tx, userID, keep)
+		return err
+	})
+	return revoked, err
+}
+
+// RevokeAllSessions logs the user out on every device.
+func RevokeAllSessions(userID uuid.UUID) (int, error) {
+	return RevokeOtherSessions(userID, uuid.Nil)
+}
+
+// RevokeCredentials is RevokeOtherSessions for a changed password or email:
+// personal access tokens, pending MFA challenges and emailed links were all
+// obtained with the old credentials and go too. Email change cancel links
+// stay, they are how the owner undoes a takeover. Pass uuid.Nil as keep to
+// revoke every session.
+func RevokeCredentials(userID, keep uuid.UUID) (int, error) {
+	revoked := 0
+	err := database.DB.Db.Transaction(func(tx *gorm.DB) error {
+		var err error
+		if revoked, err = revokeOtherSessions(tx, userID, keep); err != nil {
+			return err
+		}
+
+		if err := tx.Model(&models.PersonalAccessToken{}).
+			Where("user_id = ? AND revoked_at IS NULL", userID).
+			Update("revoked_at", time.Now()).Error; err != nil {
+			return err
+		}
+		if err := tx.Where("user_id = ?", userID).Delete(&models.MFAChallenge{}).Error; err != nil {
+			return err
+		}
+		return tx.Where("user_id = ? AND purpose <> ?", userID, PurposeEmailCancel).Delete(&models.ActionToken{}).Error
+	})
+	return revoked, err
+}
+
+func revokeOtherSessions(tx *gorm.DB, userID, keep uuid.UUID) (int, error) {
+	var sessionIDs []uuid.UUID
+	if err := tx.Model(&models.Session{}).
+		Where("user_id = ? AND id <> ? AND revoked_at IS NULL", userID, keep).
+		Pluck("id", &sessionIDs).Error; err != nil {
+		return 0, err
+	}
+
+	for _, sessionID := range sessionIDs {
+		if err := revokeSession(tx, sessionID); err != nil {
+			return 0, err
+		}
+	}
+	return len(sessionIDs), nil
+}
+
+func revokeSession(tx *gorm.DB, sessionID uuid.UUID) error {
+	now := time.Now()
+
+	if err := tx.Model(&models.Session{}).
+		Where("id = ? AND revoked_at IS NULL", sessionID).
+		Update("revoked_at", now).Error; err != nil {
+		return err
+	}
+
+	if err := tx.Where("session_id = ?", sessionID).Delete(&models.Token{}).Error; err != nil {
+		return err
+	}
+
+	return tx.Model(&models.RefreshToken{}).
+		Where("session_id = ? AND revoked_at IS NULL", sessionID).
+		Update("revoked_at", now).Error
+}
@@ -0,0 +1,284 @@
+// utils/token_introspection_utils.go
+package utils
+
+import (
+	"errors"
+	"strings"
+	"time"
+
+	"github.com/golang-jwt/jwt/v5"
+	"github.com/pramek008/go-jwt-project/database"
+	"github.com/pramek008/go-jwt-project/models"
+)
+
+// Token type hints from RFC 7009 and RFC 7662
+const (
+	TokenTypeHintAccessToken  = "access_token"
+	TokenTypeHintRefreshToken = "refresh_token"
+)
+
+// TokenIntrospection is the RFC 7662 introspection response. Sid is the
+// session the token belongs to, if any.
+type TokenIntrospection struct {
+	Active    bool             `json:"active"`
+	Scope     string           `json:"scope,omitempty"`
+	ClientID  string           `json:"client_id,omitempty"`
+	Username  string           `json:"username,omitempty"`
+	TokenType string           `json:"token_type,omitempty"`
+	Exp       int64            `json:"exp,omitempty"`
+	Iat       int64            `json:"iat,omitempty"`
+	Nbf       int64            `json:"nbf,omitempty"`
+	Sub       string           `json:"sub,omitempty"`
+	Aud       jwt.ClaimStrings `json:"aud,omitempty"`
+	Iss       string           `json:"iss,omitempty"`
+	Jti       string           `json:"jti,omitempty"`
+	Sid       string           `json:"sid,omitempty"`
+}
+
+var inactiveToken = TokenIntrospection{Active: false}
+
+// IntrospectToken reports whether the token is currently accepted by this
+// API. Access tokens, refresh tokens and personal access tokens are all
+// understood, the hint only decides which is tried first.
+func IntrospectToken(rawToken, hint string) TokenIntrospection {
+	if strings.HasPrefix(rawToken, PersonalAccessTokenPrefix) {
+		return introspectPersonalAccessToken(rawToken)
+	}
+
+	if hint == TokenTypeHintRefreshToken {
+		if result := introspectRefreshToken(rawToken); result.Active {
+			return result
+		}
+		return introspectAccessToken(rawToken)
+	}
+
+	if result := introspectAccessToken(rawToken); result.Active {
+		return result
+	}
+	return introspectRefreshToken(rawToken)
+}
+
+func introspectAccessToken(rawToken string) TokenIntrospection {
+	claims, err := ExtractClaimsFromToken(rawToken)
+	if err != nil {
+		return inactiveToken
+	}
+	if IsClientToken(claims) {
+		return introspectClientToken(claims)
+	}
+
+	var storedToken models.Token
+	if err := database.DB.Db.Where("jti = ? AND user_id = ?", claims.ID, claims.UserID).First(&storedToken).Error; err != nil {
+		return inactiveToken
+	}
+	if storedToken.SessionID == nil {
+		return inactiveToken
+	}
+	if _, err := FindActiveSession(claims.UserID, *storedToken.SessionID); err != nil {
+		return inactiveToken
+	}
+
+	user, ok := activeUser(claims.UserID.String())
+	if !ok {
+		return inactiveToken
+	}
+
+	result := TokenIntrospection{
+		Active:    true,
+		Scope:     ScopeAll,
+		Username:  user.Nickname,
+		TokenType: "Bearer",
+		Sub:       claims.UserID.String(),
+		Aud:       claims.Audience,
+		Iss:       claims.Issuer,
+		Jti:       claims.ID,
+		Sid:       storedToken.SessionID.String(),
+	}
+	if storedToken.ClientID != "" {
+		result.ClientID = storedToken.ClientID
+		result.Scope = storedToken.Scope
+	}
+	setClaimTimes(&result, claims)
+	return result
+}
+
+// introspectClientToken describes a client_credentials token, whose subject
+// is the client itself.
+func introspectClientToken(claims *Claims) TokenIntrospection {
+	clientToken, err := FindActiveClientToken(claims)
+	if err != nil {
+		return inactiveToken
+	}
+
+	result := TokenIntrospection{
+		Active:    true,
+		Scope:     clientToken.Scope,
+		ClientID:  clientToken.ClientID,
+		TokenType: "Bearer",
+		Sub:       clientToken.ClientID,
+		Aud:       claims.Audience,
+		Iss:       claims.Issuer,
+		Jti:       claims.ID,
+	}
+	setClaimTimes(&result, claims)
+	return result
+}
+
+func setClaimTimes(result *TokenIntrospection, claims *Claims) {
+	if claims.ExpiresAt != nil {
+		result.Exp = claims.ExpiresAt.Unix()
+	}
+	if claims.IssuedAt != nil {
+		result.Iat = claims.IssuedAt.Unix()
+	}
+	if claims.NotBefore != nil {
+		result.Nbf = claims.NotBefore.Unix()
+	}
+}
+
+func introspectRefreshToken(rawToken string) TokenIntrospection {
+	var stored models.RefreshToken
+	err := database.DB.Db.Where("token_hash = ? AND revoked_at IS NULL AND expires_at > ?", hashToken(rawToken), time.Now()).First(&stored).Error
+	if err != nil || stored.SessionID == nil {
+		return inactiveToken
+	}
+	session, err := FindActiveSession(stored.UserID, *stored.SessionID)
+	if err != nil {
+		return inactiveToken
+	}
+
+	user, ok := activeUser(stored.UserID.String())
+	if !ok {
+		return inactiveToken
+	}
+
+	scope := ScopeAll
+	if session.ClientID != "" {
+		scope = session.Scope
+	}
+
+	return TokenIntrospection{
+		Active:    true,
+		Scope:     scope,
+		ClientID:  session.ClientID,
+		Username:  user.Nickname,
+		TokenType: TokenTypeHintRefreshToken,
+		Exp:       stored.ExpiresAt.Unix(),
+		Iat:       stored.CreatedAt.Unix(),
+		Sub:       stored.UserID.String(),
+		Iss:       jwtIssuer(),
+		Sid:       stored.SessionID.String(),
+	}
+}
+
+func introspectPersonalAccessToken(rawToken string) TokenIntrospection {
+	var token models.PersonalAccessToken
+	err := database.DB.Db.Where("token_hash = ? AND revoked_at IS NULL AND expires_at > ?", hashToken(rawToken), time.Now()).First(&token).Error
+	if err != nil {
+		return inactiveToken
+	}
+
+	user, ok := activeUser(token.UserID.String())
+	if !ok {
+		return inactiveToken
+	}
+
+	return TokenIntrospection{
+		Active:    true,
+		Scope:     token.Scopes,
+		Username:  user.Nickname,
+		TokenType: "Bearer",
+		Exp:       token.ExpiresAt.Unix(),
+		Iat:       token.CreatedAt.Unix(),
+		Sub:       token.UserID.String(),
+		Iss:       jwtIssuer(),
+		Jti:       token.ID.String(),
+	}
+}
+
+func activeUser(userID string) (models.User, bool) {
+	var user models.User
+	if err := database.DB.Db.First(&user, "id = ?", userID).Error; err != nil {
+		return user, false
+	}
+	return user, !user.IsSuspended(time.Now()) && user.DeletionScheduledAt == nil
+}
+
+// ErrTokenNotIssuedToClient is returned when a client tries to revoke a
+// token that belongs to another client, or to no client at all.
+var ErrTokenNotIssuedToClient = errors.New("token was not issued to this client")
+
+// RevokeToken implements RFC 7009 for the given client. Revoking a refresh
+// token ends its whole session, including the access tokens issued with it.
+// Unknown or already invalid tokens are not an error, but tokens of other
+// clients are refused with ErrTokenNotIssuedToClient.
+func RevokeToken(rawToken, hint, clientID string) error {
+	if strings.HasPrefix(rawToken, PersonalAccessTokenPrefix) {
+		var count int64
+		if err := database.DB.Db.Model(&models.PersonalAccessToken{}).
+			Where("token_hash = ? AND revoked_at IS NULL", hashToken(rawToken)).
+			Count(&count).Error; err != nil {
+			return err
+		}
+		if count > 0 {
+			return ErrTokenNotIssuedToClient
+		}
+		return nil
+	}
+
+	if hint == TokenTypeHintRefreshToken {
+		if found, err := revokeRefreshTokenString(rawToken, clientID); found || err != nil {
+			return err
+		}
+		_, err := revokeAccessTokenString(rawToken, clientID)
+		return err
+	}
+
+	if found, err := revokeAccessTokenString(rawToken, clientID); found || err != nil {
+		return err
+	}
+	_, err := revokeRefreshTokenString(rawToken, clientID)
+	return err
+}
+
+func revokeAccessTokenString(rawToken, clientID string) (bool, error) {
+	claims, err := ExtractClaimsFromToken(rawToken)
+	if err != nil {
+		return false, nil
+	}
+
+	if IsClientToken(claims) {
+		if claims.ClientID != clientID {
+			return true, ErrTokenNotIssuedToClient
+		}
+		result := database.DB.Db.Model(&models.OAuthClientToken{}).
+			Where("id = ? AND client_id = ? AND revoked_at IS NULL", claims.ID, clientID).
+			Update("revoked_at", time.Now())
+		return result.RowsAffected > 0, result.Error
+	}
+
+	var storedToken models.Token
+	if err := database.DB.Db.Where("jti = ?", claims.ID).First(&storedToken).Error; err != nil {
+		return false, nil
+	}
+	if storedToken.ClientID != clientID {
+		return true, ErrTokenNotIssuedToClient
+	}
+
+	return true, database.DB.Db.Where("jti = ?", claims.ID).Delete(&models.Token{}).Error
+}
+
+func revokeRefreshTokenString(rawToken, clientID string) (bool, error) {
+	var stored models.RefreshToken
+	if err := database.DB.Db.Where("token_hash = ?", hashToken(rawToken)).First(&stored).Error; err != nil {
+		return false, nil
+	}
+
+	// Only sessions created for a client hold its refresh tokens
+	var session models.Session
+	if stored.SessionID == nil || database.DB.Db.First(&session, "id = ?", *stored.SessionID).Error != nil || session.ClientID != clientID {
+		return true, ErrTokenNotIssuedToClient
+	}
+
+	return true, RevokeSession(session.ID)
+}
@@ -0,0 +1,205 @@
+// utils/totp_utils.go
+package utils
+
+import (
+	"bytes"
+	"crypto/rand"
+	"crypto/subtle"
+	"encoding/base32"
+	"errors"
+	"image/png"
+	"os"
+	"strings"
+	"time"
+
+	"github.com/google/uuid"
+	"github.com/pquerna/otp"
+	"github.com/pquerna/otp/totp"
+	"github.com/pramek008/go-jwt-project/database"
+	"github.com/pramek008/go-jwt-project/models"
+	"gorm.io/gorm"
+)
+
+const (
+	totpPeriod         = 30
+	totpSkewSteps      = 1
+	recoveryCodeCount  = 10
+	mfaChallengeExpiry = 5 * time.Minute
+	mfaMaxAttempts     = 5
+)
+
+var ErrMFAChallengeInvalid = errors.New("invalid or expired MFA challenge")
+
+// GenerateTOTPKey creates a new random secret for the account. The returned
+// key renders both the otpauth:// URI and the QR code.
+func GenerateTOTPKey(accountName string) (*otp.Key, error) {
+	issuer := os.Getenv("TOTP_ISSUER")
+	if issuer == "" {
+		issuer = "go-jwt-project"
+	}
+
+	return totp.Generate(totp.GenerateOpts{
+		Issuer:      issuer,
+		AccountName: accountName,
+		Period:      totpPeriod,
+		Algorithm:   otp.AlgorithmSHA1,
+		Digits:      otp.DigitsSix,
+	})
+}
+
+func TOTPQRCodePNG(key *otp.Key) ([]byte, error) {
+	img, err := key.Image(256, 256)
+	if err != nil {
+		return nil, err
+	}
+
+	var buffer bytes.Buffer
+	if err := png.Encode(&buffer, img); err != nil {
+		return nil, err
+	}
+	return buffer.Bytes(), nil
+}
+
+// ValidateTOTPCode accepts codes from the current time step and one step
+// either side of it. A step can only be used once, so an observed code cannot
+// be replayed.
+func ValidateTOTPCode(factor *models.TOTPFactor, code string) bool {
+	now := time.Now().Unix() / totpPeriod
+
+	for step := now - totpSkewSteps; step <= now+totpSkewSteps; step++ {
+		if step <= factor.LastUsedStep {
+			continue
+		}
+
+		expected, err := totp.GenerateCodeCustom(factor.Secret, time.Unix(step*totpPeriod, 0), totp.ValidateOpts{
+			Period:    totpPeriod,
+			Digits:    otp.DigitsSix,
+			Algorithm: otp.AlgorithmSHA1,
+		})
+		if err != nil {
+			return false
+		}
+
+		if subtle.ConstantTimeCompare([]byte(expected), []byte(code)) == 1 {
+			// Only one request can claim a step
+			result := database.DB.Db.Model(&models.TOTPFactor{}).
+				Where("id = ? AND last_used_step < ?", factor.ID, step).
+				Update("last_used_step", step)
+			if result.Error != nil || result.RowsAffected == 0 {
+				return false
+			}
+			factor.LastUsedStep = step
+			return true
+		}
+	}
+
+	return false
+}
+
+// FindConfirmedTOTPFactor returns the user's active authenticator, if any.
+func FindConfirmedTOTPFactor(userID uuid.UUID) (*models.TOTPFactor, error) {
+	var factor models.TOTPFactor
+	if err := database.DB.Db.Where("user_id = ? AND confirmed_at IS NOT NULL", userID).First(&factor).Error; err != nil {
+		return nil, err
+	}
+	return &factor, nil
+}
+
+func UserHasMFA(userID uuid.UUID) bool {
+	_, err := FindConfirmedTOTPFactor(userID)
+	return err == nil
+}
+
+// GenerateRecoveryCodes replaces any existing recovery codes of the user and
+// returns the new ones in plaintext. They are never shown again.
+func GenerateRecoveryCodes(db *gorm.DB, userID uuid.UUID) ([]string, error) {
+	if err := db.Where("user_id = ?", userID).Delete(&models.RecoveryCode{}).Error; err != nil {
+		return nil, err
+	}
+
+	codes := make([]string, 0, recoveryCodeCount)
+	for i := 0; i < recoveryCodeCount; i++ {
+		buffer := make([]byte, 7)
+		if _, err := rand.Read(buffer); err != nil {
+			return nil, err
+		}
+		encoded := strings.ToLower(base32.StdEncoding.WithPadding(base32.NoPadding).EncodeToString(buffer))[:10]
+		code := encoded[:5] + "-" + encoded[5:]
+
+		record := models.RecoveryCode{
+			UserID:   userID,
+			CodeHash: hashToken(normalizeRecoveryCode(code)),
+		}
+		if err := db.Create(&record).Error; err != nil {
+			return nil, err
+		}
+		codes = append(codes, code)
+	}
+
+	return codes, nil
+}
+
+// UseRecoveryCode consumes a recovery code. It returns false if the code is
+// unknown or was already used.
+func UseRecoveryCode(userID uuid.UUID, code string) bool {
+	result := database.DB.Db.Model(&models.RecoveryCode{}).
+		Where("user_id = ? AND code_hash = ? AND used_at IS NULL", userID, hashToken(normalizeRecoveryCode(code))).
+		Update("used_at", time.Now())
+	return result.Error == nil && result.RowsAffected == 1
+}
+
+// CreateMFAChallenge stores the pending login and returns the challenge token
+// the client exchanges, together with a code, for a session.
+func CreateMFAChallenge(userID uuid.UUID, deviceName string) (string, error) {
+	challenge := models.MFAChallenge{
+		ID:         uuid.New(),
+		UserID:     userID,
+		DeviceName: deviceName,
+		ExpiresAt:  time.Now().Add(mfaChallengeExpiry),
+	}
+	if err := database.DB.Db.Create(&challenge).Error; err != nil {
+		return "", err
+	}
+
+	return GeneratePurposeToken(userID, PurposeMFAPending, challenge.ID, challenge.ExpiresAt)
+}
+
+// StartMFAChallengeAttempt validates the challenge token and counts one code
+// attempt against it. Challenges are dropped after too many wrong codes.
+func StartMFAChallengeAttempt(tokenString string) (*models.MFAChallenge, error) {
+	claims, err := ExtractPurposeClaims(tokenString, PurposeMFAPending)
+	if err != nil {
+		return nil, ErrMFAChallengeInvalid
+	}
+
+	var challenge models.MFAChallenge
+	err = database.DB.Db.Where("id = ? AND user_id = ? AND used_at IS NULL AND expires_at > ?", claims.ID, claims.UserID, time.Now()).First(&challenge).Error
+	if err != nil || challenge.Attempts >= mfaMaxAttempts {
+		return nil, ErrMFAChallengeInvalid
+	}
+
+	result := database.DB.Db.Model(&models.MFAChallenge{}).
+		Where("id = ? AND attempts < ?", challenge.ID, mfaMaxAttempts).
+		Update("attempts", gorm.Expr("attempts + 1"))
+	if result.Error != nil || result.RowsAffected == 0 {
+		return nil, ErrMFAChallengeInvalid
+	}
+
+	return &challenge, nil
+}
+
+// CompleteMFAChallenge marks the challenge as used so its token cannot start
+// a second session.
+func CompleteMFAChallenge(challenge *models.MFAChallenge) bool {
+	result := database.DB.Db.Model(&models.MFAChallenge{}).
+		Where("id = ? AND used_at IS NULL", challenge.ID).
+		Update("used_at", time.Now())
+	return result.Error == nil && result.RowsAffected == 1
+}
+
+// normalizeRecoveryCode accepts a code typed with or without the hyphen and
+// spaces it is displayed with.
+func normalizeRecoveryCode(code string) string {
+	code = strings.ToLower(strings.TrimSpace(code))
+	return strings.NewReplacer(" ", "", "-", "").Replace(code)
+}
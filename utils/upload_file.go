@@ -5,23 +5,26 @@ import (
 	"mime/multipart"
 	"os"
 	"path/filepath"
+	"strings"
 
 	"github.com/gin-gonic/gin"
 	"github.com/google/uuid"
 )
 
+// UploadDir is where uploaded files are stored, served under /uploads.
+const UploadDir = "/usr/src/app/uploads"
+
 func UploadFile(c *gin.Context, file *multipart.FileHeader) (string, error) {
 	// Generate a unique filename
 	filename := uuid.New().String() + filepath.Ext(file.Filename)
 
 	// Ensure the upload directory exists
-	uploadDir := "/usr/src/app/uploads"
-	if err := os.MkdirAll(uploadDir, os.ModePerm); err != nil {
+	if err := os.MkdirAll(UploadDir, os.ModePerm); err != nil {
 		return "", fmt.Errorf("failed to create upload directory: %w", err)
 	}
 
 	// Save the file
-	dst := filepath.Join(uploadDir, filename)
+	dst := filepath.Join(UploadDir, filename)
 	if err := c.SaveUploadedFile(file, dst); err != nil {
 		return "", fmt.Errorf("failed to save file: %w", err)
 	}
@@ -35,3 +38,18 @@ func UploadFile(c *gin.Context, file *multipart.FileHeader) (string, error) {
 	// Return the full URL
 	return fmt.Sprintf("%s/uploads/%s", baseURL, filename), nil
 }
+
+// UploadPathFromURL maps a URL returned by UploadFile back to the file on
+// disk. Only the base name is used so a crafted URL cannot leave UploadDir.
+func UploadPathFromURL(fileURL string) (string, bool) {
+	_, filename, found := strings.Cut(fileURL, "/uploads/")
+	if !found || filename == "" {
+		return "", false
+	}
+
+	filename = filepath.Base(filename)
+	if filename == "." || filename == ".." || filename == "/" {
+		return "", false
+	}
+	return filepath.Join(UploadDir, filename), true
+}
@@ -0,0 +1,267 @@
+// utils/user_identity_utils.go
+package utils
+
+import (
+	"crypto/rand"
+	"encoding/hex"
+	"errors"
+	"regexp"
+	"strings"
+	"time"
+
+	"github.com/google/uuid"
+	"github.com/pramek008/go-jwt-project/database"
+	"github.com/pramek008/go-jwt-project/federation"
+	"github.com/pramek008/go-jwt-project/models"
+	"gorm.io/gorm"
+	"gorm.io/gorm/clause"
+)
+
+const (
+	oidcLoginStateExpiry      = 10 * time.Minute
+	IdentityLinkRequestExpiry = 15 * time.Minute
+
+	maxNicknameLength = 32
+)
+
+var (
+	ErrOIDCLoginStateInvalid  = errors.New("invalid or expired federated login state")
+	ErrIdentityLinkInvalid    = errors.New("invalid or expired identity link request")
+	ErrIdentityAlreadyLinked  = errors.New("identity is already linked to an account")
+	ErrFederatedEmailUnusable = errors.New("provider did not return a verified email")
+	ErrFederatedEmailInUse    = errors.New("email belongs to an existing account")
+	errNoAvailableNickname    = errors.New("no available nickname")
+)
+
+var (
+	nicknameDisallowedPattern = regexp.MustCompile(`[^A-Za-z0-9._-]+`)
+	nicknameSeparatorPattern  = regexp.MustCompile(`[._-]{2,}`)
+)
+
+// SaveOIDCLoginState stores the nonce and PKCE verifier of a login that is
+// being redirected to the provider and returns the state to send along.
+func SaveOIDCLoginState(provider, nonce, codeVerifier, redirectURL, deviceName string) (string, error) {
+	state, err := GenerateNonce()
+	if err != nil {
+		return "", err
+	}
+
+	record := models.OIDCLoginState{
+		StateHash:    hashToken(state),
+		Provider:     provider,
+		Nonce:        nonce,
+		CodeVerifier: codeVerifier,
+		RedirectURL:  redirectURL,
+		DeviceName:   truncate(deviceName, 255),
+		ExpiresAt:    time.Now().Add(oidcLoginStateExpiry),
+	}
+	if err := database.DB.Db.Create(&record).Error; err != nil {
+		return "", err
+	}
+
+	return state, nil
+}
+
+// ConsumeOIDCLoginState loads and deletes the login state, so a callback can
+// only be completed once.
+func ConsumeOIDCLoginState(state, provider string) (*models.OIDCLoginState, error) {
+	var record models.OIDCLoginState
+	if err := database.DB.Db.Where("state_hash = ? AND provider = ?", hashToken(state), provider).First(&record).Error; err != nil {
+		return nil, ErrOIDCLoginStateInvalid
+	}
+
+	result := database.DB.Db.Delete(&record)
+	if result.Error != nil || result.RowsAffected == 0 || time.Now().After(record.ExpiresAt) {
+		return nil, ErrOIDCLoginStateInvalid
+	}
+
+	return &record, nil
+}
+
+// FindUserIdentity returns the linked identity for the provider's subject and
+// records the login on it.
+func FindUserIdentity(provider string, identity *federation.Identity) (*models.UserIdentity, error) {
+	var userIdentity models.UserIdentity
+	if err := database.DB.Db.Where("provider = ? AND subject = ?", provider, identity.Subject).First(&userIdentity).Error; err != nil {
+		return nil, err
+	}
+
+	now := time.Now()
+	database.DB.Db.Model(&userIdentity).Updates(map[string]interface{}{
+		"last_login_at": now,
+		"email":         truncate(identity.Email, 100),
+	})
+	return &userIdentity, nil
+}
+
+// CreateFederatedUser provisions an account for a first-time federated login.
+// The provider must vouch for the email, and it must not belong to an
+// account already; unconfirmed registrations for it are dropped. The user
+// gets a random password and can set one with the forgot password flow.
+func CreateFederatedUser(provider string, identity *federation.Identity) (*models.User, error) {
+	if identity.Email == "" || !identity.EmailVerified {
+		return nil, ErrFederatedEmailUnusable
+	}
+
+	password, err := GenerateNonce()
+	if err != nil {
+		return nil, err
+	}
+	hashedPassword, err := HashPassword(password)
+	if err != nil {
+		return nil, err
+	}
+
+	var user models.User
+	err = database.DB.Db.Transaction(func(tx *gorm.DB) error {
+		var count int64
+		if err := tx.Model(&models.User{}).Unscoped().Where("LOWER(email) = LOWER(?)", identity.Email).Count(&count).Error; err != nil {
+			return err
+		}
+		if count > 0 {
+			return ErrFederatedEmailInUse
+		}
+
+		if err := tx.Where("LOWER(email) = LOWER(?)", identity.Email).Delete(&models.TempUser{}).Error; err != nil {
+			return err
+		}
+
+		nickname, err := availableNickname(tx, identity)
+		if err != nil {
+			return err
+		}
+
+		user = models.User{
+			Nickname: nickname,
+			Email:    identity.Email,
+			Password: hashedPassword,
+		}
+		if err := tx.Create(&user).Error; err != nil {
+			return err
+		}
+		if err := database.AssignRole(tx, user.ID, models.RoleUser); err != nil {
+			return err
+		}
+
+		return createUserIdentity(tx, user.ID, provider, identity.Subject, identity.Email)
+	})
+	if err != nil {
+		return nil, err
+	}
+
+	return &user, nil
+}
+
+// CreateIdentityLinkRequest replaces the user's pending link request and
+// returns the link token that confirms it, bound to the browser by nonce.
+func CreateIdentityLinkRequest(userID uuid.UUID, provider string, identity *federation.Identity, deviceName, nonce string) (string, error) {
+	linkToken, linkTokenID, err := createActionToken(userID, PurposeIdentityLink, nonce, IdentityLinkRequestExpiry)
+	if err != nil {
+		return "", err
+	}
+
+	request := models.IdentityLinkRequest{
+		UserID:      userID,
+		LinkTokenID: linkTokenID,
+		Provider:    provider,
+		Subject:     identity.Subject,
+		Email:       identity.Email,
+		DeviceName:  truncate(deviceName, 255),
+		ExpiresAt:   time.Now().Add(IdentityLinkRequestExpiry),
+	}
+
+	err = database.DB.Db.Clauses(clause.OnConflict{
+		Columns:   []clause.Column{{Name: "user_id"}},
+		DoUpdates: clause.AssignmentColumns([]string{"link_token_id", "provider", "subject", "email", "device_name", "expires_at", "created_at"}),
+	}).Create(&request).Error
+	if err != nil {
+		return "", err
+	}
+
+	return linkToken, nil
+}
+
+// ConfirmIdentityLink links the identity of the pending request created
+// along with the consumed link token. A request made after that token was
+// issued is not linked by it.
+func ConfirmIdentityLink(userID, linkTokenID uuid.UUID) (*models.IdentityLinkRequest, error) {
+	var request models.IdentityLinkRequest
+	err := database.DB.Db.Transaction(func(tx *gorm.DB) error {
+		if err := tx.Clauses(clause.Locking{Strength: "UPDATE"}).
+			Where("user_id = ? AND link_token_id = ?", userID, linkTokenID).
+			First(&request).Error; err != nil {
+			return ErrIdentityLinkInvalid
+		}
+
+		if err := tx.Delete(&request).Error; err != nil {
+			return err
+		}
+		if time.Now().After(request.ExpiresAt) {
+			return ErrIdentityLinkInvalid
+		}
+
+		return createUserIdentity(tx, userID, request.Provider, request.Subject, request.Email)
+	})
+	if err != nil {
+		return nil, err
+	}
+
+	return &request, nil
+}
+
+func createUserIdentity(tx *gorm.DB, userID uuid.UUID, provider, subject, email string) error {
+	var count int64
+	if err := tx.Model(&models.UserIdentity{}).Where("provider = ? AND subject = ?", provider, subject).Count(&count).Error; err != nil {
+		return err
+	}
+	if count > 0 {
+		return ErrIdentityAlreadyLinked
+	}
+
+	now := time.Now()
+	return tx.Create(&models.UserIdentity{
+		UserID:      userID,
+		Provider:    provider,
+		Subject:     subject,
+		Email:       truncate(email, 100),
+		LastLoginAt: &now,
+	}).Error
+}
+
+// availableNickname derives a nickname from what the provider knows about
+// the user, adding a random suffix when it is taken.
+func availableNickname(tx *gorm.DB, identity *federation.Identity) (string, error) {
+	base := identity.PreferredUsername
+	if base == "" {
+		base = identity.Name
+	}
+	if base == "" {
+		base, _, _ = strings.Cut(identity.Email, "@")
+	}
+
+	base = nicknameDisallowedPattern.ReplaceAllString(strings.TrimSpace(base), "_")
+	base = nicknameSeparatorPattern.ReplaceAllString(base, "_")
+	base = strings.Trim(truncate(base, maxNicknameLength-5), "._-")
+	if base == "" {
+		base = "user"
+	}
+
+	candidate := base
+	for attempt := 0; attempt < 5; attempt++ {
+		var count int64
+		if err := tx.Model(&models.User{}).Unscoped().Where("LOWER(nickname) = LOWER(?)", candidate).Count(&count).Error; err != nil {
+			return "", err
+		}
+		if count == 0 && !IsReservedNickname(candidate) {
+			return candidate, nil
+		}
+
+		suffix := make([]byte, 2)
+		if _, err := rand.Read(suffix); err != nil {
+			return "", err
+		}
+		candidate = base + "-" + hex.EncodeToString(suffix)
+	}
+
+	return "", errNoAvailableNickname
+}
@@ -0,0 +1,21 @@
+// utils/user_utils.go
+package utils
+
+import (
+	"time"
+
+	"github.com/google/uuid"
+	"github.com/pramek008/go-jwt-project/database"
+	"github.com/pramek008/go-jwt-project/models"
+)
+
+// IsUserActive reports whether the user still exists and is not suspended.
+// Tokens of inactive users are rejected by the auth middleware.
+func IsUserActive(userID uuid.UUID) bool {
+	var user models.User
+	err := database.DB.Db.Select("id", "suspended_at", "suspended_until").First(&user, "id = ?", userID).Error
+	if err != nil {
+		return false
+	}
+	return !user.IsSuspended(time.Now())
+}
@@ -0,0 +1,217 @@
+// utils/webauthn_utils.go
+package utils
+
+import (
+	"encoding/json"
+	"errors"
+	"os"
+	"strings"
+	"time"
+
+	"github.com/go-webauthn/webauthn/protocol"
+	"github.com/go-webauthn/webauthn/webauthn"
+	"github.com/google/uuid"
+	"github.com/pramek008/go-jwt-project/database"
+	"github.com/pramek008/go-jwt-project/models"
+)
+
+const (
+	WebAuthnCeremonyRegistration = "registration"
+	WebAuthnCeremonyLogin        = "login"
+
+	webAuthnSessionExpiry = 5 * time.Minute
+)
+
+var ErrWebAuthnSessionInvalid = errors.New("invalid or expired webauthn session")
+
+// NewWebAuthn builds the relying party from WEBAUTHN_RP_ID (the domain),
+// WEBAUTHN_RP_NAME and the comma separated WEBAUTHN_RP_ORIGINS.
+func NewWebAuthn() (*webauthn.WebAuthn, error) {
+	rpID := os.Getenv("WEBAUTHN_RP_ID")
+	if rpID == "" {
+		rpID = "localhost"
+	}
+
+	rpName := os.Getenv("WEBAUTHN_RP_NAME")
+	if rpName == "" {
+		rpName = "go-jwt-project"
+	}
+
+	var origins []string
+	for _, origin := range strings.Split(os.Getenv("WEBAUTHN_RP_ORIGINS"), ",") {
+		if origin = strings.TrimSpace(origin); origin != "" {
+			origins = append(origins, origin)
+		}
+	}
+	if len(origins) == 0 {
+		origins = []string{"http://localhost:1500"}
+	}
+
+	return webauthn.New(&webauthn.Config{
+		RPID:          rpID,
+		RPDisplayName: rpName,
+		RPOrigins:     origins,
+		AuthenticatorSelection: protocol.AuthenticatorSelection{
+			ResidentKey:      protocol.ResidentKeyRequirementPreferred,
+			UserVerification: protocol.VerificationPreferred,
+		},
+		Timeouts: webauthn.TimeoutsConfig{
+			Login:        webauthn.TimeoutConfig{Enforce: true, Timeout: webAuthnSessionExpiry, TimeoutUVD: webAuthnSessionExpiry},
+			Registration: webauthn.TimeoutConfig{Enforce: true, Timeout: webAuthnSessionExpiry, TimeoutUVD: webAuthnSessionExpiry},
+		},
+	})
+}
+
+// WebAuthnUser adapts models.User to the webauthn.User interface. The user
+// handle is the user's UUID, which carries no personal data.
+type WebAuthnUser struct {
+	User        models.User
+	Credentials []models.WebAuthnCredential
+}
+
+func (u *WebAuthnUser) WebAuthnID() []byte {
+	return u.User.ID[:]
+}
+
+func (u *WebAuthnUser) WebAuthnName() string {
+	return u.User.Email
+}
+
+func (u *WebAuthnUser) WebAuthnDisplayName() string {
+	return u.User.Nickname
+}
+
+func (u *WebAuthnUser) WebAuthnIcon() string {
+	return ""
+}
+
+func (u *WebAuthnUser) WebAuthnCredentials() []webauthn.Credential {
+	credentials := make([]webauthn.Credential, 0, len(u.Credentials))
+	for _, credential := range u.Credentials {
+		credentials = append(credentials, CredentialFromModel(credential))
+	}
+	return credentials
+}
+
+// CredentialExclusions lists the user's credentials so an authenticator is not
+// registered twice.
+func (u *WebAuthnUser) CredentialExclusions() []protocol.CredentialDescriptor {
+	exclusions := []protocol.CredentialDescriptor{}
+	for _, credential := range u.WebAuthnCredentials() {
+		exclusions = append(exclusions, credential.Descriptor())
+	}
+	return exclusions
+}
+
+func LoadWebAuthnUser(userID uuid.UUID) (*WebAuthnUser, error) {
+	var user models.User
+	if err := database.DB.Db.Where("id = ?", userID).First(&user).Error; err != nil {
+		return nil, err
+	}
+
+	var credentials []models.WebAuthnCredential
+	if err := database.DB.Db.Where("user_id = ?", userID).Find(&credentials).Error; err != nil {
+		return nil, err
+	}
+
+	return &WebAuthnUser{User: user, Credentials: credentials}, nil
+}
+
+// LoadWebAuthnUserByHandle resolves the user handle returned by a passkey
+// during a discoverable login.
+func LoadWebAuthnUserByHandle(userHandle []byte) (*WebAuthnUser, error) {
+	userID, err := uuid.FromBytes(userHandle)
+	if err != nil {
+		return nil, err
+	}
+	return LoadWebAuthnUser(userID)
+}
+
+func CredentialFromModel(credential models.WebAuthnCredential) webauthn.Credential {
+	var transports []protocol.AuthenticatorTransport
+	for _, transport := range strings.Split(credential.Transports, ",") {
+		if transport != "" {
+			transports = append(transports, protocol.AuthenticatorTransport(transport))
+		}
+	}
+
+	return webauthn.Credential{
+		ID:              credential.CredentialID,
+		PublicKey:       credential.PublicKey,
+		AttestationType: credential.AttestationType,
+		Transport:       transports,
+		Flags: webauthn.CredentialFlags{
+			BackupEligible: credential.BackupEligible,
+			BackupState:    credential.BackupState,
+		},
+		Authenticator: webauthn.Authenticator{
+			AAGUID:       credential.AAGUID,
+			SignCount:    uint32(credential.SignCount),
+			CloneWarning: credential.CloneWarning,
+		},
+	}
+}
+
+func CredentialToModel(userID uuid.UUID, name string, credential *webauthn.Credential) models.WebAuthnCredential {
+	transports := make([]string, 0, len(credential.Transport))
+	for _, transport := range credential.Transport {
+		transports = append(transports, string(transport))
+	}
+
+	return models.WebAuthnCredential{
+		UserID:          userID,
+		Name:            name,
+		CredentialID:    credential.ID,
+		PublicKey:       credential.PublicKey,
+		AttestationType: credential.AttestationType,
+		Transports:      strings.Join(transports, ","),
+		AAGUID:          credential.Authenticator.AAGUID,
+		SignCount:       int64(credential.Authenticator.SignCount),
+		CloneWarning:    credential.Authenticator.CloneWarning,
+		BackupEligible:  credential.Flags.BackupEligible,
+		BackupState:     credential.Flags.BackupState,
+	}
+}
+
+// SaveWebAuthnSession stores the ceremony state and returns the id the client
+// sends back with its finish request.
+func SaveWebAuthnSession(userID *uuid.UUID, ceremony string, session *webauthn.SessionData) (uuid.UUID, error) {
+	data, err := json.Marshal(session)
+	if err != nil {
+		return uuid.Nil, err
+	}
+
+	record := models.WebAuthnSession{
+		ID:        uuid.New(),
+		UserID:    userID,
+		Ceremony:  ceremony,
+		Data:      string(data),
+		ExpiresAt: time.Now().Add(webAuthnSessionExpiry),
+	}
+	if err := database.DB.Db.Create(&record).Error; err != nil {
+		return uuid.Nil, err
+	}
+
+	return record.ID, nil
+}
+
+// ConsumeWebAuthnSession loads and deletes the ceremony state, so every
+// challenge can be answered only once.
+func ConsumeWebAuthnSession(id uuid.UUID, ceremony string) (*models.WebAuthnSession, *webauthn.SessionData, error) {
+	var record models.WebAuthnSession
+	if err := database.DB.Db.Where("id = ? AND ceremony = ?", id, ceremony).First(&record).Error; err != nil {
+		return nil, nil, ErrWebAuthnSessionInvalid
+	}
+
+	result := database.DB.Db.Delete(&record)
+	if result.Error != nil || result.RowsAffected == 0 || time.Now().After(record.ExpiresAt) {
+		return nil, nil, ErrWebAuthnSessionInvalid
+	}
+
+	var session webauthn.SessionData
+	if err := json.Unmarshal([]byte(record.Data), &session); err != nil {
+		return nil, nil, err
+	}
+
+	return &record, &session, nil
+}
@@ -0,0 +1,312 @@
+package utils
+
+import (
+	"bytes"
+	"crypto/ecdsa"
+	"crypto/elliptic"
+	"crypto/rand"
+	"crypto/sha256"
+	"encoding/base64"
+	"encoding/binary"
+	"encoding/json"
+	"testing"
+
+	"github.com/fxamacker/cbor/v2"
+	"github.com/go-webauthn/webauthn/protocol"
+	"github.com/go-webauthn/webauthn/webauthn"
+	"github.com/google/uuid"
+	"github.com/pramek008/go-jwt-project/models"
+)
+
+const (
+	testRPID   = "localhost"
+	testOrigin = "http://localhost:1500"
+)
+
+// softwareAuthenticator is a minimal platform authenticator: it holds one
+// P-256 credential, attests with the "none" format and signs assertions.
+type softwareAuthenticator struct {
+	credentialID []byte
+	key          *ecdsa.PrivateKey
+	userHandle   []byte
+	signCount    uint32
+}
+
+func newSoftwareAuthenticator(t *testing.T) *softwareAuthenticator {
+	t.Helper()
+
+	key, err := ecdsa.GenerateKey(elliptic.P256(), rand.Reader)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	credentialID := make([]byte, 32)
+	if _, err := rand.Read(credentialID); err != nil {
+		t.Fatal(err)
+	}
+
+	return &softwareAuthenticator{credentialID: credentialID, key: key}
+}
+
+func (a *softwareAuthenticator) authenticatorData(flags byte, attestedCredential []byte) []byte {
+	rpIDHash := sha256.Sum256([]byte(testRPID))
+
+	data := append([]byte{}, rpIDHash[:]...)
+	data = append(data, flags)
+	data = binary.BigEndian.AppendUint32(data, a.signCount)
+	return append(data, attestedCredential...)
+}
+
+func (a *softwareAuthenticator) create(t *testing.T, options *protocol.CredentialCreation) []byte {
+	t.Helper()
+
+	a.userHandle = options.Response.User.ID.(protocol.URLEncodedBase64)
+
+	coseKey, err := cbor.Marshal(map[int]interface{}{
+		1:  2,  // kty: EC2
+		3:  -7, // alg: ES256
+		-1: 1,  // crv: P-256
+		-2: a.key.X.FillBytes(make([]byte, 32)),
+		-3: a.key.Y.FillBytes(make([]byte, 32)),
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	attested := make([]byte, 16) // zero AAGUID
+	attested = binary.BigEndian.AppendUint16(attested, uint16(len(a.credentialID)))
+	attested = append(attested, a.credentialID...)
+	attested = append(attested, coseKey...)
+
+	// Flags: user present, user verified, attested credential data
+	authData := a.authenticatorData(0x45, attested)
+
+	attestationObject, err := cbor.Marshal(map[string]interface{}{
+		"fmt":      "none",
+		"attStmt":  map[string]interface{}{},
+		"authData": authData,
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	clientData := a.clientData(t, "webauthn.create", options.Response.Challenge)
+
+	return a.marshal(t, map[string]interface{}{
+		"clientDataJSON":    encode(clientData),
+		"attestationObject": encode(attestationObject),
+		"transports":        []string{"internal"},
+	})
+}
+
+func (a *softwareAuthenticator) get(t *testing.T, options *protocol.CredentialAssertion) []byte {
+	t.Helper()
+
+	a.signCount++
+	// Flags: user present, user verified
+	authData := a.authenticatorData(0x05, nil)
+	clientData := a.clientData(t, "webauthn.get", options.Response.Challenge)
+
+	clientDataHash := sha256.Sum256(clientData)
+	digest := sha256.Sum256(append(append([]byte{}, authData...), clientDataHash[:]...))
+	signature, err := ecdsa.SignASN1(rand.Reader, a.key, digest[:])
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	return a.marshal(t, map[string]interface{}{
+		"clientDataJSON":    encode(clientData),
+		"authenticatorData": encode(authData),
+		"signature":         encode(signature),
+		"userHandle":        encode(a.userHandle),
+	})
+}
+
+func (a *softwareAuthenticator) clientData(t *testing.T, ceremony string, challenge []byte) []byte {
+	t.Helper()
+
+	clientData, err := json.Marshal(map[string]string{
+		"type":      ceremony,
+		"challenge": encode(challenge),
+		"origin":    testOrigin,
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+	return clientData
+}
+
+func (a *softwareAuthenticator) marshal(t *testing.T, response map[string]interface{}) []byte {
+	t.Helper()
+
+	body, err := json.Marshal(map[string]interface{}{
+		"id":       encode(a.credentialID),
+		"rawId":    encode(a.credentialID),
+		"type":     "public-key",
+		"response": response,
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+	return body
+}
+
+func encode(data []byte) string {
+	return base64.RawURLEncoding.EncodeToString(data)
+}
+
+func newTestRelyingParty(t *testing.T) *webauthn.WebAuthn {
+	t.Helper()
+
+	t.Setenv("WEBAUTHN_RP_ID", testRPID)
+	t.Setenv("WEBAUTHN_RP_ORIGINS", testOrigin)
+
+	relyingParty, err := NewWebAuthn()
+	if err != nil {
+		t.Fatal(err)
+	}
+	return relyingParty
+}
+
+// registerTestCredential runs a registration ceremony and stores the result
+// on the user the same way the controller does.
+func registerTestCredential(t *testing.T, relyingParty *webauthn.WebAuthn, user *WebAuthnUser, authenticator *softwareAuthenticator) {
+	t.Helper()
+
+	options, session, err := relyingParty.BeginRegistration(user, webauthn.WithExclusions(user.CredentialExclusions()))
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	parsed, err := protocol.ParseCredentialCreationResponseBody(bytes.NewReader(authenticator.create(t, options)))
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	credential, err := relyingParty.CreateCredential(user, *session, parsed)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	user.Credentials = append(user.Credentials, CredentialToModel(user.User.ID, "test key", credential))
+}
+
+func newTestWebAuthnUser() *WebAuthnUser {
+	return &WebAuthnUser{User: models.User{ID: uuid.New(), Nickname: "passkey", Email: "passkey@example.com"}}
+}
+
+func TestWebAuthnRegistrationAndLogin(t *testing.T) {
+	relyingParty := newTestRelyingParty(t)
+	user := newTestWebAuthnUser()
+	authenticator := newSoftwareAuthenticator(t)
+
+	registerTestCredential(t, relyingParty, user, authenticator)
+
+	stored := user.Credentials[0]
+	if !bytes.Equal(stored.CredentialID, authenticator.credentialID) {
+		t.Fatalf("stored credential id %x, want %x", stored.CredentialID, authenticator.credentialID)
+	}
+	if stored.Transports != "internal" {
+		t.Fatalf("stored transports %q, want %q", stored.Transports, "internal")
+	}
+
+	options, session, err := relyingParty.BeginLogin(user)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	parsed, err := protocol.ParseCredentialRequestResponseBody(bytes.NewReader(authenticator.get(t, options)))
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	credential, err := relyingParty.ValidateLogin(user, *session, parsed)
+	if err != nil {
+		t.Fatalf("login failed: %v", err)
+	}
+	if credential.Authenticator.SignCount != 1 || credential.Authenticator.CloneWarning {
+		t.Fatalf("sign count %d, clone warning %v", credential.Authenticator.SignCount, credential.Authenticator.CloneWarning)
+	}
+}
+
+func TestWebAuthnDiscoverableLogin(t *testing.T) {
+	relyingParty := newTestRelyingParty(t)
+	user := newTestWebAuthnUser()
+	authenticator := newSoftwareAuthenticator(t)
+
+	registerTestCredential(t, relyingParty, user, authenticator)
+
+	options, session, err := relyingParty.BeginDiscoverableLogin()
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	parsed, err := protocol.ParseCredentialRequestResponseBody(bytes.NewReader(authenticator.get(t, options)))
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	_, err = relyingParty.ValidateDiscoverableLogin(func(rawID, userHandle []byte) (webauthn.User, error) {
+		userID, err := uuid.FromBytes(userHandle)
+		if err != nil || userID != user.User.ID {
+			t.Fatalf("unexpected user handle %x", userHandle)
+		}
+		return user, nil
+	}, *session, parsed)
+	if err != nil {
+		t.Fatalf("discoverable login failed: %v", err)
+	}
+}
+
+func TestWebAuthnLoginRejectsWrongChallenge(t *testing.T) {
+	relyingParty := newTestRelyingParty(t)
+	user := newTestWebAuthnUser()
+	authenticator := newSoftwareAuthenticator(t)
+
+	registerTestCredential(t, relyingParty, user, authenticator)
+
+	options, _, err := relyingParty.BeginLogin(user)
+	if err != nil {
+		t.Fatal(err)
+	}
+	_, otherSession, err := relyingParty.BeginLogin(user)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	parsed, err := protocol.ParseCredentialRequestResponseBody(bytes.NewReader(authenticator.get(t, options)))
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if _, err := relyingParty.ValidateLogin(user, *otherSession, parsed); err == nil {
+		t.Fatal("assertion for another challenge was accepted")
+	}
+}
+
+func TestWebAuthnLoginFlagsClonedAuthenticator(t *testing.T) {
+	relyingParty := newTestRelyingParty(t)
+	user := newTestWebAuthnUser()
+	authenticator := newSoftwareAuthenticator(t)
+
+	registerTestCredential(t, relyingParty, user, authenticator)
+	user.Credentials[0].SignCount = 5
+
+	options, session, err := relyingParty.BeginLogin(user)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	parsed, err := protocol.ParseCredentialRequestResponseBody(bytes.NewReader(authenticator.get(t, options)))
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	credential, err := relyingParty.ValidateLogin(user, *session, parsed)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !credential.Authenticator.CloneWarning {
+		t.Fatal("expected a clone warning for a sign count that went backwards")
+	}
+}